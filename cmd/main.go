@@ -1,24 +1,66 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/cyclingwithelephants/kubediff/internal/argocd"
+	"github.com/cyclingwithelephants/kubediff/internal/capacity"
+	"github.com/cyclingwithelephants/kubediff/internal/deprecation"
 	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/flux"
 	"github.com/cyclingwithelephants/kubediff/internal/gh"
+	"github.com/cyclingwithelephants/kubediff/internal/images"
+	"github.com/cyclingwithelephants/kubediff/internal/kube"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/network"
+	"github.com/cyclingwithelephants/kubediff/internal/offline"
+	"github.com/cyclingwithelephants/kubediff/internal/policy"
+	"github.com/cyclingwithelephants/kubediff/internal/rbac"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/risk"
+	"github.com/cyclingwithelephants/kubediff/internal/rollout"
+	"github.com/cyclingwithelephants/kubediff/internal/schema"
+	"github.com/cyclingwithelephants/kubediff/internal/sops"
+	"github.com/cyclingwithelephants/kubediff/internal/tanka"
 	"github.com/cyclingwithelephants/kubediff/internal/utils"
 	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// The sources of truth kubediff can discover apps from
+const (
+	appSourceDirectory = "directory"
+	appSourceArgoCD    = "argocd"
+	appSourceFlux      = "flux"
+	appSourceTanka     = "tanka"
 )
 
 type Config struct {
+	appSource             string
 	prDir                 string
 	targetDir             string
 	envsDir               string
 	globLevels            int
 	renderedYamlWriteRoot string
 	tempPath              string
+	renderCacheDir        string
+	kustomizeMode         string
+	kustomizeFlags        yaml.KustomizeFlags
+	toolVersions          map[string]string
+	toolsDir              string
+	buildTimeout          time.Duration
+	offline               bool
+	helmChartMirror       string
+	buildCommandsFile     string
 	renderedCommentPath   string
 	githubOwner           string
 	githubRepo            string
@@ -26,17 +68,64 @@ type Config struct {
 	githubToken           string
 	diffWithColour        bool
 	diffContextLines      int
+	maskHashSuffixes      bool
+	normaliseApps         []string
+	kubeconfigPath        string
+	liveKubeContexts      map[string]string
+	dryRunKubeContexts    map[string]string
+	schemaDir             string
+	kubernetesVersion     string
+	crdDirs               []string
+	failOnSchemaErrors    bool
+	kubernetesVersions    map[string]string
+	deprecationsFile      string
+	policyDir             string
+	failOnPolicyErrors    bool
+	destructiveKinds      []string
+	immutableFields       []string
+	predictRollouts       bool
+	summariseImages       bool
+	registryAllowlist     []string
+	analyseRBAC           bool
+	analyseCapacity       bool
+	reportNetwork         bool
+	redactSecrets         bool
+	sopsAgeKeyFile        string
+	appOrder              string
+	environmentPriority   []string
+	argocdAppsDir         string
+	argocdRepoURLs        []string
+	fluxDir               string
+	fluxChartsDir         string
+	fluxGitRepositories   []string
+	tankaDir              string
 }
 
 type Tool struct {
-	config          Config
-	logger          *log.Logger
-	differ          Differ
-	renderer        TemplateRenderer
-	appFinder       AppFinder
-	yamlBuilder     YamlBuilder
-	chunker         Chunker
-	githubCommenter GithubCommenter
+	config           Config
+	logger           *log.Logger
+	differ           Differ
+	renderer         TemplateRenderer
+	appFinder        AppFinder
+	yamlBuilder      YamlBuilder
+	runner           Runner
+	chartResolver    ChartResolver
+	normaliser       Normaliser
+	liveFetcher      LiveFetcher
+	dryRunner        DryRunner
+	schemaValidator  SchemaValidator
+	deprecations     DeprecationChecker
+	policies         PolicyChecker
+	riskClassifier   RiskClassifier
+	rolloutPredictor RolloutPredictor
+	imageSummariser  ImageSummariser
+	rbacAnalyser     RBACAnalyser
+	capacityAnalyser CapacityAnalyser
+	networkReporter  NetworkReporter
+	secretRedactor   SecretRedactor
+	orderer          Orderer
+	chunker          Chunker
+	githubCommenter  GithubCommenter
 }
 
 type Differ interface {
@@ -52,77 +141,367 @@ type AppFinder interface {
 	GetAllAppPaths() (utils.Set, error)
 }
 
+type Runner interface {
+	Pin(ctx context.Context, required map[string]string, toolsDir string) error
+	Versions(ctx context.Context) []yaml.ToolVersion
+	AppFlags() map[string][]string
+	Stats() yaml.RenderStats
+}
+
+type ChartResolver interface {
+	Resolve(dirs ...string) error
+}
+
 type YamlBuilder interface {
-	Build(path string) (yaml.BuiltYaml, error)
+	Build(ctx context.Context, path string) (yaml.BuiltYaml, error)
+}
+
+type Normaliser interface {
+	Normalise(builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, error)
+}
+
+type LiveFetcher interface {
+	Fetch(ctx context.Context, builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, bool, error)
+}
+
+type DryRunner interface {
+	DryRun(ctx context.Context, builtYaml yaml.BuiltYaml) (kube.DryRunResult, bool, error)
+}
+
+type SchemaValidator interface {
+	Validate(builtYamls []yaml.BuiltYaml) (schema.Result, error)
+}
+
+type DeprecationChecker interface {
+	Check(builtYamls []yaml.BuiltYaml) (deprecation.Result, error)
+}
+
+type PolicyChecker interface {
+	Check(builtYamls []yaml.BuiltYaml) (policy.Result, error)
+}
+
+type RiskClassifier interface {
+	Classify(builtYamls []yaml.BuiltYaml) (risk.Result, error)
+}
+
+type RolloutPredictor interface {
+	Predict(builtYamls []yaml.BuiltYaml) (rollout.Result, error)
+}
+
+type ImageSummariser interface {
+	Summarise(builtYamls []yaml.BuiltYaml) (images.Result, error)
+}
+
+type RBACAnalyser interface {
+	Analyse(builtYamls []yaml.BuiltYaml) (rbac.Result, error)
+}
+
+type CapacityAnalyser interface {
+	Analyse(builtYamls []yaml.BuiltYaml) (capacity.Result, error)
+}
+
+type SecretRedactor interface {
+	Redact(builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, []sops.Change, error)
+}
+
+type NetworkReporter interface {
+	Report(builtYamls []yaml.BuiltYaml) (network.Result, error)
+}
+
+type Orderer interface {
+	Sort(diffs []file.Diff)
 }
 
 type Chunker interface {
 	Chunk(diff string) (chunks []string)
+	Pack(pieces []string) (chunks []string)
 }
 
 type GithubCommenter interface {
-	DeleteAllToolComments() error
-	Comment(comments []string) error
+	DeleteAllToolComments(ctx context.Context) error
+	Comment(ctx context.Context, comments []string) error
 }
 
 func New() Tool {
 	logger := log.Default()
 	config := newConfig()
-	differ := file.NewRealDiffer(logger, config.diffContextLines, config.diffWithColour)
+	differ := file.NewRealDiffer(logger, config.diffContextLines, config.diffWithColour, config.maskHashSuffixes)
+	var renderCache *yaml.Cache
+	if config.renderCacheDir != "" {
+		renderCache = yaml.NewCache(config.renderCacheDir, logger)
+	}
+	runner := yaml.NewRunner(filesys.MakeFsOnDisk(), config.kustomizeMode, config.kustomizeFlags, renderCache, logger)
 	logger.Println("creating server with config:", fmt.Sprintf("%+v", config))
-	return Tool{
-		config:   config,
-		logger:   logger,
-		differ:   differ,
-		renderer: file.NewTemplateRenderer(),
-		appFinder: file.NewAppFinder(
+	tool := Tool{
+		config:         config,
+		logger:         logger,
+		differ:         differ,
+		runner:         runner,
+		renderer:       file.NewTemplateRenderer(),
+		normaliser:     manifest.NewNormaliser(config.normaliseApps, logger),
+		riskClassifier: risk.NewClassifier(config.destructiveKinds, config.immutableFields, logger),
+		orderer:        file.NewOrderer(config.appOrder, config.environmentPriority),
+		chunker:        utils.NewChunker(gh.MaxCommentLength),
+		githubCommenter: gh.NewCommenter(
+			config.githubOwner,
+			config.githubRepo,
+			config.githubPrNumber,
+			config.githubToken,
+			logger,
+		),
+	}
+
+	// without network access, charts come from a mirror and remote resources are refused
+	if config.offline {
+		tool.chartResolver = offline.NewResolver(config.helmChartMirror, logger)
+	}
+
+	// diffing against and dry running on live clusters are optional
+	if len(config.liveKubeContexts) > 0 {
+		clusters := kube.NewClusters(config.kubeconfigPath, config.liveKubeContexts)
+		tool.liveFetcher = kube.NewLiveFetcher(clusters, logger)
+	}
+	if len(config.dryRunKubeContexts) > 0 {
+		clusters := kube.NewClusters(config.kubeconfigPath, config.dryRunKubeContexts)
+		tool.dryRunner = kube.NewDryRunner(clusters, logger)
+	}
+
+	if config.schemaDir != "" {
+		crdDirs := []string{}
+		for _, crdDir := range config.crdDirs {
+			crdDirs = append(crdDirs, filepath.Join(config.prDir, crdDir))
+		}
+		tool.schemaValidator = schema.NewValidator(config.schemaDir, config.kubernetesVersion, crdDirs, logger)
+	}
+
+	if config.kubernetesVersion != "" || len(config.kubernetesVersions) > 0 {
+		tool.deprecations = deprecation.NewChecker(config.deprecationsFile, config.kubernetesVersions, config.kubernetesVersion, logger)
+	}
+
+	if config.predictRollouts {
+		tool.rolloutPredictor = rollout.NewPredictor(logger)
+	}
+
+	if config.summariseImages {
+		tool.imageSummariser = images.NewSummariser(config.registryAllowlist, logger)
+	}
+
+	if config.analyseRBAC {
+		tool.rbacAnalyser = rbac.NewAnalyser(logger)
+	}
+
+	if config.analyseCapacity {
+		tool.capacityAnalyser = capacity.NewAnalyser(logger)
+	}
+
+	if config.reportNetwork {
+		tool.networkReporter = network.NewReporter(logger)
+	}
+
+	// the key itself is read here rather than kept in the config, which is logged
+	if config.redactSecrets {
+		identities, err := sops.LoadIdentities(config.sopsAgeKeyFile, utils.DefaultEnv("SOPS_AGE_KEY", ""))
+		if err != nil {
+			log.Fatalf("error loading SOPS age keys: %v", err)
+		}
+		tool.secretRedactor = sops.NewRedactor(identities, logger)
+	}
+
+	// policies come from the target branch, so that a PR can't loosen the rules it is checked against
+	if config.policyDir != "" {
+		tool.policies = policy.NewChecker(filepath.Join(config.targetDir, config.policyDir), logger)
+	}
+
+	switch config.appSource {
+	case appSourceArgoCD:
+		appFinder := argocd.NewAppFinder(
+			config.prDir,
+			config.targetDir,
+			config.argocdAppsDir,
+			config.argocdRepoURLs,
+			logger,
+		)
+		tool.appFinder = appFinder
+		tool.yamlBuilder = argocd.NewBuilder(
+			appFinder,
+			config.prDir,
+			config.targetDir,
+			runner,
+			logger,
+		)
+	case appSourceFlux:
+		appFinder := flux.NewAppFinder(
+			config.prDir,
+			config.targetDir,
+			config.fluxDir,
+			logger,
+		)
+		tool.appFinder = appFinder
+		tool.yamlBuilder = flux.NewBuilder(
+			appFinder,
+			config.prDir,
+			config.targetDir,
+			config.fluxChartsDir,
+			config.fluxGitRepositories,
+			runner,
+			logger,
+		)
+	case appSourceTanka:
+		tool.appFinder = tanka.NewAppFinder(
+			config.prDir,
+			config.targetDir,
+			config.tankaDir,
+			logger,
+		)
+		tool.yamlBuilder = tanka.NewBuilder(
+			config.prDir,
+			config.targetDir,
+			config.tankaDir,
+			runner,
+			logger,
+		)
+	default:
+		tool.appFinder = file.NewAppFinder(
 			config.prDir,
 			config.targetDir,
 			config.envsDir,
 			config.globLevels,
 			logger,
-		),
-		yamlBuilder: yaml.NewBuilder(
+		)
+		// build commands come from the target branch, so that those a PR is rendered with have been reviewed
+		commands := []yaml.Command{}
+		if config.buildCommandsFile != "" {
+			var err error
+			commands, err = yaml.LoadCommands(filepath.Join(config.targetDir, config.buildCommandsFile))
+			if err != nil {
+				log.Fatalf("error loading build commands: %v", err)
+			}
+		}
+		tool.yamlBuilder = yaml.NewBuilder(
 			config.prDir,
 			config.targetDir,
 			config.envsDir,
 			config.renderedYamlWriteRoot,
+			commands,
+			runner,
 			logger,
-		),
-		chunker: utils.NewChunker(gh.MaxCommentLength),
-		githubCommenter: gh.NewCommenter(
-			config.githubOwner,
-			config.githubRepo,
-			config.githubPrNumber,
-			config.githubToken,
-			logger,
-		),
+		)
 	}
+	return tool
 }
 
 func newConfig() Config {
-	return Config{
+	config := Config{
+		appSource:             utils.DefaultEnv("APP_SOURCE", appSourceDirectory),
 		prDir:                 utils.DefaultEnv("PR_BRANCH_DIR", "pr"),
 		targetDir:             utils.DefaultEnv("TARGET_BRANCH_DIR", "target"),
-		envsDir:               utils.MustGetEnv("ENVS_DIR"),
-		globLevels:            utils.AsInt(utils.MustGetEnv("GLOB_LEVELS")),
 		renderedYamlWriteRoot: utils.DefaultEnv("RENDERED_WRITE_PATH", "rendered"),
 		tempPath:              utils.DefaultEnv("TEMP_PATH", "tmp"),
-		renderedCommentPath:   utils.DefaultEnv("TEMP_PATH", "tmp"),
-		githubOwner:           utils.MustGetEnv("GITHUB_OWNER"),
-		githubRepo:            utils.MustGetEnv("GITHUB_REPO"),
-		githubPrNumber:        utils.AsInt(utils.MustGetEnv("GITHUB_PR_NUMBER")),
-		githubToken:           utils.MustGetEnv("GITHUB_TOKEN"),
-		diffWithColour:        utils.AsBool(utils.DefaultEnv("DIFF_WITH_COLOUR", "true")),
-		diffContextLines:      utils.AsInt(utils.DefaultEnv("DIFF_CONTEXT_LINES", "3")),
+		renderCacheDir:        utils.DefaultEnv("RENDER_CACHE_DIR", ""),
+		kustomizeMode:         utils.DefaultEnv("KUSTOMIZE_MODE", yaml.KustomizeLibrary),
+		kustomizeFlags: yaml.KustomizeFlags{
+			Global: strings.Fields(utils.DefaultEnv("KUSTOMIZE_BUILD_FLAGS", strings.Join(yaml.DefaultKustomizeFlags, " "))),
+		},
+		toolVersions:        map[string]string{},
+		toolsDir:            utils.DefaultEnv("TOOLS_DIR", ""),
+		buildTimeout:        utils.AsDuration(utils.DefaultEnv("BUILD_TIMEOUT", "10m")),
+		offline:             utils.AsBool(utils.DefaultEnv("OFFLINE", "false")),
+		helmChartMirror:     utils.DefaultEnv("HELM_CHART_MIRROR", ""),
+		buildCommandsFile:   utils.DefaultEnv("BUILD_COMMANDS_FILE", ""),
+		renderedCommentPath: utils.DefaultEnv("TEMP_PATH", "tmp"),
+		githubOwner:         utils.MustGetEnv("GITHUB_OWNER"),
+		githubRepo:          utils.MustGetEnv("GITHUB_REPO"),
+		githubPrNumber:      utils.AsInt(utils.MustGetEnv("GITHUB_PR_NUMBER")),
+		githubToken:         utils.MustGetEnv("GITHUB_TOKEN"),
+		diffWithColour:      utils.AsBool(utils.DefaultEnv("DIFF_WITH_COLOUR", "true")),
+		diffContextLines:    utils.AsInt(utils.DefaultEnv("DIFF_CONTEXT_LINES", "3")),
+		maskHashSuffixes:    utils.AsBool(utils.DefaultEnv("IGNORE_HASH_SUFFIXES", "true")),
+		normaliseApps:       utils.AsList(utils.DefaultEnv("NORMALISE_YAML_APPS", "")),
+		kubeconfigPath:      utils.DefaultEnv("KUBECONFIG_PATH", kube.DefaultKubeconfigPath()),
+		liveKubeContexts:    utils.AsMap(utils.DefaultEnv("LIVE_KUBE_CONTEXTS", "")),
+		dryRunKubeContexts:  utils.AsMap(utils.DefaultEnv("DRY_RUN_KUBE_CONTEXTS", "")),
+		schemaDir:           utils.DefaultEnv("SCHEMA_DIR", ""),
+		kubernetesVersion:   utils.DefaultEnv("KUBERNETES_VERSION", ""),
+		crdDirs:             utils.AsList(utils.DefaultEnv("CRD_DIRS", "")),
+		failOnSchemaErrors:  utils.AsBool(utils.DefaultEnv("FAIL_ON_SCHEMA_ERRORS", "false")),
+		kubernetesVersions:  utils.AsMap(utils.DefaultEnv("KUBERNETES_VERSIONS", "")),
+		deprecationsFile:    utils.DefaultEnv("DEPRECATIONS_FILE", ""),
+		policyDir:           utils.DefaultEnv("POLICY_DIR", ""),
+		failOnPolicyErrors:  utils.AsBool(utils.DefaultEnv("FAIL_ON_POLICY_ERRORS", "false")),
+		destructiveKinds:    utils.AsList(utils.DefaultEnv("DESTRUCTIVE_KINDS", strings.Join(risk.DefaultKinds, ","))),
+		immutableFields:     utils.AsList(utils.DefaultEnv("IMMUTABLE_FIELDS", strings.Join(risk.DefaultImmutableFields, ","))),
+		predictRollouts:     utils.AsBool(utils.DefaultEnv("PREDICT_ROLLOUTS", "true")),
+		summariseImages:     utils.AsBool(utils.DefaultEnv("SUMMARISE_IMAGES", "true")),
+		registryAllowlist:   utils.AsList(utils.DefaultEnv("IMAGE_REGISTRY_ALLOWLIST", "")),
+		analyseRBAC:         utils.AsBool(utils.DefaultEnv("ANALYSE_RBAC", "true")),
+		analyseCapacity:     utils.AsBool(utils.DefaultEnv("ANALYSE_CAPACITY", "true")),
+		reportNetwork:       utils.AsBool(utils.DefaultEnv("REPORT_NETWORK", "true")),
+		redactSecrets:       utils.AsBool(utils.DefaultEnv("REDACT_SOPS_SECRETS", "true")),
+		sopsAgeKeyFile:      utils.DefaultEnv("SOPS_AGE_KEY_FILE", ""),
+		appOrder:            utils.DefaultEnv("APP_ORDER", file.OrderAlphabetical),
+		environmentPriority: utils.AsList(utils.DefaultEnv("ENVIRONMENT_PRIORITY", "")),
 	}
+
+	switch config.appOrder {
+	case file.OrderAlphabetical, file.OrderDiffSize, file.OrderEnvironmentPriority:
+	default:
+		log.Fatalf("APP_ORDER must be one of %s, %s, %s: got %s", file.OrderAlphabetical, file.OrderDiffSize, file.OrderEnvironmentPriority, config.appOrder)
+	}
+
+	perAppFlags, err := yaml.ParseAppFlags(utils.DefaultEnv("KUSTOMIZE_BUILD_FLAGS_PER_APP", ""))
+	if err != nil {
+		log.Fatalf("KUSTOMIZE_BUILD_FLAGS_PER_APP is invalid: %v", err)
+	}
+	config.kustomizeFlags.PerApp = perAppFlags
+
+	// only tools given a version are pinned
+	for tool, variable := range map[string]string{"kustomize": "KUSTOMIZE_VERSION", "helm": "HELM_VERSION"} {
+		if version := utils.DefaultEnv(variable, ""); version != "" {
+			config.toolVersions[tool] = version
+		}
+	}
+
+	// each app source needs its own settings to find apps
+	switch config.appSource {
+	case appSourceDirectory:
+		config.envsDir = utils.MustGetEnv("ENVS_DIR")
+		config.globLevels = utils.AsInt(utils.MustGetEnv("GLOB_LEVELS"))
+	case appSourceArgoCD:
+		config.argocdAppsDir = utils.MustGetEnv("ARGOCD_APPS_DIR")
+		config.argocdRepoURLs = utils.AsList(utils.DefaultEnv("ARGOCD_REPO_URLS", ""))
+	case appSourceFlux:
+		config.fluxDir = utils.MustGetEnv("FLUX_DIR")
+		config.fluxChartsDir = utils.DefaultEnv("FLUX_CHARTS_DIR", "")
+		config.fluxGitRepositories = utils.AsList(utils.DefaultEnv("FLUX_GIT_REPOSITORIES", ""))
+	case appSourceTanka:
+		config.tankaDir = utils.MustGetEnv("TANKA_DIR")
+	default:
+		log.Fatalf("APP_SOURCE must be one of %s, %s, %s, %s: got %s", appSourceDirectory, appSourceArgoCD, appSourceFlux, appSourceTanka, config.appSource)
+	}
+	return config
 }
 
-func (S Tool) RunToCompletion() error {
+func (S Tool) RunToCompletion(ctx context.Context) error {
+	// check the tools before anything else, as renders with the wrong versions are misleading
+	err := S.runner.Pin(ctx, S.config.toolVersions, S.config.toolsDir)
+	if err != nil {
+		S.logger.Println("error checking tool versions:", err)
+		return err
+	}
+
+	// without network access, check every remote dependency can be met before building anything
+	if S.chartResolver != nil {
+		err = S.chartResolver.Resolve(S.offlineDirs()...)
+		if err != nil {
+			S.logger.Println("error resolving charts offline:", err)
+			return err
+		}
+	}
+
 	// clean up old comments
 	// we do this first ti reduce likelihood of confusion with the new comments
 	S.logger.Println("begin deleting all old comments")
-	err := S.githubCommenter.DeleteAllToolComments()
+	err = S.githubCommenter.DeleteAllToolComments(ctx)
 	if err != nil {
 		S.logger.Println("error deleting old comments:", err)
 		return err
@@ -135,42 +514,204 @@ func (S Tool) RunToCompletion() error {
 		return err
 	}
 
-	diffPaths := make(map[string]struct{})
-	for eachApp := range allApps {
-		dir1 := filepath.Join(S.config.prDir, S.config.envsDir, eachApp)
-		dir2 := filepath.Join(S.config.targetDir, S.config.envsDir, eachApp)
-		hasDiff, reason, err := S.differ.HasDiff(dir1, dir2)
+	diffPaths := allApps
+	// only the directory source can tell which apps changed from their inputs alone,
+	// other sources are filtered after rendering
+	if S.config.appSource == appSourceDirectory {
+		diffPaths, err = S.changedDirectoryApps(allApps)
 		if err != nil {
-			S.logger.Println("error checking if diff exists:", err)
 			return err
 		}
-		if hasDiff {
-			diffPaths[eachApp] = struct{}{}
-			S.logger.Println("diff found between branches for app: ", eachApp, "reason:", reason)
+	}
+
+	// render the yaml for each diffPath
+	renderStart := time.Now()
+	builtYamls := []yaml.BuiltYaml{}
+	failedRenders := []yaml.FailedRender{}
+	secretChanges := sops.Result{}
+	for _, diffPath := range diffPaths.Sorted(file.LessAppPath) {
+		S.logger.Println("building yaml for path:", diffPath)
+		// each app's builds share its timeout, so one slow app can't hold up the rest
+		appCtx, cancel := yaml.BuildContext(ctx, S.config.buildTimeout)
+		builtYaml, err := S.yamlBuilder.Build(appCtx, diffPath)
+		cancel()
+		if ctx.Err() != nil {
+			S.logger.Println("interrupted while building yaml for path:", diffPath)
+			return ctx.Err()
+		}
+		// a build that times out or whose command fails is reported on the PR, without stopping the others
+		if yaml.IsRenderFailure(err) {
+			S.logger.Printf("building yaml for path %s failed: %s", diffPath, err)
+			failedRenders = append(failedRenders, yaml.FailedRender{AppPath: diffPath, Err: err})
+			continue
 		}
+		if err != nil {
+			return err
+		}
+		builtYaml, err = S.normaliser.Normalise(builtYaml)
+		if err != nil {
+			return err
+		}
+		// ciphertext changes on every edit, so encrypted values are compared before the diffs are
+		if S.secretRedactor != nil {
+			var changes []sops.Change
+			builtYaml, changes, err = S.secretRedactor.Redact(builtYaml)
+			if err != nil {
+				S.logger.Println("error redacting encrypted secrets:", err)
+				return err
+			}
+			secretChanges.Changes = append(secretChanges.Changes, changes...)
+		}
+		if builtYaml.YamlPrBranch == builtYaml.YamlTargetBranch {
+			S.logger.Println("rendered yaml is identical between branches for app:", diffPath)
+			continue
+		}
+		builtYamls = append(builtYamls, builtYaml)
 	}
-	// at each path, if the directory above has a kustomization.yaml, remove it from the list
-	for diffPath := range diffPaths {
-		fullPath := filepath.Join(S.config.prDir, S.config.envsDir, diffPath, "..", "kustomization.yaml")
-		exists, err := utils.FileExists(fullPath)
+	stats := S.runner.Stats()
+	S.logger.Printf(
+		"built %d apps in %s: %d renders took %s, %d render cache hits, %d render cache misses",
+		len(diffPaths), time.Since(renderStart), stats.Renders, stats.Duration, stats.CacheHits, stats.CacheMisses,
+	)
+
+	// sections of the report posted ahead of the diffs
+	sections := []report.Section{}
+
+	// find changes that destroy resources, to warn about them above everything else
+	risks, err := S.riskClassifier.Classify(builtYamls)
+	if err != nil {
+		S.logger.Println("error classifying changes:", err)
+		return err
+	}
+
+	// checks that fail the run once the report is posted, so the PR shows why
+	failures := []string{}
+
+	if len(failedRenders) > 0 {
+		failures = append(failures, fmt.Sprintf("%d apps failed to render", len(failedRenders)))
+		sections = append(sections, yaml.FailedRendersSection(failedRenders))
+	}
+
+	// check the PR branch against the schemas of its kinds, without needing a cluster
+	if S.schemaValidator != nil {
+		result, err := S.schemaValidator.Validate(builtYamls)
 		if err != nil {
-			S.logger.Println("error checking if file exists:", err)
+			S.logger.Println("error validating schemas:", err)
 			return err
 		}
-		if exists {
-			delete(diffPaths, diffPath)
+		if result.Failed() && S.config.failOnSchemaErrors {
+			failures = append(failures, "rendered resources don't match their schemas")
 		}
+		sections = append(sections, result.Section())
 	}
 
-	// render the yaml for each diffPath
-	builtYamls := []yaml.BuiltYaml{}
-	for diffPath := range diffPaths {
-		S.logger.Println("building yaml for path:", diffPath)
-		builtYaml, err := S.yamlBuilder.Build(diffPath)
+	// check added and modified resources against the house rules
+	if S.policies != nil {
+		result, err := S.policies.Check(builtYamls)
 		if err != nil {
+			S.logger.Println("error checking policies:", err)
 			return err
 		}
-		builtYamls = append(builtYamls, builtYaml)
+		if result.Failed() && S.config.failOnPolicyErrors {
+			failures = append(failures, "rendered resources violate policies")
+		}
+		sections = append(sections, result.Section())
+	}
+
+	// find APIs that are or will be unavailable in each environment's cluster
+	if S.deprecations != nil {
+		result, err := S.deprecations.Check(builtYamls)
+		if err != nil {
+			S.logger.Println("error checking for deprecated APIs:", err)
+			return err
+		}
+		if len(result.Usages) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// permission changes are security sensitive, so are shown per subject rather than per object
+	if S.rbacAnalyser != nil {
+		result, err := S.rbacAnalyser.Analyse(builtYamls)
+		if err != nil {
+			S.logger.Println("error analysing RBAC:", err)
+			return err
+		}
+		if len(result.Grants) > 0 || len(result.UnknownBindings) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// image bumps are reviewable from a summary alone
+	if S.imageSummariser != nil {
+		result, err := S.imageSummariser.Summarise(builtYamls)
+		if err != nil {
+			S.logger.Println("error summarising images:", err)
+			return err
+		}
+		if len(result.Changes) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// tell reviewers which workloads will restart their pods
+	if S.rolloutPredictor != nil {
+		result, err := S.rolloutPredictor.Predict(builtYamls)
+		if err != nil {
+			S.logger.Println("error predicting rollouts:", err)
+			return err
+		}
+		if len(result.Rollouts) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// summarise how much more or less of the cluster the change asks for
+	if S.capacityAnalyser != nil {
+		result, err := S.capacityAnalyser.Analyse(builtYamls)
+		if err != nil {
+			S.logger.Println("error analysing capacity:", err)
+			return err
+		}
+		if len(result.Deltas) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// list what becomes reachable, or stops being reachable, from outside a workload
+	if S.networkReporter != nil {
+		result, err := S.networkReporter.Report(builtYamls)
+		if err != nil {
+			S.logger.Println("error reporting network exposure:", err)
+			return err
+		}
+		if len(result.Changes) > 0 {
+			sections = append(sections, result.Section())
+		}
+	}
+
+	// list which encrypted values changed, without showing them
+	if len(secretChanges.Changes) > 0 {
+		sections = append(sections, secretChanges.Section())
+	}
+
+	// submit the PR branch to the cluster, to catch anything admission would reject
+	if S.dryRunner != nil {
+		for _, builtYaml := range builtYamls {
+			result, ok, err := S.dryRunner.DryRun(ctx, builtYaml)
+			if err != nil {
+				S.logger.Println("error dry running:", err)
+				return err
+			}
+			if !ok {
+				continue
+			}
+			diff, err := S.differ.Diff(result.Live, result.DryRun)
+			if err != nil {
+				return err
+			}
+			sections = append(sections, result.Section(diff))
+		}
 	}
 
 	fileDiffs := []file.Diff{}
@@ -188,6 +729,31 @@ func (S Tool) RunToCompletion() error {
 		)
 	}
 
+	// diff the PR branch against what is currently running, where a cluster is configured
+	if S.liveFetcher != nil {
+		for _, builtYaml := range builtYamls {
+			liveYaml, ok, err := S.liveFetcher.Fetch(ctx, builtYaml)
+			if err != nil {
+				S.logger.Println("error fetching live objects:", err)
+				return err
+			}
+			if !ok {
+				continue
+			}
+			diff, err := S.differ.Diff(liveYaml.YamlTargetBranch, liveYaml.YamlPrBranch)
+			if err != nil {
+				return err
+			}
+			fileDiffs = append(fileDiffs, file.Diff{
+				AppPath: liveYaml.AppPath,
+				Diff:    diff,
+			})
+		}
+	}
+
+	// order the diffs so that comments are identical between runs
+	S.orderer.Sort(fileDiffs)
+
 	// chunk the diffs into comments
 	chunkedDiffs := []file.Diff{}
 	for _, fileDiff := range fileDiffs {
@@ -216,19 +782,189 @@ func (S Tool) RunToCompletion() error {
 		renderedTemplates = append(renderedTemplates, renderedTemplate)
 	}
 
+	// show how the changed apps were built when it isn't the default
+	builtFlags := map[string][]string{}
+	for _, builtYaml := range builtYamls {
+		if flags, ok := S.runner.AppFlags()[builtYaml.AppPath]; ok {
+			builtFlags[builtYaml.AppPath] = flags
+		}
+	}
+	if section, ok := S.config.kustomizeFlags.Section(builtFlags); ok {
+		sections = append(sections, section)
+	}
+
+	// the report comes before the diffs
+	renderedSections, err := S.renderSections(sections)
+	if err != nil {
+		return err
+	}
+	renderedTemplates = append(renderedSections, renderedTemplates...)
+
+	if len(risks.Changes) > 0 {
+		renderedTemplates, err = S.prependBanner(renderedTemplates, risks)
+		if err != nil {
+			return err
+		}
+	}
+
+	renderedTemplates, err = S.appendFooter(ctx, renderedTemplates)
+	if err != nil {
+		return err
+	}
+
+	// a cancelled run doesn't post a partial report
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	// create a PR comment for each rendered template
-	err = S.githubCommenter.Comment(renderedTemplates)
+	err = S.githubCommenter.Comment(ctx, renderedTemplates)
 	if err != nil {
 		S.logger.Println("error commenting:", err)
 		return err
 	}
 
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, ", "))
+	}
+
 	return nil
 }
 
+// renderSections renders the report sections into as few comments as they fit in, splitting
+// only a section too big for a comment of its own
+func (S Tool) renderSections(sections []report.Section) ([]string, error) {
+	renderedSections := []string{}
+	for _, section := range sections {
+		for _, chunk := range S.chunker.Chunk(section.Body) {
+			renderedSection, err := S.renderer.Render(
+				gh.ReportSectionTemplate,
+				map[string]string{
+					"TITLE": section.Title,
+					"BODY":  chunk,
+				},
+			)
+			if err != nil {
+				return nil, err
+			}
+			renderedSections = append(renderedSections, renderedSection)
+		}
+	}
+	return S.chunker.Pack(renderedSections), nil
+}
+
+// prependBanner puts a warning about destructive changes at the top of the first comment,
+// or in a comment of its own if it doesn't fit
+func (S Tool) prependBanner(renderedTemplates []string, risks risk.Result) ([]string, error) {
+	banner, err := S.renderer.Render(
+		gh.WarningBannerTemplate,
+		map[string]string{
+			"TITLE": fmt.Sprintf("This change destroys or recreates %d resources", len(risks.Changes)),
+			"BODY":  risks.Banner(),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(renderedTemplates) > 0 && len(banner)+len(renderedTemplates[0]) <= gh.MaxGithubCommentLength {
+		renderedTemplates[0] = banner + "\n" + renderedTemplates[0]
+		return renderedTemplates, nil
+	}
+	return append([]string{banner}, renderedTemplates...), nil
+}
+
+// appendFooter puts the versions of the tools used to render at the bottom of the last comment,
+// or in a comment of its own if it doesn't fit
+func (S Tool) appendFooter(ctx context.Context, renderedTemplates []string) ([]string, error) {
+	versions := S.runner.Versions(ctx)
+	if len(renderedTemplates) == 0 || len(versions) == 0 {
+		return renderedTemplates, nil
+	}
+	tools := []string{}
+	for _, version := range versions {
+		tools = append(tools, version.Name+" "+version.Version)
+	}
+	footer, err := S.renderer.Render(
+		gh.FooterTemplate,
+		map[string]string{
+			"TOOLS": strings.Join(tools, ", "),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	last := len(renderedTemplates) - 1
+	if len(renderedTemplates[last])+len(footer) <= gh.MaxGithubCommentLength {
+		renderedTemplates[last] = renderedTemplates[last] + "\n" + footer
+		return renderedTemplates, nil
+	}
+	return append(renderedTemplates, footer), nil
+}
+
+// offlineDirs are the directories whose kustomizations are checked before building offline.
+// Directory apps are all under ENVS_DIR, with the bases they use found wherever they are,
+// while the kustomizations of other app sources may be anywhere in the checkouts.
+func (S Tool) offlineDirs() []string {
+	if S.config.appSource == appSourceDirectory {
+		return []string{
+			filepath.Join(S.config.prDir, S.config.envsDir),
+			filepath.Join(S.config.targetDir, S.config.envsDir),
+		}
+	}
+	return []string{S.config.prDir, S.config.targetDir}
+}
+
+// changedDirectoryApps filters apps down to those whose directories differ between branches,
+// ignoring any whose parent directory is itself a kustomization
+func (S Tool) changedDirectoryApps(allApps utils.Set) (utils.Set, error) {
+	diffPaths := utils.NewSet()
+	for _, eachApp := range allApps.Sorted(file.LessAppPath) {
+		dir1 := filepath.Join(S.config.prDir, S.config.envsDir, eachApp)
+		dir2 := filepath.Join(S.config.targetDir, S.config.envsDir, eachApp)
+		hasDiff, reason, err := S.differ.HasDiff(dir1, dir2)
+		if err != nil {
+			S.logger.Println("error checking if diff exists:", err)
+			return nil, err
+		}
+		if hasDiff {
+			diffPaths[eachApp] = struct{}{}
+			S.logger.Println("diff found between branches for app: ", eachApp, "reason:", reason)
+		}
+	}
+	// at each path, if the directory above has a kustomization.yaml, remove it from the list
+	for _, diffPath := range diffPaths.Sorted(file.LessAppPath) {
+		fullPath := filepath.Join(S.config.prDir, S.config.envsDir, diffPath, "..", "kustomization.yaml")
+		exists, err := utils.FileExists(fullPath)
+		if err != nil {
+			S.logger.Println("error checking if file exists:", err)
+			return nil, err
+		}
+		if exists {
+			delete(diffPaths, diffPath)
+		}
+	}
+
+	return diffPaths, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "vendor" {
+		if err := vendor(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// stop cleanly when the job is cancelled, killing any build in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// once cancelled, a second signal kills kubediff straight away
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 	tool := New()
-	err := tool.RunToCompletion()
+	err := tool.RunToCompletion(ctx)
 	if err != nil {
 		tool.logger.Fatal(err)
 	}
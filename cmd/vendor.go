@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cyclingwithelephants/kubediff/internal/offline"
+)
+
+// vendor lists every remote dependency of the kustomizations under each directory given,
+// the current directory by default, so that a chart mirror can be populated ahead of offline runs
+func vendor(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("vendor", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: kubediff vendor [directory...]")
+		fmt.Fprintln(flags.Output(), "lists the charts and remote resources kustomizations fetch when they are built")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KIND\tNAME\tVERSION\tREPOSITORY\tUSED BY")
+	for _, dir := range dirs {
+		dependencies, err := offline.Dependencies(dir)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n",
+				dependency.Kind,
+				dependency.Name,
+				orDash(dependency.Version),
+				orDash(dependency.Repo),
+				strings.Join(dependency.UsedBy, ","),
+			)
+		}
+	}
+	return writer.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,331 @@
+package argocd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// Builder renders applications found by an AppFinder with the same options
+// Argo CD would use: kustomize overrides, helm values and parameters, and
+// plain directories of manifests, across one or more sources.
+type Builder struct {
+	finder    *AppFinder
+	prDir     string // the directory where the PR branch is checked out
+	targetDir string // the directory where the target branch is checked out
+	runner    *yaml.Runner
+	logger    *log.Logger
+}
+
+func NewBuilder(finder *AppFinder, prDir, targetDir string, runner *yaml.Runner, logger *log.Logger) *Builder {
+	return &Builder{
+		finder:    finder,
+		prDir:     prDir,
+		targetDir: targetDir,
+		runner:    runner,
+		logger:    logger,
+	}
+}
+
+func (B *Builder) Build(ctx context.Context, appPath string) (yaml.BuiltYaml, error) {
+	pair, ok := B.finder.App(appPath)
+	if !ok {
+		return yaml.BuiltYaml{}, fmt.Errorf("no Argo CD application found for %s", appPath)
+	}
+
+	prYaml, err := B.render(ctx, B.prDir, appPath, pair.PrBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+	targetYaml, err := B.render(ctx, B.targetDir, appPath, pair.TargetBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+
+	return yaml.BuiltYaml{
+		AppPath:          appPath,
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: targetYaml,
+	}, nil
+}
+
+func (B *Builder) render(ctx context.Context, branchRoot, appPath string, app *Application) (string, error) {
+	if app == nil {
+		return "", nil
+	}
+	sources := app.Spec.AllSources()
+
+	// sources with a ref are only there to provide value files to other sources
+	refs := map[string]string{}
+	for _, source := range sources {
+		if source.Ref != "" && isLocalRepo(B.finder.repoURLs, source.RepoURL) {
+			refs[source.Ref] = branchRoot
+		}
+	}
+
+	rendered := []string{}
+	for _, source := range sources {
+		if source.Ref != "" && source.Path == "" && source.Chart == "" {
+			continue
+		}
+		out, err := B.renderSource(ctx, branchRoot, appPath, app, source, refs)
+		if err != nil {
+			return "", fmt.Errorf("error rendering application %s: %w", app.Metadata.Name, err)
+		}
+		if strings.TrimSpace(out) != "" {
+			rendered = append(rendered, strings.TrimSuffix(out, "\n")+"\n")
+		}
+	}
+	return strings.Join(rendered, "---\n"), nil
+}
+
+func (B *Builder) renderSource(ctx context.Context, branchRoot, appPath string, app *Application, source Source, refs map[string]string) (string, error) {
+	if source.Chart != "" {
+		return B.helm(ctx, app, source, "", refs)
+	}
+	if !isLocalRepo(B.finder.repoURLs, source.RepoURL) {
+		B.logger.Println("skipping source from another repository:", source.RepoURL)
+		return "", nil
+	}
+
+	dir := filepath.Join(branchRoot, source.Path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "", fmt.Errorf("source path %s does not exist", dir)
+	}
+	switch {
+	case yaml.HasKustomization(dir):
+		return B.kustomize(ctx, appPath, app, source, dir)
+	case fileExists(filepath.Join(dir, "Chart.yaml")):
+		return B.helm(ctx, app, source, dir, refs)
+	default:
+		return B.directory(source, dir)
+	}
+}
+
+// kustomize builds a kustomization, wrapping it in a temporary overlay when the
+// application sets kustomize options, as Argo CD applies them with `kustomize edit`.
+func (B *Builder) kustomize(ctx context.Context, appPath string, app *Application, source Source, dir string) (string, error) {
+	options := source.Kustomize
+	if options == nil {
+		return B.runner.Kustomize(ctx, appPath, dir)
+	}
+
+	overlay := map[string]interface{}{}
+	if options.NamePrefix != "" {
+		overlay["namePrefix"] = options.NamePrefix
+	}
+	if options.NameSuffix != "" {
+		overlay["nameSuffix"] = options.NameSuffix
+	}
+	if options.Namespace != "" {
+		overlay["namespace"] = options.Namespace
+	}
+	if len(options.CommonLabels) > 0 {
+		overlay["commonLabels"] = options.CommonLabels
+	}
+	if len(options.CommonAnnotations) > 0 {
+		overlay["commonAnnotations"] = options.CommonAnnotations
+	}
+	if len(options.Images) > 0 {
+		images := []map[string]string{}
+		for _, image := range options.Images {
+			images = append(images, parseKustomizeImage(image))
+		}
+		overlay["images"] = images
+	}
+	if len(options.Replicas) > 0 {
+		overlay["replicas"] = options.Replicas
+	}
+	if len(options.Patches) > 0 {
+		overlay["patches"] = options.Patches
+	}
+	if len(options.Components) > 0 {
+		overlay["components"] = options.Components
+	}
+
+	B.logger.Println("applying kustomize options for application", app.Metadata.Name, "via overlay")
+	return B.runner.KustomizeOverlay(ctx, appPath, dir, overlay)
+}
+
+// helm templates either a local chart directory, or a chart from a remote
+// repository when chartDir is empty.
+func (B *Builder) helm(ctx context.Context, app *Application, source Source, chartDir string, refs map[string]string) (string, error) {
+	options := source.Helm
+	if options == nil {
+		options = &HelmSource{}
+	}
+
+	releaseName := options.ReleaseName
+	if releaseName == "" {
+		releaseName = app.Metadata.Name
+	}
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = app.Spec.Destination.Namespace
+	}
+
+	args := []string{releaseName}
+	if chartDir != "" {
+		args = append(args, chartDir)
+	} else if strings.Contains(source.RepoURL, "://") {
+		args = append(args, source.Chart, "--repo", source.RepoURL)
+	} else {
+		// repositories without a scheme are OCI registries
+		args = append(args, "oci://"+strings.TrimSuffix(source.RepoURL, "/")+"/"+source.Chart)
+	}
+	if chartDir == "" && source.TargetRevision != "" {
+		args = append(args, "--version", source.TargetRevision)
+	}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+	if options.SkipCrds {
+		args = append(args, "--skip-crds")
+	} else {
+		args = append(args, "--include-crds")
+	}
+
+	for _, valueFile := range options.ValueFiles {
+		resolved := resolveValueFile(valueFile, chartDir, refs)
+		if !fileExists(resolved) {
+			if options.IgnoreMissingValueFiles {
+				B.logger.Println("ignoring missing value file:", resolved)
+				continue
+			}
+			return "", fmt.Errorf("value file %s does not exist", resolved)
+		}
+		args = append(args, "--values", resolved)
+	}
+
+	// inline values take precedence over value files
+	inlineValues := options.Values
+	if len(options.ValuesObject) > 0 {
+		content, err := goyaml.Marshal(options.ValuesObject)
+		if err != nil {
+			return "", err
+		}
+		inlineValues = string(content)
+	}
+	if strings.TrimSpace(inlineValues) != "" {
+		valuesFile, err := os.CreateTemp("", "kubediff-values-*.yaml")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(valuesFile.Name())
+		if _, err := valuesFile.WriteString(inlineValues); err != nil {
+			valuesFile.Close()
+			return "", err
+		}
+		if err := valuesFile.Close(); err != nil {
+			return "", err
+		}
+		args = append(args, "--values", valuesFile.Name())
+	}
+
+	// parameters take precedence over everything else
+	for _, parameter := range options.Parameters {
+		flag := "--set"
+		if parameter.ForceString {
+			flag = "--set-string"
+		}
+		args = append(args, flag, parameter.Name+"="+parameter.Value)
+	}
+
+	return B.runner.Helm(ctx, args...)
+}
+
+// directory concatenates the manifests in a plain directory source
+func (B *Builder) directory(source Source, dir string) (string, error) {
+	options := source.Directory
+	if options == nil {
+		options = &DirectorySource{}
+	}
+
+	documents, err := yaml.ConcatManifests(dir, options.Recurse, func(relative string) bool {
+		return matchesGlobs(options.Include, relative, true) && !matchesGlobs(options.Exclude, relative, false)
+	})
+	if err != nil {
+		return "", err
+	}
+	B.logger.Println("read manifests from directory:", dir)
+	return documents, nil
+}
+
+// resolveValueFile resolves a value file relative to the chart, or to the
+// root of a referenced source when written as $ref/path
+func resolveValueFile(valueFile, chartDir string, refs map[string]string) string {
+	if strings.HasPrefix(valueFile, "$") {
+		ref, rest, _ := strings.Cut(strings.TrimPrefix(valueFile, "$"), "/")
+		if root, ok := refs[ref]; ok {
+			return filepath.Join(root, rest)
+		}
+	}
+	if filepath.IsAbs(valueFile) || chartDir == "" {
+		return valueFile
+	}
+	return filepath.Join(chartDir, valueFile)
+}
+
+// matchesGlobs matches a path against Argo CD's `{a,b}` style list of globs.
+// An empty pattern list returns emptyResult.
+func matchesGlobs(patterns, filePath string, emptyResult bool) bool {
+	patterns = strings.TrimSuffix(strings.TrimPrefix(patterns, "{"), "}")
+	if patterns == "" {
+		return emptyResult
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if ok, _ := path.Match(pattern, filepath.ToSlash(filePath)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(filepath.ToSlash(filePath))); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKustomizeImage converts Argo CD's `[old=]new[:tag|@digest]` image
+// override into a kustomize images entry.
+func parseKustomizeImage(image string) map[string]string {
+	result := map[string]string{}
+	name, override, hasOverride := strings.Cut(image, "=")
+	if !hasOverride {
+		override = name
+	}
+
+	newName := override
+	if before, digest, ok := strings.Cut(override, "@"); ok {
+		newName = before
+		result["digest"] = digest
+	} else if i := strings.LastIndex(override, ":"); i > strings.LastIndex(override, "/") {
+		newName = override[:i]
+		result["newTag"] = override[i+1:]
+	}
+
+	if !hasOverride {
+		name = newName
+	} else if before, _, ok := strings.Cut(name, "@"); ok {
+		name = before
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	result["name"] = name
+	if newName != name {
+		result["newName"] = newName
+	}
+	return result
+}
+
+func fileExists(filePath string) bool {
+	exists, err := utils.FileExists(filePath)
+	return err == nil && exists
+}
@@ -0,0 +1,260 @@
+package argocd
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func newBuilder() *Builder {
+	logger := log.New(io.Discard, "", 0)
+	runner := yaml.NewRunner(filesys.MakeFsOnDisk(), yaml.KustomizeLibrary, yaml.KustomizeFlags{}, nil, logger)
+	return NewBuilder(&AppFinder{}, "", "", runner, logger)
+}
+
+// fakeHelm puts a helm on PATH that prints each of its arguments on a line, and the
+// content of the values files kubediff writes for inline values in place of their path
+func fakeHelm(t *testing.T) {
+	binDir := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"helm": "#!/bin/sh\nfor arg in \"$@\"; do\n  case \"$arg\" in\n    */kubediff-values-*) echo \"inline: $(cat \"$arg\")\" ;;\n    *) echo \"$arg\" ;;\n  esac\ndone\n",
+	})
+	if err := os.Chmod(filepath.Join(binDir, "helm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestBuilder_Helm(t *testing.T) {
+	fakeHelm(t)
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"charts/web/Chart.yaml":       "name: web\n",
+		"charts/web/values-prod.yaml": "replicas: 2\n",
+		"values/prod.yaml":            "replicas: 3\n",
+	})
+	chartDir := filepath.Join(root, "charts", "web")
+	app := &Application{
+		Metadata: Metadata{Name: "web"},
+		Spec:     AppSpec{Destination: Destination{Namespace: "shop"}},
+	}
+
+	testCases := []struct {
+		name          string
+		source        Source
+		chartDir      string
+		expectedArgs  []string // with {root} for the root of the branch
+		expectedError string
+	}{
+		{
+			name:     "Case 1: value files, then inline values, then parameters",
+			chartDir: chartDir,
+			source: Source{Helm: &HelmSource{
+				ValueFiles: []string{"values-prod.yaml"},
+				Values:     "replicas: 4\n",
+				Parameters: []HelmParameter{{Name: "replicas", Value: "5"}, {Name: "tag", Value: "1.0", ForceString: true}},
+			}},
+			expectedArgs: []string{
+				"web", "{root}/charts/web", "--namespace", "shop", "--include-crds",
+				"--values", "{root}/charts/web/values-prod.yaml",
+				"--values", "inline: replicas: 4",
+				"--set", "replicas=5", "--set-string", "tag=1.0",
+			},
+		},
+		{
+			name:     "Case 2: valuesObject replaces values",
+			chartDir: chartDir,
+			source: Source{Helm: &HelmSource{
+				Values:       "replicas: 4\n",
+				ValuesObject: map[string]interface{}{"replicas": 6},
+			}},
+			expectedArgs: []string{"web", "{root}/charts/web", "--namespace", "shop", "--include-crds", "--values", "inline: replicas: 6"},
+		},
+		{
+			name:     "Case 3: value file from a referenced source",
+			chartDir: chartDir,
+			source: Source{Helm: &HelmSource{
+				ValueFiles: []string{"$values/values/prod.yaml", "values-prod.yaml"},
+			}},
+			expectedArgs: []string{
+				"web", "{root}/charts/web", "--namespace", "shop", "--include-crds",
+				"--values", "{root}/values/prod.yaml",
+				"--values", "{root}/charts/web/values-prod.yaml",
+			},
+		},
+		{
+			name:     "Case 4: missing value file",
+			chartDir: chartDir,
+			source: Source{Helm: &HelmSource{
+				ValueFiles: []string{"values-staging.yaml"},
+			}},
+			expectedError: "value file " + filepath.Join(chartDir, "values-staging.yaml") + " does not exist",
+		},
+		{
+			name:     "Case 5: ignored missing value file",
+			chartDir: chartDir,
+			source: Source{Helm: &HelmSource{
+				ValueFiles:              []string{"values-staging.yaml"},
+				IgnoreMissingValueFiles: true,
+			}},
+			expectedArgs: []string{"web", "{root}/charts/web", "--namespace", "shop", "--include-crds"},
+		},
+		{
+			name: "Case 6: chart from a repository",
+			source: Source{
+				RepoURL:        "https://charts.example.com",
+				Chart:          "redis",
+				TargetRevision: "1.0.0",
+				Helm:           &HelmSource{ReleaseName: "cache", Namespace: "data", SkipCrds: true},
+			},
+			expectedArgs: []string{"cache", "redis", "--repo", "https://charts.example.com", "--version", "1.0.0", "--namespace", "data", "--skip-crds"},
+		},
+		{
+			name: "Case 7: chart from an OCI registry",
+			source: Source{
+				RepoURL:        "registry.example.com/charts/",
+				Chart:          "redis",
+				TargetRevision: "1.0.0",
+			},
+			expectedArgs: []string{"web", "oci://registry.example.com/charts/redis", "--version", "1.0.0", "--namespace", "shop", "--include-crds"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out, err := newBuilder().helm(context.Background(), app, testCase.source, testCase.chartDir, map[string]string{"values": root})
+			if testCase.expectedError != "" {
+				if err == nil || err.Error() != testCase.expectedError {
+					t.Fatalf("Expected error %q, got %v", testCase.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := "template\n" + strings.ReplaceAll(strings.Join(testCase.expectedArgs, "\n"), "{root}", root) + "\n"
+			if out != expected {
+				t.Errorf("Expected helm arguments:\n%s\ngot:\n%s", expected, out)
+			}
+		})
+	}
+}
+
+func TestBuilder_Kustomize(t *testing.T) {
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"app/kustomization.yaml":       "resources: [deployment.yaml]\n",
+		"app/deployment.yaml":          testutil.Deployment{Replicas: 1, Containers: []testutil.Container{{Name: "web", Image: "web:1.0"}}}.Yaml(),
+		"app/debug/kustomization.yaml": "apiVersion: kustomize.config.k8s.io/v1alpha1\nkind: Component\ncommonAnnotations:\n  debug: \"true\"\n",
+	})
+	app := &Application{Metadata: Metadata{Name: "web"}}
+
+	testCases := []struct {
+		name     string
+		dir      string
+		options  *KustomizeSource
+		expected string
+	}{
+		{
+			name:     "Case 1: without options",
+			dir:      "app",
+			expected: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  replicas: 1\n  template:\n    spec:\n      containers:\n      - image: web:1.0\n        name: web\n",
+		},
+		{
+			name: "Case 2: name, namespace and labels",
+			dir:  "app",
+			options: &KustomizeSource{
+				NamePrefix:   "prod-",
+				NameSuffix:   "-v1",
+				Namespace:    "shop",
+				CommonLabels: map[string]string{"team": "web"},
+			},
+			expected: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  labels:\n    team: web\n  name: prod-web-v1\n  namespace: shop\nspec:\n  replicas: 1\n  selector:\n    matchLabels:\n      team: web\n  template:\n    metadata:\n      labels:\n        team: web\n    spec:\n      containers:\n      - image: web:1.0\n        name: web\n",
+		},
+		{
+			name: "Case 3: images, replicas and components",
+			dir:  "app",
+			options: &KustomizeSource{
+				Images:     []string{"web=registry.example.com/web:2.0"},
+				Replicas:   []KustomizeReplica{{Name: "web", Count: 3}},
+				Components: []string{"debug"},
+			},
+			expected: "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  annotations:\n    debug: \"true\"\n  name: web\nspec:\n  replicas: 3\n  template:\n    metadata:\n      annotations:\n        debug: \"true\"\n    spec:\n      containers:\n      - image: registry.example.com/web:2.0\n        name: web\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			source := Source{Kustomize: testCase.options}
+			actual, err := newBuilder().kustomize(context.Background(), "prod/web", app, source, filepath.Join(root, testCase.dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuilder_Directory(t *testing.T) {
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"service.yaml":       "name: service\n",
+		"deployment.yml":     "name: deployment\n",
+		"secret.json":        "{\"name\": \"secret\"}\n",
+		"README.md":          "# not a manifest\n",
+		"crds/crd.yaml":      "name: crd\n",
+		"crds/test/crd.yaml": "name: test-crd\n",
+	})
+
+	testCases := []struct {
+		name     string
+		options  *DirectorySource
+		expected []string // the names of the manifests read, in order
+	}{
+		{
+			name:     "Case 1: top level only",
+			expected: []string{"deployment", "secret", "service"},
+		},
+		{
+			name:     "Case 2: recursive",
+			options:  &DirectorySource{Recurse: true},
+			expected: []string{"crd", "test-crd", "deployment", "secret", "service"},
+		},
+		{
+			name:     "Case 3: include by base name and path",
+			options:  &DirectorySource{Recurse: true, Include: "{*.yml,crds/*}"},
+			expected: []string{"crd", "deployment"},
+		},
+		{
+			name:     "Case 4: exclude",
+			options:  &DirectorySource{Recurse: true, Exclude: "{*.json, crds/test/*}"},
+			expected: []string{"crd", "deployment", "service"},
+		},
+		{
+			name:     "Case 5: include and exclude",
+			options:  &DirectorySource{Recurse: true, Include: "*.yaml", Exclude: "crd.yaml"},
+			expected: []string{"service"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out, err := newBuilder().directory(Source{Directory: testCase.options}, root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, document := range strings.Split(out, "---\n") {
+				name := strings.Trim(strings.TrimSpace(document), "{}")
+				name = strings.TrimPrefix(strings.TrimPrefix(name, "name: "), "\"name\": ")
+				actual = append(actual, strings.Trim(name, "\""))
+			}
+			if strings.Join(actual, ",") != strings.Join(testCase.expected, ",") {
+				t.Errorf("Expected manifests %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package argocd
+
+import (
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+)
+
+// AppPair holds the definition of an application in each branch.
+// Either side is nil when the application was added or removed by the PR.
+type AppPair struct {
+	PrBranch     *Application
+	TargetBranch *Application
+}
+
+// AppFinder discovers applications from the Argo CD Application and
+// ApplicationSet manifests in both checkouts of the repository.
+// Each app is identified by "<destination cluster>/<application name>".
+type AppFinder struct {
+	apps      map[string]*AppPair // every application found, keyed by app path
+	prDir     string              // the directory where the PR branch is checked out
+	targetDir string              // the directory where the target branch is checked out
+	appsDir   string              // the directory containing Application and ApplicationSet manifests
+	repoURLs  []string            // the URLs referring to this repository, used to find local sources
+	logger    *log.Logger
+}
+
+func NewAppFinder(prDir, targetDir, appsDir string, repoURLs []string, logger *log.Logger) *AppFinder {
+	normalised := make([]string, len(repoURLs))
+	for i, repoURL := range repoURLs {
+		normalised[i] = NormaliseRepoURL(repoURL)
+	}
+	return &AppFinder{
+		apps:      map[string]*AppPair{},
+		prDir:     prDir,
+		targetDir: targetDir,
+		appsDir:   appsDir,
+		repoURLs:  normalised,
+		logger:    logger,
+	}
+}
+
+func (F *AppFinder) GetAllAppPaths() (utils.Set, error) {
+	for _, branchDir := range []string{F.prDir, F.targetDir} {
+		loader := &manifestLoader{
+			branchRoot: branchDir,
+			appsDir:    F.appsDir,
+			repoURLs:   F.repoURLs,
+			logger:     F.logger,
+		}
+		apps, err := loader.load()
+		if err != nil {
+			return nil, err
+		}
+		for i := range apps {
+			app := apps[i]
+			key := AppKey(app)
+			pair, ok := F.apps[key]
+			if !ok {
+				pair = &AppPair{}
+				F.apps[key] = pair
+			}
+			if branchDir == F.prDir {
+				if pair.PrBranch != nil {
+					return nil, fmt.Errorf("application %s is defined more than once in the PR branch", key)
+				}
+				pair.PrBranch = &app
+			} else {
+				if pair.TargetBranch != nil {
+					return nil, fmt.Errorf("application %s is defined more than once in the target branch", key)
+				}
+				pair.TargetBranch = &app
+			}
+		}
+	}
+
+	paths := utils.NewSet()
+	for key := range F.apps {
+		F.logger.Println("found Argo CD application:", key)
+		paths.Add(key)
+	}
+	return paths, nil
+}
+
+// App returns the definition of an application in both branches
+func (F *AppFinder) App(appPath string) (*AppPair, bool) {
+	pair, ok := F.apps[appPath]
+	return pair, ok
+}
+
+// AppKey identifies an application across branches
+func AppKey(app Application) string {
+	return path.Join(destinationName(app.Spec.Destination), app.Metadata.Name)
+}
@@ -0,0 +1,418 @@
+package argocd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inClusterServer is the address Argo CD uses for the cluster it is running in.
+const inClusterServer = "https://kubernetes.default.svc"
+
+// manifestLoader reads Application and ApplicationSet manifests from a single
+// checkout of the repository and expands them into a list of Applications.
+type manifestLoader struct {
+	branchRoot string   // the directory the branch is checked out to
+	appsDir    string   // the directory, relative to branchRoot, holding the manifests
+	repoURLs   []string // the normalised URLs that refer to this repository
+	logger     *log.Logger
+}
+
+// load walks the apps directory and returns every Application found, with
+// ApplicationSets expanded into the Applications they would generate.
+func (L *manifestLoader) load() ([]Application, error) {
+	root := filepath.Join(L.branchRoot, L.appsDir)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		L.logger.Println("apps directory doesn't exist:", root)
+		return nil, nil
+	}
+
+	apps := []Application{}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !isYamlFile(filePath) {
+			return nil
+		}
+		found, err := L.loadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("error loading %s: %w", filePath, err)
+		}
+		apps = append(apps, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+func (L *manifestLoader) loadFile(filePath string) ([]Application, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	apps := []Application{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var header struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+		}
+		if err := node.Decode(&header); err != nil {
+			// not a kubernetes object, e.g. a values file living alongside the apps
+			continue
+		}
+		if !strings.HasPrefix(header.APIVersion, "argoproj.io/") {
+			continue
+		}
+
+		switch header.Kind {
+		case KindApplication:
+			var app Application
+			if err := node.Decode(&app); err != nil {
+				return nil, err
+			}
+			L.logger.Println("found Application", app.Metadata.Name, "in", filePath)
+			apps = append(apps, app)
+		case KindApplicationSet:
+			var appSet ApplicationSet
+			if err := node.Decode(&appSet); err != nil {
+				return nil, err
+			}
+			generated, err := L.generate(appSet)
+			if err != nil {
+				return nil, fmt.Errorf("error generating ApplicationSet %s: %w", appSet.Metadata.Name, err)
+			}
+			L.logger.Println("ApplicationSet", appSet.Metadata.Name, "in", filePath, "generated", len(generated), "Applications")
+			apps = append(apps, generated...)
+		}
+	}
+	return apps, nil
+}
+
+// generate evaluates the generators of an ApplicationSet and renders its
+// template once per parameter set.
+func (L *manifestLoader) generate(appSet ApplicationSet) ([]Application, error) {
+	apps := []Application{}
+	for _, generator := range appSet.Spec.Generators {
+		paramSets, err := L.params(generator, appSet.Spec.GoTemplate)
+		if err != nil {
+			return nil, err
+		}
+		for _, params := range paramSets {
+			rendered, err := renderTemplate(appSet.Spec.Template, params, appSet.Spec.GoTemplate)
+			if err != nil {
+				return nil, err
+			}
+			// round trip through yaml to turn the generic template into an Application
+			raw, err := yaml.Marshal(rendered)
+			if err != nil {
+				return nil, err
+			}
+			app := Application{}
+			if err := yaml.Unmarshal(raw, &app); err != nil {
+				return nil, err
+			}
+			app.APIVersion = appSet.APIVersion
+			app.Kind = KindApplication
+			apps = append(apps, app)
+		}
+	}
+	return apps, nil
+}
+
+func (L *manifestLoader) params(generator Generator, goTemplate bool) ([]map[string]interface{}, error) {
+	switch {
+	case generator.List != nil:
+		return generator.List.Elements, nil
+	case generator.Git != nil:
+		if !L.isLocalRepo(generator.Git.RepoURL) {
+			L.logger.Println("skipping git generator for remote repository:", generator.Git.RepoURL)
+			return nil, nil
+		}
+		paramSets, err := L.gitParams(generator.Git, goTemplate)
+		if err != nil {
+			return nil, err
+		}
+		for _, params := range paramSets {
+			for key, value := range generator.Git.Values {
+				if goTemplate {
+					values, _ := params["values"].(map[string]interface{})
+					if values == nil {
+						values = map[string]interface{}{}
+						params["values"] = values
+					}
+					values[key] = value
+				} else {
+					params["values."+key] = value
+				}
+			}
+		}
+		return paramSets, nil
+	default:
+		L.logger.Println("skipping unsupported ApplicationSet generator")
+		return nil, nil
+	}
+}
+
+func (L *manifestLoader) gitParams(generator *GitGenerator, goTemplate bool) ([]map[string]interface{}, error) {
+	paramSets := []map[string]interface{}{}
+
+	if len(generator.Directories) > 0 {
+		dirs, err := L.matchDirectories(generator.Directories)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			paramSets = append(paramSets, pathParams(dir, "", goTemplate))
+		}
+	}
+
+	for _, item := range generator.Files {
+		matches, err := filepath.Glob(filepath.Join(L.branchRoot, item.Path))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			content, err := os.ReadFile(match)
+			if err != nil {
+				return nil, err
+			}
+			var values map[string]interface{}
+			if err := yaml.Unmarshal(content, &values); err != nil {
+				return nil, fmt.Errorf("error parsing git generator file %s: %w", match, err)
+			}
+			relative, err := filepath.Rel(L.branchRoot, match)
+			if err != nil {
+				return nil, err
+			}
+			params := pathParams(path.Dir(filepath.ToSlash(relative)), path.Base(relative), goTemplate)
+			if goTemplate {
+				for key, value := range values {
+					params[key] = value
+				}
+			} else {
+				flatten("", values, params)
+			}
+			paramSets = append(paramSets, params)
+		}
+	}
+	return paramSets, nil
+}
+
+// matchDirectories returns every directory in the branch matching the
+// included patterns and none of the excluded ones, relative to the branch root.
+func (L *manifestLoader) matchDirectories(items []GitDirectoryItem) ([]string, error) {
+	matched := []string{}
+	err := filepath.WalkDir(L.branchRoot, func(dirPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		relative, err := filepath.Rel(L.branchRoot, dirPath)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		include := false
+		for _, item := range items {
+			ok, err := path.Match(item.Path, relative)
+			if err != nil {
+				return err
+			}
+			if ok && item.Exclude {
+				return nil
+			}
+			include = include || ok
+		}
+		if include {
+			matched = append(matched, relative)
+		}
+		return nil
+	})
+	return matched, err
+}
+
+func (L *manifestLoader) isLocalRepo(repoURL string) bool {
+	return isLocalRepo(L.repoURLs, repoURL)
+}
+
+// isLocalRepo reports whether a repoURL refers to the repository being diffed
+func isLocalRepo(repoURLs []string, repoURL string) bool {
+	// with no repositories configured we assume a single repo setup
+	if len(repoURLs) == 0 {
+		return true
+	}
+	normalised := NormaliseRepoURL(repoURL)
+	for _, local := range repoURLs {
+		if normalised == local {
+			return true
+		}
+	}
+	return false
+}
+
+// pathParams builds the path parameters the git generator exposes for a directory
+func pathParams(dir, filename string, goTemplate bool) map[string]interface{} {
+	basename := path.Base(dir)
+	normalised := regexp.MustCompile(`[^a-zA-Z0-9-]`).ReplaceAllString(basename, "-")
+	segments := strings.Split(dir, "/")
+
+	if goTemplate {
+		segmentsList := make([]interface{}, len(segments))
+		for i, segment := range segments {
+			segmentsList[i] = segment
+		}
+		return map[string]interface{}{
+			"path": map[string]interface{}{
+				"path":               dir,
+				"basename":           basename,
+				"basenameNormalized": normalised,
+				"filename":           filename,
+				"segments":           segmentsList,
+			},
+		}
+	}
+
+	params := map[string]interface{}{
+		"path":                    dir,
+		"path.basename":           basename,
+		"path.basenameNormalized": normalised,
+	}
+	if filename != "" {
+		params["path.filename"] = filename
+	}
+	for i, segment := range segments {
+		params[fmt.Sprintf("path[%d]", i)] = segment
+	}
+	return params
+}
+
+// flatten turns nested values into dot separated keys, as the git files
+// generator does for fasttemplate style ApplicationSets.
+func flatten(prefix string, values map[string]interface{}, into map[string]interface{}) {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(key, nested, into)
+			continue
+		}
+		into[key] = value
+	}
+}
+
+var fastTemplateParam = regexp.MustCompile(`{{\s*([^{}\s]+)\s*}}`)
+
+// renderTemplate substitutes params into every string of an ApplicationSet template
+func renderTemplate(value interface{}, params map[string]interface{}, goTemplate bool) (interface{}, error) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for key, nested := range typed {
+			rendered, err := renderTemplate(nested, params, goTemplate)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = rendered
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, nested := range typed {
+			rendered, err := renderTemplate(nested, params, goTemplate)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = rendered
+		}
+		return result, nil
+	case string:
+		if goTemplate {
+			parsed, err := template.New("appset").Option("missingkey=zero").Parse(typed)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := parsed.Execute(&buf, params); err != nil {
+				return nil, err
+			}
+			return buf.String(), nil
+		}
+		return fastTemplateParam.ReplaceAllStringFunc(typed, func(match string) string {
+			key := fastTemplateParam.FindStringSubmatch(match)[1]
+			if param, ok := params[key]; ok {
+				return fmt.Sprintf("%v", param)
+			}
+			return match
+		}), nil
+	default:
+		return value, nil
+	}
+}
+
+// NormaliseRepoURL reduces the different ways of writing a git URL to a
+// comparable form, e.g. git@github.com:org/repo.git becomes github.com/org/repo
+func NormaliseRepoURL(repoURL string) string {
+	normalised := strings.ToLower(strings.TrimSpace(repoURL))
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git://"} {
+		normalised = strings.TrimPrefix(normalised, prefix)
+	}
+	if strings.HasPrefix(normalised, "git@") {
+		normalised = strings.Replace(strings.TrimPrefix(normalised, "git@"), ":", "/", 1)
+	}
+	normalised = strings.TrimSuffix(normalised, "/")
+	return strings.TrimSuffix(normalised, ".git")
+}
+
+// destinationName names the cluster an application is deployed to, matching
+// the name Argo CD shows in its UI.
+func destinationName(destination Destination) string {
+	if destination.Name != "" {
+		return destination.Name
+	}
+	if destination.Server == "" || destination.Server == inClusterServer {
+		return "in-cluster"
+	}
+	parsed, err := url.Parse(destination.Server)
+	if err != nil || parsed.Host == "" {
+		return strings.ReplaceAll(destination.Server, "/", "_")
+	}
+	return parsed.Host
+}
+
+func isYamlFile(filePath string) bool {
+	extension := strings.ToLower(filepath.Ext(filePath))
+	return extension == ".yaml" || extension == ".yml"
+}
@@ -0,0 +1,177 @@
+package argocd
+
+import (
+	"io"
+	"log"
+	"sort"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+)
+
+func TestNormaliseRepoURL(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		expectedOutput string
+	}{
+		{
+			name:           "Case 1: https with .git suffix",
+			input:          "https://github.com/org/repo.git",
+			expectedOutput: "github.com/org/repo",
+		},
+		{
+			name:           "Case 2: ssh shorthand",
+			input:          "git@github.com:org/repo.git",
+			expectedOutput: "github.com/org/repo",
+		},
+		{
+			name:           "Case 3: trailing slash and mixed case",
+			input:          "https://GitHub.com/Org/Repo/",
+			expectedOutput: "github.com/org/repo",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := NormaliseRepoURL(testCase.input)
+			if result != testCase.expectedOutput {
+				t.Errorf("Expected %v, got %v", testCase.expectedOutput, result)
+			}
+		})
+	}
+}
+
+func TestParseKustomizeImage(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		expectedOutput map[string]string
+	}{
+		{
+			name:           "Case 1: tag only",
+			input:          "nginx:1.25",
+			expectedOutput: map[string]string{"name": "nginx", "newTag": "1.25"},
+		},
+		{
+			name:           "Case 2: renamed image",
+			input:          "nginx=registry.local:5000/nginx:1.25",
+			expectedOutput: map[string]string{"name": "nginx", "newName": "registry.local:5000/nginx", "newTag": "1.25"},
+		},
+		{
+			name:           "Case 3: digest",
+			input:          "nginx@sha256:abc",
+			expectedOutput: map[string]string{"name": "nginx", "digest": "sha256:abc"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := parseKustomizeImage(testCase.input)
+			if len(result) != len(testCase.expectedOutput) {
+				t.Errorf("Expected %v, got %v", testCase.expectedOutput, result)
+				return
+			}
+			for key, value := range testCase.expectedOutput {
+				if result[key] != value {
+					t.Errorf("Expected %v, got %v", testCase.expectedOutput, result)
+				}
+			}
+		})
+	}
+}
+
+func TestManifestLoader_Load(t *testing.T) {
+	branchRoot := t.TempDir()
+	files := map[string]string{
+		"apps/app.yaml": `
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: standalone
+spec:
+  destination:
+    server: https://kubernetes.default.svc
+  source:
+    repoURL: https://github.com/org/repo
+    path: manifests/standalone
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-an-app
+`,
+		"apps/appset.yaml": `
+apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: web
+spec:
+  generators:
+  - list:
+      elements:
+      - cluster: prod
+  template:
+    metadata:
+      name: '{{cluster}}-web'
+    spec:
+      destination:
+        name: '{{cluster}}'
+      source:
+        repoURL: https://github.com/org/repo
+        path: web
+---
+apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: addons
+spec:
+  goTemplate: true
+  generators:
+  - git:
+      repoURL: git@github.com:org/repo.git
+      directories:
+      - path: addons/*
+      - path: addons/excluded
+        exclude: true
+  template:
+    metadata:
+      name: '{{.path.basename}}'
+    spec:
+      destination:
+        server: https://10.0.0.1:6443
+      source:
+        repoURL: https://github.com/org/repo
+        path: '{{.path.path}}'
+`,
+		"addons/cert-manager/kustomization.yaml": "resources: []",
+		"addons/excluded/kustomization.yaml":     "resources: []",
+	}
+	testutil.WriteFiles(t, branchRoot, files)
+
+	loader := &manifestLoader{
+		branchRoot: branchRoot,
+		appsDir:    "apps",
+		repoURLs:   []string{NormaliseRepoURL("https://github.com/org/repo")},
+		logger:     log.New(io.Discard, "", 0),
+	}
+	apps, err := loader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for _, app := range apps {
+		keys = append(keys, AppKey(app))
+	}
+	sort.Strings(keys)
+	expected := []string{"10.0.0.1:6443/cert-manager", "in-cluster/standalone", "prod/prod-web"}
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected apps %v, got %v", expected, keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Errorf("Expected apps %v, got %v", expected, keys)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package argocd
+
+// The types below mirror the subset of the argoproj.io/v1alpha1 Application
+// and ApplicationSet schemas that affect how an application is rendered.
+
+const (
+	KindApplication    = "Application"
+	KindApplicationSet = "ApplicationSet"
+)
+
+type Metadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+type Application struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       AppSpec  `yaml:"spec"`
+}
+
+type AppSpec struct {
+	Project     string      `yaml:"project"`
+	Destination Destination `yaml:"destination"`
+	Source      *Source     `yaml:"source"`
+	Sources     []Source    `yaml:"sources"`
+}
+
+// AllSources returns the single source and multi-source lists as one slice,
+// since Argo CD treats `source` as a one element `sources`.
+func (S AppSpec) AllSources() []Source {
+	if len(S.Sources) > 0 {
+		return S.Sources
+	}
+	if S.Source != nil {
+		return []Source{*S.Source}
+	}
+	return nil
+}
+
+type Destination struct {
+	Server    string `yaml:"server"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type Source struct {
+	RepoURL        string           `yaml:"repoURL"`
+	Path           string           `yaml:"path"`
+	TargetRevision string           `yaml:"targetRevision"`
+	Chart          string           `yaml:"chart"`
+	Ref            string           `yaml:"ref"`
+	Helm           *HelmSource      `yaml:"helm"`
+	Kustomize      *KustomizeSource `yaml:"kustomize"`
+	Directory      *DirectorySource `yaml:"directory"`
+}
+
+type HelmSource struct {
+	ReleaseName             string                 `yaml:"releaseName"`
+	ValueFiles              []string               `yaml:"valueFiles"`
+	Values                  string                 `yaml:"values"`
+	ValuesObject            map[string]interface{} `yaml:"valuesObject"`
+	Parameters              []HelmParameter        `yaml:"parameters"`
+	IgnoreMissingValueFiles bool                   `yaml:"ignoreMissingValueFiles"`
+	SkipCrds                bool                   `yaml:"skipCrds"`
+	Namespace               string                 `yaml:"namespace"`
+}
+
+type HelmParameter struct {
+	Name        string `yaml:"name"`
+	Value       string `yaml:"value"`
+	ForceString bool   `yaml:"forceString"`
+}
+
+type KustomizeSource struct {
+	NamePrefix        string                   `yaml:"namePrefix"`
+	NameSuffix        string                   `yaml:"nameSuffix"`
+	Namespace         string                   `yaml:"namespace"`
+	Images            []string                 `yaml:"images"`
+	CommonLabels      map[string]string        `yaml:"commonLabels"`
+	CommonAnnotations map[string]string        `yaml:"commonAnnotations"`
+	Replicas          []KustomizeReplica       `yaml:"replicas"`
+	Components        []string                 `yaml:"components"`
+	Patches           []map[string]interface{} `yaml:"patches"`
+}
+
+type KustomizeReplica struct {
+	Name  string `yaml:"name"`
+	Count int    `yaml:"count"`
+}
+
+type DirectorySource struct {
+	Recurse bool   `yaml:"recurse"`
+	Include string `yaml:"include"`
+	Exclude string `yaml:"exclude"`
+}
+
+type ApplicationSet struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   Metadata           `yaml:"metadata"`
+	Spec       ApplicationSetSpec `yaml:"spec"`
+}
+
+type ApplicationSetSpec struct {
+	GoTemplate bool                   `yaml:"goTemplate"`
+	Generators []Generator            `yaml:"generators"`
+	Template   map[string]interface{} `yaml:"template"`
+}
+
+// Generator only models the generators that can be evaluated from the
+// repository alone. Cluster, SCM and pull request generators need access to
+// live systems and are skipped.
+type Generator struct {
+	List *ListGenerator `yaml:"list"`
+	Git  *GitGenerator  `yaml:"git"`
+}
+
+type ListGenerator struct {
+	Elements []map[string]interface{} `yaml:"elements"`
+}
+
+type GitGenerator struct {
+	RepoURL     string                 `yaml:"repoURL"`
+	Revision    string                 `yaml:"revision"`
+	Directories []GitDirectoryItem     `yaml:"directories"`
+	Files       []GitFileItem          `yaml:"files"`
+	Values      map[string]interface{} `yaml:"values"`
+}
+
+type GitDirectoryItem struct {
+	Path    string `yaml:"path"`
+	Exclude bool   `yaml:"exclude"`
+}
+
+type GitFileItem struct {
+	Path string `yaml:"path"`
+}
@@ -0,0 +1,271 @@
+package capacity
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// scaledKinds are the kinds that run a number of replicas of a pod template, by their group and kind
+var scaledKinds = map[string]bool{
+	"apps/Deployment":  true,
+	"apps/StatefulSet": true,
+	"apps/ReplicaSet":  true,
+}
+
+// Totals are the summed requests and limits of a set of pods, in millicores and bytes
+type Totals struct {
+	CPURequests    int64
+	CPULimits      int64
+	MemoryRequests int64
+	MemoryLimits   int64
+}
+
+func (T Totals) add(other Totals, replicas int64) Totals {
+	return Totals{
+		CPURequests:    T.CPURequests + other.CPURequests*replicas,
+		CPULimits:      T.CPULimits + other.CPULimits*replicas,
+		MemoryRequests: T.MemoryRequests + other.MemoryRequests*replicas,
+		MemoryLimits:   T.MemoryLimits + other.MemoryLimits*replicas,
+	}
+}
+
+// max takes the larger of each total
+func (T Totals) max(other Totals) Totals {
+	larger := func(a, b int64) int64 {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	return Totals{
+		CPURequests:    larger(T.CPURequests, other.CPURequests),
+		CPULimits:      larger(T.CPULimits, other.CPULimits),
+		MemoryRequests: larger(T.MemoryRequests, other.MemoryRequests),
+		MemoryLimits:   larger(T.MemoryLimits, other.MemoryLimits),
+	}
+}
+
+// Delta is how the totals of a namespace change, where an empty Namespace is the whole environment
+type Delta struct {
+	Environment string
+	Namespace   string
+	Old         Totals
+	New         Totals
+}
+
+// Result is every namespace and environment whose totals change
+type Result struct {
+	Deltas []Delta
+}
+
+// Section tabulates the capacity changes for the PR report
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	for _, delta := range R.Deltas {
+		namespace := delta.Namespace
+		if namespace == "" {
+			namespace = "**all namespaces**"
+		}
+		rows = append(rows, []string{
+			delta.Environment,
+			namespace,
+			change(delta.Old.CPURequests, delta.New.CPURequests, formatCPU),
+			change(delta.Old.CPULimits, delta.New.CPULimits, formatCPU),
+			change(delta.Old.MemoryRequests, delta.New.MemoryRequests, formatMemory),
+			change(delta.Old.MemoryLimits, delta.New.MemoryLimits, formatMemory),
+		})
+	}
+	return report.Section{
+		Title: "Capacity changes",
+		Body: "Totals of the changed apps only, counting the minimum replicas of autoscaled workloads. " +
+			"DaemonSets aren't counted, as how many pods they run depends on the nodes of the cluster.\n\n" +
+			report.Table([]string{"Environment", "Namespace", "CPU requests", "CPU limits", "Memory requests", "Memory limits"}, rows),
+	}
+}
+
+// change formats an old and new total with the difference between them
+func change(old, new int64, format func(int64) string) string {
+	if old == new {
+		return format(new)
+	}
+	sign := "+"
+	if new < old {
+		sign = "-"
+	}
+	difference := new - old
+	if difference < 0 {
+		difference = -difference
+	}
+	return fmt.Sprintf("%s → %s (**%s%s**)", format(old), format(new), sign, format(difference))
+}
+
+// Analyser sums the CPU and memory requests and limits of the workloads in each branch
+type Analyser struct {
+	logger *log.Logger
+}
+
+func NewAnalyser(logger *log.Logger) *Analyser {
+	return &Analyser{
+		logger: logger,
+	}
+}
+
+// Analyse compares the totals of each namespace, then each environment, between branches
+func (A *Analyser) Analyse(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	environments := []string{}
+	namespaces := map[string]map[string]bool{}
+	oldTotals := map[[2]string]Totals{}
+	newTotals := map[[2]string]Totals{}
+	addTotals := func(totals map[[2]string]Totals, environment, rendered string) error {
+		resources, err := manifest.Parse(rendered)
+		if err != nil {
+			return err
+		}
+		for namespace, namespaceTotals := range A.totals(resources) {
+			namespaces[environment][namespace] = true
+			key := [2]string{environment, namespace}
+			totals[key] = totals[key].add(namespaceTotals, 1)
+		}
+		return nil
+	}
+	for _, builtYaml := range builtYamls {
+		environment := file.Environment(builtYaml.AppPath)
+		if _, ok := namespaces[environment]; !ok {
+			environments = append(environments, environment)
+			namespaces[environment] = map[string]bool{}
+		}
+		if err := addTotals(oldTotals, environment, builtYaml.YamlTargetBranch); err != nil {
+			return result, err
+		}
+		if err := addTotals(newTotals, environment, builtYaml.YamlPrBranch); err != nil {
+			return result, err
+		}
+	}
+
+	for _, environment := range environments {
+		environmentDelta := Delta{Environment: environment}
+		deltas := []Delta{}
+		sortedNamespaces := []string{}
+		for namespace := range namespaces[environment] {
+			sortedNamespaces = append(sortedNamespaces, namespace)
+		}
+		sort.Strings(sortedNamespaces)
+		for _, namespace := range sortedNamespaces {
+			key := [2]string{environment, namespace}
+			environmentDelta.Old = environmentDelta.Old.add(oldTotals[key], 1)
+			environmentDelta.New = environmentDelta.New.add(newTotals[key], 1)
+			if oldTotals[key] != newTotals[key] {
+				deltas = append(deltas, Delta{Environment: environment, Namespace: namespace, Old: oldTotals[key], New: newTotals[key]})
+			}
+		}
+		if len(deltas) > 0 {
+			result.Deltas = append(result.Deltas, deltas...)
+			result.Deltas = append(result.Deltas, environmentDelta)
+		}
+	}
+	return result, nil
+}
+
+// totals sums the requests and limits of the pods a render runs, by namespace
+func (A *Analyser) totals(resources []manifest.Resource) map[string]Totals {
+	// autoscaled workloads run at least their minimum replicas, whatever their own replicas say
+	minReplicas := map[string]int64{}
+	for _, resource := range resources {
+		if resource.ID.Kind != "HorizontalPodAutoscaler" || resource.ID.Group != "autoscaling" {
+			continue
+		}
+		replicas := int64(1)
+		if value, ok := manifest.Field(resource.Object, "spec", "minReplicas").(int); ok {
+			replicas = int64(value)
+		}
+		target := resource.ID.Namespace + "/" + manifest.String(resource.Object, "spec", "scaleTargetRef", "kind") +
+			"/" + manifest.String(resource.Object, "spec", "scaleTargetRef", "name")
+		minReplicas[target] = replicas
+	}
+
+	totals := map[string]Totals{}
+	for _, resource := range resources {
+		var podSpec interface{}
+		replicas := int64(1)
+		switch {
+		case scaledKinds[resource.ID.Group+"/"+resource.ID.Kind]:
+			podSpec = manifest.Field(resource.Object, "spec", "template", "spec")
+			if value, ok := manifest.Field(resource.Object, "spec", "replicas").(int); ok {
+				replicas = int64(value)
+			}
+			if value, ok := minReplicas[resource.ID.Namespace+"/"+resource.ID.Kind+"/"+resource.ID.Name]; ok {
+				replicas = value
+			}
+		case resource.ID.Kind == "Pod" && resource.ID.Group == "":
+			podSpec = manifest.Field(resource.Object, "spec")
+		default:
+			continue
+		}
+		podSpecMap, _ := podSpec.(map[string]interface{})
+		totals[resource.ID.Namespace] = totals[resource.ID.Namespace].add(A.podTotals(resource.ID, podSpecMap), replicas)
+	}
+	return totals
+}
+
+// podTotals computes the requests and limits of a pod as the scheduler does: the larger
+// of what its containers need together and what its init containers need while running
+// one at a time, alongside any sidecar init containers started before them
+func (A *Analyser) podTotals(id manifest.ID, podSpec map[string]interface{}) Totals {
+	containers, _ := podSpec["containers"].([]interface{})
+	totals := Totals{}
+	for _, container := range containers {
+		container, _ := container.(map[string]interface{})
+		totals = totals.add(A.containerTotals(id, container), 1)
+	}
+
+	initContainers, _ := podSpec["initContainers"].([]interface{})
+	sidecars := Totals{}
+	initTotals := Totals{}
+	for _, container := range initContainers {
+		container, _ := container.(map[string]interface{})
+		containerTotals := A.containerTotals(id, container)
+		if manifest.String(container, "restartPolicy") == "Always" {
+			sidecars = sidecars.add(containerTotals, 1)
+			initTotals = initTotals.max(sidecars)
+			continue
+		}
+		initTotals = initTotals.max(sidecars.add(containerTotals, 1))
+	}
+	return totals.add(sidecars, 1).max(initTotals)
+}
+
+// containerTotals reads the requests and limits of a single container
+func (A *Analyser) containerTotals(id manifest.ID, container map[string]interface{}) Totals {
+	totals := Totals{}
+	fields := map[*int64][]string{
+		&totals.CPURequests:    {"resources", "requests", "cpu"},
+		&totals.CPULimits:      {"resources", "limits", "cpu"},
+		&totals.MemoryRequests: {"resources", "requests", "memory"},
+		&totals.MemoryLimits:   {"resources", "limits", "memory"},
+	}
+	for total, path := range fields {
+		value := manifest.Field(container, path...)
+		if value == nil {
+			continue
+		}
+		quantity, err := parseQuantity(value)
+		if err != nil {
+			A.logger.Println("ignoring", strings.Join(path, "."), "of", id, ":", err)
+			continue
+		}
+		if path[2] == "cpu" {
+			quantity *= 1000
+		}
+		*total += int64(math.Round(quantity))
+	}
+	return totals
+}
@@ -0,0 +1,139 @@
+package capacity
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func deployment(namespace string, replicas int, cpu, memory string) string {
+	return testutil.Deployment{
+		Namespace: namespace,
+		Replicas:  replicas,
+		Containers: []testutil.Container{
+			{Name: "web", Requests: map[string]string{"cpu": cpu, "memory": memory}, Limits: map[string]string{"memory": memory}},
+			{Name: "sidecar", Requests: map[string]string{"cpu": "50m"}},
+		},
+	}.Yaml() + "---\n"
+}
+
+const hpa = `apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+  namespace: shop
+spec:
+  minReplicas: 4
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: web
+`
+
+// withInitContainers is a pod whose largest init container needs more memory than its containers,
+// and whose sidecar runs alongside both
+const withInitContainers = `apiVersion: v1
+kind: Pod
+metadata:
+  name: migrate
+  namespace: shop
+spec:
+  initContainers:
+  - name: proxy
+    restartPolicy: Always
+    resources:
+      requests:
+        cpu: 100m
+        memory: 64Mi
+  - name: migrate
+    resources:
+      requests:
+        cpu: 200m
+        memory: 2Gi
+  containers:
+  - name: web
+    resources:
+      requests:
+        cpu: 500m
+        memory: 512Mi
+`
+
+func TestAnalyser_Analyse(t *testing.T) {
+	testCases := []struct {
+		name       string
+		prYaml     string
+		targetYaml string
+		expected   []Delta
+	}{
+		{
+			name:       "Case 1: more replicas",
+			prYaml:     deployment("shop", 3, "250m", "512Mi"),
+			targetYaml: deployment("shop", 2, "250m", "512Mi"),
+			expected: []Delta{
+				{Environment: "prod", Namespace: "shop", Old: Totals{CPURequests: 600, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}, New: Totals{CPURequests: 900, MemoryRequests: 3 << 29, MemoryLimits: 3 << 29}},
+				{Environment: "prod", Old: Totals{CPURequests: 600, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}, New: Totals{CPURequests: 900, MemoryRequests: 3 << 29, MemoryLimits: 3 << 29}},
+			},
+		},
+		{
+			name:       "Case 2: HPA minReplicas overrides replicas",
+			prYaml:     deployment("shop", 1, "1", "1Gi") + hpa,
+			targetYaml: deployment("shop", 1, "1", "1Gi"),
+			expected: []Delta{
+				{Environment: "prod", Namespace: "shop", Old: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}, New: Totals{CPURequests: 4200, MemoryRequests: 4 << 30, MemoryLimits: 4 << 30}},
+				{Environment: "prod", Old: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}, New: Totals{CPURequests: 4200, MemoryRequests: 4 << 30, MemoryLimits: 4 << 30}},
+			},
+		},
+		{
+			name:       "Case 3: moving between namespaces leaves the environment unchanged",
+			prYaml:     deployment("checkout", 1, "1", "1Gi"),
+			targetYaml: deployment("shop", 1, "1", "1Gi"),
+			expected: []Delta{
+				{Environment: "prod", Namespace: "checkout", New: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}},
+				{Environment: "prod", Namespace: "shop", Old: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}},
+				{Environment: "prod", Old: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}, New: Totals{CPURequests: 1050, MemoryRequests: 1 << 30, MemoryLimits: 1 << 30}},
+			},
+		},
+		{
+			name:   "Case 4: init containers",
+			prYaml: withInitContainers,
+			expected: []Delta{
+				{Environment: "prod", Namespace: "shop", New: Totals{CPURequests: 600, MemoryRequests: 2<<30 + 64<<20}},
+				{Environment: "prod", New: Totals{CPURequests: 600, MemoryRequests: 2<<30 + 64<<20}},
+			},
+		},
+		{
+			name:       "Case 5: no change in capacity",
+			prYaml:     deployment("shop", 2, "250m", "512Mi") + "# a comment\n",
+			targetYaml: deployment("shop", 2, "250m", "512Mi"),
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			analyser := NewAnalyser(log.New(io.Discard, "", 0))
+			result, err := analyser.Analyse([]yaml.BuiltYaml{{
+				AppPath:          "prod/shop",
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.targetYaml,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Deltas, testCase.expected) {
+				t.Errorf("Expected deltas %+v, got %+v", testCase.expected, result.Deltas)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if actual := change(500, 1250, formatCPU); actual != "500m → 1.25 (**+750m**)" {
+		t.Errorf("Unexpected CPU change %v", actual)
+	}
+	if actual := change(1<<30, 512<<20, formatMemory); actual != "1Gi → 512Mi (**-512Mi**)" {
+		t.Errorf("Unexpected memory change %v", actual)
+	}
+}
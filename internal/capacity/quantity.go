@@ -0,0 +1,68 @@
+package capacity
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// quantitySuffixes are the multipliers of kubernetes quantity suffixes, binary ones first
+// so that e.g. Mi isn't read as M followed by an i
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3},
+	{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+}
+
+// parseQuantity parses a kubernetes quantity such as 250m, 1.5 or 512Mi into base units,
+// cores for CPU and bytes for memory
+func parseQuantity(value interface{}) (float64, error) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), nil
+	case float64:
+		return typed, nil
+	case string:
+		text := strings.TrimSpace(typed)
+		multiplier := 1.0
+		for _, suffix := range quantitySuffixes {
+			if strings.HasSuffix(text, suffix.suffix) {
+				text = strings.TrimSuffix(text, suffix.suffix)
+				multiplier = suffix.multiplier
+				break
+			}
+		}
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid quantity %q", typed)
+		}
+		return number * multiplier, nil
+	}
+	return 0, fmt.Errorf("invalid quantity %v", value)
+}
+
+// formatCPU formats millicores as kubernetes would, in cores from one core up
+func formatCPU(millicores int64) string {
+	if millicores%1000 != 0 && millicores < 1000 && millicores > -1000 {
+		return fmt.Sprintf("%dm", millicores)
+	}
+	return strconv.FormatFloat(float64(millicores)/1000, 'f', -1, 64)
+}
+
+// formatMemory formats bytes in the largest binary unit that keeps them above one
+func formatMemory(bytes int64) string {
+	value := float64(bytes)
+	unit := ""
+	for _, next := range []string{"Ki", "Mi", "Gi", "Ti"} {
+		if math.Abs(value) < 1024 {
+			break
+		}
+		value /= 1024
+		unit = next
+	}
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64) + unit
+}
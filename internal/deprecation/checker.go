@@ -0,0 +1,176 @@
+package deprecation
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// Whether a usage of a deprecated apiVersion is introduced, kept or removed by the PR
+const (
+	StatusNew      = "new"
+	StatusExisting = "existing"
+	StatusResolved = "resolved"
+)
+
+// Usage is a resource rendered with a deprecated apiVersion
+type Usage struct {
+	AppPath           string
+	ID                manifest.ID
+	Deprecation       Deprecation
+	KubernetesVersion string
+	Removed           bool // whether the apiVersion is no longer served by KubernetesVersion
+	Status            string
+}
+
+// Result is every usage of a deprecated apiVersion in either branch of the changed apps
+type Result struct {
+	Usages []Usage
+}
+
+// Section describes the usages for the PR report
+func (R Result) Section() report.Section {
+	counts := map[string]int{}
+	rows := [][]string{}
+	for _, usage := range R.Usages {
+		counts[usage.Status]++
+		state := fmt.Sprintf(":warning: deprecated in %s", usage.Deprecation.DeprecatedIn)
+		if usage.Removed {
+			state = fmt.Sprintf(":x: removed in %s", usage.Deprecation.RemovedIn)
+		}
+		rows = append(rows, []string{
+			usage.AppPath,
+			report.Code(usage.ID.String()),
+			report.Code(usage.Deprecation.APIVersion),
+			usage.Status,
+			state + " (cluster is " + usage.KubernetesVersion + ")",
+			report.Code(usage.Deprecation.Replacement),
+		})
+	}
+
+	var body strings.Builder
+	if counts[StatusNew] > 0 {
+		body.WriteString(fmt.Sprintf(":warning: **This change introduces %d resources with deprecated or removed apiVersions**\n\n", counts[StatusNew]))
+	}
+	body.WriteString(fmt.Sprintf("%d new, %d existing and %d resolved usages of deprecated apiVersions.\n\n", counts[StatusNew], counts[StatusExisting], counts[StatusResolved]))
+	body.WriteString(report.Table([]string{"App", "Resource", "apiVersion", "Status", "Deprecation", "Replacement"}, rows))
+	return report.Section{
+		Title: "Deprecated APIs",
+		Body:  body.String(),
+	}
+}
+
+// Checker finds resources rendered with apiVersions that are deprecated or removed
+// in the kubernetes version of their app's environment
+type Checker struct {
+	tablePath      string
+	versions       map[string]string // kubernetes versions by environment
+	defaultVersion string
+	logger         *log.Logger
+}
+
+func NewChecker(tablePath string, versions map[string]string, defaultVersion string, logger *log.Logger) *Checker {
+	return &Checker{
+		tablePath:      tablePath,
+		versions:       versions,
+		defaultVersion: defaultVersion,
+		logger:         logger,
+	}
+}
+
+// Check compares the usages in both branches of each app, so that reviewers can tell
+// what a PR introduces from what was already there
+func (C *Checker) Check(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	table, err := LoadTable(C.tablePath)
+	if err != nil {
+		return result, err
+	}
+	deprecations := map[string]Deprecation{}
+	for _, deprecation := range table {
+		deprecations[deprecation.APIVersion+" "+deprecation.Kind] = deprecation
+	}
+
+	for _, builtYaml := range builtYamls {
+		kubernetesVersion, ok := C.versions[file.Environment(builtYaml.AppPath)]
+		if !ok {
+			kubernetesVersion = C.defaultVersion
+		}
+		if kubernetesVersion == "" {
+			C.logger.Println("no kubernetes version for app, skipping deprecation checks:", builtYaml.AppPath)
+			continue
+		}
+		clusterVersion, err := parseVersion(kubernetesVersion)
+		if err != nil {
+			return result, fmt.Errorf("error parsing kubernetes version of %s: %w", builtYaml.AppPath, err)
+		}
+
+		prUsages, err := usages(builtYaml.YamlPrBranch, deprecations, clusterVersion)
+		if err != nil {
+			return result, err
+		}
+		targetUsages, err := usages(builtYaml.YamlTargetBranch, deprecations, clusterVersion)
+		if err != nil {
+			return result, err
+		}
+		for _, status := range []string{StatusNew, StatusExisting, StatusResolved} {
+			from, against := prUsages, targetUsages
+			if status == StatusResolved {
+				from, against = targetUsages, prUsages
+			}
+			for _, usage := range from {
+				if against.contains(usage) != (status == StatusExisting) {
+					continue
+				}
+				usage.AppPath = builtYaml.AppPath
+				usage.KubernetesVersion = kubernetesVersion
+				usage.Status = status
+				result.Usages = append(result.Usages, usage)
+			}
+		}
+	}
+	return result, nil
+}
+
+type usageList []Usage
+
+// contains is whether the same resource uses the same apiVersion
+func (U usageList) contains(usage Usage) bool {
+	for _, other := range U {
+		if other.ID == usage.ID && other.Deprecation.APIVersion == usage.Deprecation.APIVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// usages lists the resources of a render using an apiVersion deprecated by a kubernetes version
+func usages(rendered string, deprecations map[string]Deprecation, clusterVersion version) (usageList, error) {
+	resources, err := manifest.Parse(rendered)
+	if err != nil {
+		return nil, err
+	}
+	found := usageList{}
+	for _, resource := range resources {
+		deprecation, ok := deprecations[resource.APIVersion+" "+resource.ID.Kind]
+		if !ok {
+			continue
+		}
+		usage := Usage{ID: resource.ID, Deprecation: deprecation}
+		if deprecation.RemovedIn != "" {
+			removedIn, _ := parseVersion(deprecation.RemovedIn)
+			usage.Removed = clusterVersion.atLeast(removedIn)
+		}
+		deprecatedIn, _ := parseVersion(deprecation.DeprecatedIn)
+		if !usage.Removed && (deprecation.DeprecatedIn == "" || !clusterVersion.atLeast(deprecatedIn)) {
+			continue
+		}
+		found = append(found, usage)
+	}
+	return found, nil
+}
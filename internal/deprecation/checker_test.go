@@ -0,0 +1,95 @@
+package deprecation
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestChecker_Check(t *testing.T) {
+	pdb := `apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: web
+`
+	hpa := `apiVersion: autoscaling/v2beta2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: web
+`
+	cronJob := `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+`
+
+	testCases := []struct {
+		name     string
+		appPath  string
+		prYaml   string
+		target   string
+		expected []string // status, kind and whether removed of each usage
+	}{
+		{
+			name:     "Case 1: newly introduced usage removed from the cluster version",
+			appPath:  "prod/web",
+			prYaml:   pdb,
+			expected: []string{"new PodDisruptionBudget removed"},
+		},
+		{
+			name:     "Case 2: existing usage only deprecated in the cluster version",
+			appPath:  "staging/web",
+			prYaml:   hpa,
+			target:   hpa,
+			expected: []string{"existing HorizontalPodAutoscaler deprecated"},
+		},
+		{
+			name:     "Case 3: usage resolved by the change",
+			appPath:  "prod/web",
+			prYaml:   "apiVersion: batch/v1\nkind: CronJob\nmetadata:\n  name: backup\n",
+			target:   cronJob,
+			expected: []string{"resolved CronJob removed"},
+		},
+		{
+			name:    "Case 4: not yet deprecated in the cluster version",
+			appPath: "legacy/web",
+			prYaml:  hpa,
+		},
+		{
+			name:    "Case 5: environment without a kubernetes version",
+			appPath: "dev/web",
+			prYaml:  pdb,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			checker := NewChecker("", map[string]string{"prod": "v1.26.3", "staging": "1.23", "legacy": "1.20"}, "", log.New(io.Discard, "", 0))
+			result, err := checker.Check([]yaml.BuiltYaml{{
+				AppPath:          testCase.appPath,
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.target,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, usage := range result.Usages {
+				state := "deprecated"
+				if usage.Removed {
+					state = "removed"
+				}
+				actual = append(actual, usage.Status+" "+usage.ID.Kind+" "+state)
+			}
+			if len(actual) != len(testCase.expected) {
+				t.Fatalf("Expected %v, got %v", testCase.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != testCase.expected[i] {
+					t.Errorf("Expected %v, got %v", testCase.expected, actual)
+				}
+			}
+		})
+	}
+}
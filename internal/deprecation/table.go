@@ -0,0 +1,77 @@
+package deprecation
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// the bundled table, kept as data so it can be updated without code changes
+//
+//go:embed deprecations.yaml
+var bundledTable []byte
+
+// Deprecation is an apiVersion of a kind that kubernetes deprecated, and may have removed
+type Deprecation struct {
+	APIVersion   string `yaml:"apiVersion"`
+	Kind         string `yaml:"kind"`
+	DeprecatedIn string `yaml:"deprecatedIn"`
+	RemovedIn    string `yaml:"removedIn"`
+	Replacement  string `yaml:"replacement"`
+}
+
+// LoadTable reads a table of deprecations from a file, or the bundled table if path is empty
+func LoadTable(path string) ([]Deprecation, error) {
+	content := bundledTable
+	if path != "" {
+		var err error
+		content, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	table := []Deprecation{}
+	if err := goyaml.Unmarshal(content, &table); err != nil {
+		return nil, fmt.Errorf("error parsing deprecations table: %w", err)
+	}
+	for _, deprecation := range table {
+		for _, version := range []string{deprecation.DeprecatedIn, deprecation.RemovedIn} {
+			if _, err := parseVersion(version); version != "" && err != nil {
+				return nil, fmt.Errorf("error parsing deprecations of %s %s: %w", deprecation.APIVersion, deprecation.Kind, err)
+			}
+		}
+	}
+	return table, nil
+}
+
+// version is the major and minor of a kubernetes version, which is all deprecations depend on
+type version [2]int
+
+// parseVersion parses versions such as 1.25, v1.25 and v1.25.3
+func parseVersion(text string) (version, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(text), "v"), ".")
+	if len(parts) < 2 {
+		return version{}, fmt.Errorf("expected a version such as 1.25, got %q", text)
+	}
+	parsed := version{}
+	for i := range parsed {
+		number, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return version{}, fmt.Errorf("expected a version such as 1.25, got %q", text)
+		}
+		parsed[i] = number
+	}
+	return parsed, nil
+}
+
+// atLeast is whether a version is the same as or newer than another
+func (V version) atLeast(other version) bool {
+	if V[0] != other[0] {
+		return V[0] > other[0]
+	}
+	return V[1] >= other[1]
+}
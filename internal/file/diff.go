@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
 	"github.com/gosimple/hashdir"
 
 	"github.com/martinohmann/go-difflib/difflib"
@@ -18,20 +19,36 @@ type Diff struct {
 }
 
 type RealDiffer struct {
-	logger  *log.Logger
-	context int // lines of context around each diff.
-	colour  bool
+	logger           *log.Logger
+	context          int // lines of context around each diff.
+	colour           bool
+	maskHashSuffixes bool // pair kustomize generated objects whose hash suffix changed
 }
 
-func NewRealDiffer(logger *log.Logger, context int, colour bool) *RealDiffer {
+func NewRealDiffer(logger *log.Logger, context int, colour bool, maskHashSuffixes bool) *RealDiffer {
 	return &RealDiffer{
-		logger:  logger,
-		context: context,
-		colour:  colour,
+		logger:           logger,
+		context:          context,
+		colour:           colour,
+		maskHashSuffixes: maskHashSuffixes,
 	}
 }
 
 func (D *RealDiffer) Diff(a, b string) (string, error) {
+	notes := ""
+	if D.maskHashSuffixes {
+		maskedA, maskedB, renames, err := manifest.MaskRenamedHashes(a, b)
+		if err != nil {
+			// the diff is still useful without pairing, e.g. for yaml kustomize accepts but we can't parse
+			D.logger.Println("unable to pair generated objects, diffing unmodified yaml:", err)
+		} else {
+			a, b = maskedA, maskedB
+			for _, rename := range renames {
+				notes += "# " + rename.Note() + "\n"
+			}
+		}
+	}
+
 	diff := difflib.UnifiedDiff{
 		A:        difflib.SplitLines(a),
 		B:        difflib.SplitLines(b),
@@ -40,7 +57,11 @@ func (D *RealDiffer) Diff(a, b string) (string, error) {
 		Context:  D.context,
 		Color:    D.colour,
 	}
-	return difflib.GetUnifiedDiffString(diff)
+	unified, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		return "", err
+	}
+	return notes + unified, nil
 }
 
 func (D *RealDiffer) HasDiff(dir1, dir2 string) (bool, string, error) {
@@ -0,0 +1,72 @@
+package file
+
+import (
+	"sort"
+	"strings"
+)
+
+// The orders diffs can be presented in
+const (
+	OrderAlphabetical        = "alphabetical"
+	OrderDiffSize            = "diff-size"
+	OrderEnvironmentPriority = "environment-priority"
+)
+
+// Orderer sorts diffs so that comments come out in the same order on every run.
+// Every order falls back to sorting by environment then app path.
+type Orderer struct {
+	order      string
+	priorities map[string]int // the position of each environment in the priority list
+}
+
+func NewOrderer(order string, environmentPriority []string) *Orderer {
+	priorities := map[string]int{}
+	for i, environment := range environmentPriority {
+		priorities[environment] = i
+	}
+	return &Orderer{
+		order:      order,
+		priorities: priorities,
+	}
+}
+
+func (O *Orderer) Sort(diffs []Diff) {
+	sort.SliceStable(diffs, func(i, j int) bool {
+		a, b := diffs[i], diffs[j]
+		switch O.order {
+		case OrderDiffSize:
+			// largest diffs first
+			if sizeA, sizeB := strings.Count(a.Diff, "\n"), strings.Count(b.Diff, "\n"); sizeA != sizeB {
+				return sizeA > sizeB
+			}
+		case OrderEnvironmentPriority:
+			if priorityA, priorityB := O.priority(a.AppPath), O.priority(b.AppPath); priorityA != priorityB {
+				return priorityA < priorityB
+			}
+		}
+		return LessAppPath(a.AppPath, b.AppPath)
+	})
+}
+
+// priority returns the position of an app's environment in the priority list,
+// with unlisted environments after all listed ones
+func (O *Orderer) priority(appPath string) int {
+	if priority, ok := O.priorities[Environment(appPath)]; ok {
+		return priority
+	}
+	return len(O.priorities)
+}
+
+// Environment returns the environment an app belongs to, which is the first
+// element of its path for every app source
+func Environment(appPath string) string {
+	return strings.SplitN(appPath, "/", 2)[0]
+}
+
+// LessAppPath orders app paths by environment, then by the rest of the path
+func LessAppPath(a, b string) bool {
+	if environmentA, environmentB := Environment(a), Environment(b); environmentA != environmentB {
+		return environmentA < environmentB
+	}
+	return a < b
+}
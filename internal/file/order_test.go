@@ -0,0 +1,50 @@
+package file
+
+import (
+	"testing"
+)
+
+func TestOrderer_Sort(t *testing.T) {
+	input := []Diff{
+		{AppPath: "prod/apps/b", Diff: "+a\n"},
+		{AppPath: "dev-eu/apps/a", Diff: "+a\n+b\n+c\n"},
+		{AppPath: "dev/apps/z", Diff: "+a\n+b\n"},
+		{AppPath: "prod/apps/a", Diff: "+a\n"},
+	}
+
+	testCases := []struct {
+		name                string
+		order               string
+		environmentPriority []string
+		expectedOutput      []string
+	}{
+		{
+			name:           "Case 1: Alphabetical sorts by environment first",
+			order:          OrderAlphabetical,
+			expectedOutput: []string{"dev/apps/z", "dev-eu/apps/a", "prod/apps/a", "prod/apps/b"},
+		},
+		{
+			name:           "Case 2: Diff size, largest first",
+			order:          OrderDiffSize,
+			expectedOutput: []string{"dev-eu/apps/a", "dev/apps/z", "prod/apps/a", "prod/apps/b"},
+		},
+		{
+			name:                "Case 3: Environment priority, unlisted last",
+			order:               OrderEnvironmentPriority,
+			environmentPriority: []string{"prod", "dev-eu"},
+			expectedOutput:      []string{"prod/apps/a", "prod/apps/b", "dev-eu/apps/a", "dev/apps/z"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			diffs := append([]Diff{}, input...)
+			NewOrderer(testCase.order, testCase.environmentPriority).Sort(diffs)
+			for i, diff := range diffs {
+				if diff.AppPath != testCase.expectedOutput[i] {
+					t.Errorf("Expected diff %v to be %v, got %v", i, testCase.expectedOutput[i], diff.AppPath)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package flux
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// Builder renders Flux Kustomizations the way kustomize-controller would,
+// applying their kustomize options and post-build substitution, then renders
+// any HelmReleases they contain from locally available charts.
+type Builder struct {
+	finder          *AppFinder
+	prDir           string   // the directory where the PR branch is checked out
+	targetDir       string   // the directory where the target branch is checked out
+	chartsDir       string   // a directory of charts from helm repositories, as <chart>/ or <chart>-<version>.tgz
+	gitRepositories []string // the names of GitRepository sources that refer to this repository
+	runner          *yaml.Runner
+	logger          *log.Logger
+}
+
+func NewBuilder(
+	finder *AppFinder,
+	prDir string,
+	targetDir string,
+	chartsDir string,
+	gitRepositories []string,
+	runner *yaml.Runner,
+	logger *log.Logger,
+) *Builder {
+	return &Builder{
+		finder:          finder,
+		prDir:           prDir,
+		targetDir:       targetDir,
+		chartsDir:       chartsDir,
+		gitRepositories: gitRepositories,
+		runner:          runner,
+		logger:          logger,
+	}
+}
+
+func (B *Builder) Build(ctx context.Context, appPath string) (yaml.BuiltYaml, error) {
+	pair, ok := B.finder.App(appPath)
+	if !ok {
+		return yaml.BuiltYaml{}, fmt.Errorf("no Flux Kustomization found for %s", appPath)
+	}
+
+	prYaml, err := B.render(ctx, B.prDir, appPath, pair.Cluster, pair.PrBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+	targetYaml, err := B.render(ctx, B.targetDir, appPath, pair.Cluster, pair.TargetBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+
+	return yaml.BuiltYaml{
+		AppPath:          appPath,
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: targetYaml,
+	}, nil
+}
+
+func (B *Builder) render(ctx context.Context, branchDir, appPath, cluster string, kustomization *Kustomization) (string, error) {
+	if kustomization == nil {
+		return "", nil
+	}
+	name := kustomization.Metadata.Name
+	if !B.isLocalSource(kustomization.Spec.SourceRef) {
+		B.logger.Println("skipping Kustomization", name, "from another source:", kustomization.Spec.SourceRef.Name)
+		return "", nil
+	}
+
+	dir := filepath.Join(branchDir, kustomization.Spec.Path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "", fmt.Errorf("path %s of Kustomization %s does not exist", dir, name)
+	}
+
+	// flux generates a kustomization for directories without one, so an overlay is always valid
+	overlay := overlayFor(kustomization.Spec)
+	var rendered string
+	var err error
+	if len(overlay) == 0 && yaml.HasKustomization(dir) {
+		rendered, err = B.runner.Kustomize(ctx, appPath, dir)
+	} else {
+		rendered, err = B.runner.KustomizeOverlay(ctx, appPath, dir, overlay)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error building Kustomization %s: %w", name, err)
+	}
+
+	if kustomization.Spec.PostBuild != nil {
+		vars, err := B.variables(branchDir, cluster, kustomization.Metadata.Namespace, *kustomization.Spec.PostBuild)
+		if err != nil {
+			return "", fmt.Errorf("error resolving variables for Kustomization %s: %w", name, err)
+		}
+		rendered, err = substituteAll(rendered, vars)
+		if err != nil {
+			return "", fmt.Errorf("error substituting variables for Kustomization %s: %w", name, err)
+		}
+	}
+
+	charts, err := B.renderHelmReleases(ctx, branchDir, rendered)
+	if err != nil {
+		return "", fmt.Errorf("error rendering HelmReleases of Kustomization %s: %w", name, err)
+	}
+	return joinDocuments(append(splitDocuments(rendered), charts...)), nil
+}
+
+// variables resolves post-build variables. Inline variables take precedence over
+// those from ConfigMaps and Secrets, which are looked up in the cluster's directory
+// in the namespace of the Kustomization, as kustomize-controller does.
+func (B *Builder) variables(branchDir, cluster, namespace string, postBuild PostBuild) (map[string]string, error) {
+	vars := map[string]string{}
+	objects := B.finder.clusterObjects(branchDir, cluster)
+	for _, ref := range postBuild.SubstituteFrom {
+		object, ok := findDataObject(objects, ref.Kind, namespace, ref.Name)
+		if !ok {
+			if ref.Optional {
+				continue
+			}
+			return nil, fmt.Errorf("%s %s/%s not found in cluster %s", ref.Kind, namespace, ref.Name, cluster)
+		}
+		values, err := object.values()
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			vars[key] = value
+		}
+	}
+	for key, value := range postBuild.Substitute {
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// renderHelmReleases templates the chart of every HelmRelease in the rendered output
+func (B *Builder) renderHelmReleases(ctx context.Context, branchDir, rendered string) ([]string, error) {
+	objects := dataObjects(rendered)
+	charts := []string{}
+	for _, document := range splitDocuments(rendered) {
+		var release HelmRelease
+		if err := goyaml.Unmarshal([]byte(document), &release); err != nil {
+			return nil, err
+		}
+		if release.Kind != KindHelmRelease || !strings.HasPrefix(release.APIVersion, helmGroup) {
+			continue
+		}
+		out, err := B.renderHelmRelease(ctx, branchDir, release, objects)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering HelmRelease %s: %w", release.Metadata.Name, err)
+		}
+		charts = append(charts, splitDocuments(out)...)
+	}
+	return charts, nil
+}
+
+func (B *Builder) renderHelmRelease(ctx context.Context, branchDir string, release HelmRelease, objects []dataObject) (string, error) {
+	// rendering the rest of the app without the chart's resources would show them as deleted
+	chart, ok := B.resolveChart(branchDir, release.Spec.Chart.Spec)
+	if !ok {
+		spec := release.Spec.Chart.Spec
+		return "", &yaml.UnavailableError{Reason: fmt.Sprintf(
+			"chart %s of HelmRelease %s/%s from %s %s isn't available locally",
+			spec.Chart, release.Metadata.Namespace, release.Metadata.Name, spec.SourceRef.Kind, spec.SourceRef.Name,
+		)}
+	}
+
+	namespace := release.Spec.TargetNamespace
+	if namespace == "" {
+		namespace = release.Metadata.Namespace
+	}
+	releaseName := release.Spec.ReleaseName
+	if releaseName == "" {
+		releaseName = release.Metadata.Name
+		if release.Spec.TargetNamespace != "" {
+			releaseName = release.Spec.TargetNamespace + "-" + releaseName
+		}
+	}
+
+	args := []string{releaseName, chart, "--include-crds"}
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+
+	tempDir, err := os.MkdirTemp("", "kubediff-helmrelease-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tempDir)
+	for i, valuesFile := range release.Spec.Chart.Spec.ValuesFiles {
+		extractTo := filepath.Join(tempDir, fmt.Sprintf("values-file-%d.yaml", i))
+		valuesPath, err := B.valuesFile(branchDir, chart, release.Spec.Chart.Spec, valuesFile, extractTo)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--values", valuesPath)
+	}
+
+	// values from ConfigMaps and Secrets in the HelmRelease's namespace are merged in order,
+	// then overridden by inline values
+	for i, ref := range release.Spec.ValuesFrom {
+		object, ok := findDataObject(objects, ref.Kind, release.Metadata.Namespace, ref.Name)
+		if !ok {
+			if ref.Optional {
+				continue
+			}
+			return "", fmt.Errorf("%s %s/%s referenced in valuesFrom not found", ref.Kind, release.Metadata.Namespace, ref.Name)
+		}
+		values, err := object.values()
+		if err != nil {
+			return "", err
+		}
+		key := ref.ValuesKey
+		if key == "" {
+			key = "values.yaml"
+		}
+		value, ok := values[key]
+		if !ok {
+			if ref.Optional {
+				continue
+			}
+			return "", fmt.Errorf("key %s not found in %s %s", key, ref.Kind, ref.Name)
+		}
+		if ref.TargetPath != "" {
+			args = append(args, "--set-string", ref.TargetPath+"="+value)
+			continue
+		}
+		valuesPath := filepath.Join(tempDir, fmt.Sprintf("values-from-%d.yaml", i))
+		if err := os.WriteFile(valuesPath, []byte(value), 0o644); err != nil {
+			return "", err
+		}
+		args = append(args, "--values", valuesPath)
+	}
+	if len(release.Spec.Values) > 0 {
+		content, err := goyaml.Marshal(release.Spec.Values)
+		if err != nil {
+			return "", err
+		}
+		valuesPath := filepath.Join(tempDir, "values.yaml")
+		if err := os.WriteFile(valuesPath, content, 0o644); err != nil {
+			return "", err
+		}
+		args = append(args, "--values", valuesPath)
+	}
+
+	return B.runner.Helm(ctx, args...)
+}
+
+// resolveChart finds a chart on disk: in this repository for GitRepository
+// sources, or in the charts directory for HelmRepository sources.
+func (B *Builder) resolveChart(branchDir string, spec HelmChartSpec) (string, bool) {
+	switch spec.SourceRef.Kind {
+	case "GitRepository":
+		if !B.isLocalSource(spec.SourceRef) {
+			return "", false
+		}
+		chart := filepath.Join(branchDir, spec.Chart)
+		return chart, fileExists(filepath.Join(chart, "Chart.yaml"))
+	case "HelmRepository":
+		if B.chartsDir == "" {
+			return "", false
+		}
+		if spec.Version != "" {
+			archive := filepath.Join(B.chartsDir, fmt.Sprintf("%s-%s.tgz", spec.Chart, spec.Version))
+			if fileExists(archive) {
+				return archive, true
+			}
+		}
+		chart := filepath.Join(B.chartsDir, spec.Chart)
+		return chart, fileExists(filepath.Join(chart, "Chart.yaml"))
+	default:
+		return "", false
+	}
+}
+
+// valuesFile finds one of a chart's valuesFiles, which Flux reads from the chart's source: the root
+// of this repository for GitRepository sources, or the chart itself for HelmRepository sources,
+// extracting it to extractTo from a chart archive
+func (B *Builder) valuesFile(branchDir, chart string, spec HelmChartSpec, valuesFile, extractTo string) (string, error) {
+	if spec.SourceRef.Kind == "GitRepository" {
+		valuesPath := filepath.Join(branchDir, valuesFile)
+		if !fileExists(valuesPath) {
+			return "", fmt.Errorf("values file %s not found in GitRepository %s", valuesFile, spec.SourceRef.Name)
+		}
+		return valuesPath, nil
+	}
+	if strings.HasSuffix(chart, ".tgz") {
+		if err := extractChartFile(chart, valuesFile, extractTo); err != nil {
+			return "", err
+		}
+		return extractTo, nil
+	}
+	valuesPath := filepath.Join(chart, valuesFile)
+	if !fileExists(valuesPath) {
+		return "", fmt.Errorf("values file %s not found in chart %s", valuesFile, spec.Chart)
+	}
+	return valuesPath, nil
+}
+
+func (B *Builder) isLocalSource(ref CrossNamespaceRef) bool {
+	if ref.Kind != "" && ref.Kind != "GitRepository" {
+		return false
+	}
+	// with no repositories configured we assume every GitRepository is this one
+	if len(B.gitRepositories) == 0 {
+		return true
+	}
+	for _, name := range B.gitRepositories {
+		if name == ref.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayFor converts a Kustomization's options into kustomize overlay fields
+func overlayFor(spec KustomizationSpec) map[string]interface{} {
+	overlay := map[string]interface{}{}
+	if spec.TargetNamespace != "" {
+		overlay["namespace"] = spec.TargetNamespace
+	}
+	if spec.NamePrefix != "" {
+		overlay["namePrefix"] = spec.NamePrefix
+	}
+	if spec.NameSuffix != "" {
+		overlay["nameSuffix"] = spec.NameSuffix
+	}
+	if len(spec.Images) > 0 {
+		overlay["images"] = spec.Images
+	}
+	if len(spec.Patches) > 0 {
+		overlay["patches"] = spec.Patches
+	}
+	if len(spec.Components) > 0 {
+		overlay["components"] = spec.Components
+	}
+	if spec.CommonMetadata != nil {
+		if len(spec.CommonMetadata.Labels) > 0 {
+			overlay["labels"] = []map[string]interface{}{{"pairs": spec.CommonMetadata.Labels}}
+		}
+		if len(spec.CommonMetadata.Annotations) > 0 {
+			overlay["commonAnnotations"] = spec.CommonMetadata.Annotations
+		}
+	}
+	return overlay
+}
+
+// findDataObject finds a ConfigMap or Secret by its namespace and name, as references
+// to them can't cross namespaces
+func findDataObject(objects []dataObject, kind, namespace, name string) (dataObject, bool) {
+	for _, object := range objects {
+		if object.Kind == kind && object.Metadata.Namespace == namespace && object.Metadata.Name == name {
+			return object, true
+		}
+	}
+	return dataObject{}, false
+}
+
+// extractChartFile extracts a file of a chart archive, by its path within the chart
+func extractChartFile(archive, name, dest string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("error reading chart %s: %w", archive, err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	want := path.Clean(filepath.ToSlash(name))
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return fmt.Errorf("values file %s not found in chart %s", name, archive)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading chart %s: %w", archive, err)
+		}
+		// the files of a chart archive are under a directory named after the chart
+		_, chartPath, _ := strings.Cut(header.Name, "/")
+		if header.Typeflag != tar.TypeReg || chartPath != want {
+			continue
+		}
+		content, err := io.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, content, 0o644)
+	}
+}
+
+func fileExists(filePath string) bool {
+	exists, err := utils.FileExists(filePath)
+	return err == nil && exists
+}
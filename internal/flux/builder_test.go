@@ -0,0 +1,303 @@
+package flux
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fakeHelm prints a ConfigMap named after the release, holding every values file it is given
+const fakeHelm = `#!/bin/sh
+printf 'apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\ndata:\n' "$2"
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--values" ]; then sed 's/^/  /' "$2"; echo; shift; fi
+  shift
+done
+`
+
+func TestBuilder_Build(t *testing.T) {
+	binDir := t.TempDir()
+	testutil.WriteFiles(t, binDir, map[string]string{"helm": fakeHelm})
+	if err := os.Chmod(filepath.Join(binDir, "helm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// same-named ConfigMaps in other namespaces come first, so only matching the namespace finds the right one
+	repo := map[string]string{
+		"clusters/prod/apps.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: vars
+  namespace: other
+data:
+  colour: red
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: vars
+  namespace: flux-system
+data:
+  colour: blue
+---
+apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: web
+  namespace: flux-system
+spec:
+  path: ./apps/web
+  sourceRef:
+    kind: GitRepository
+    name: flux-system
+  postBuild:
+    substitute:
+      replicas: "3"
+    substituteFrom:
+    - kind: ConfigMap
+      name: vars
+`,
+		"apps/web/kustomization.yaml": "resources: [configmap.yaml, release.yaml]\n",
+		"apps/web/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: web
+data:
+  colour: ${colour}
+  replicas: "${replicas}"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-values
+  namespace: other
+data:
+  values.yaml: "size: large"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-values
+  namespace: web
+data:
+  values.yaml: "size: small"
+`,
+		"apps/web/release.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: frontend
+  namespace: web
+spec:
+  chart:
+    spec:
+      chart: ./charts/web
+      sourceRef:
+        kind: GitRepository
+        name: flux-system
+  valuesFrom:
+  - kind: ConfigMap
+    name: web-values
+  values:
+    tier: frontend
+`,
+		"charts/web/Chart.yaml": "name: web\nversion: 0.1.0\n",
+	}
+	prDir, targetDir := t.TempDir(), t.TempDir()
+	testutil.WriteFiles(t, prDir, repo)
+
+	logger := log.New(io.Discard, "", 0)
+	finder := NewAppFinder(prDir, targetDir, "clusters", logger)
+	if _, err := finder.GetAllAppPaths(); err != nil {
+		t.Fatal(err)
+	}
+	runner := yaml.NewRunner(filesys.MakeFsOnDisk(), yaml.KustomizeLibrary, yaml.KustomizeFlags{}, nil, logger)
+	builder := NewBuilder(finder, prDir, targetDir, "", nil, runner, logger)
+
+	built, err := builder.Build(context.Background(), "prod/flux-system/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if built.YamlTargetBranch != "" {
+		t.Errorf("Expected nothing to be rendered in the target branch, got:\n%v", built.YamlTargetBranch)
+	}
+	for _, expected := range []string{
+		"colour: blue",      // substituted from the Kustomization's namespace
+		"replicas: 3",       // substituted inline
+		"kind: HelmRelease", // the HelmRelease itself is kept
+	} {
+		if !strings.Contains(built.YamlPrBranch, expected) {
+			t.Errorf("Expected the PR branch to contain %q, got:\n%v", expected, built.YamlPrBranch)
+		}
+	}
+	if strings.Contains(built.YamlPrBranch, "colour: red") {
+		t.Errorf("Expected no variables from another namespace, got:\n%v", built.YamlPrBranch)
+	}
+
+	// the values of the release come from its own namespace, then its inline values
+	_, release, found := strings.Cut(built.YamlPrBranch, "name: frontend\ndata:\n")
+	if !found {
+		t.Fatalf("Expected the chart of the HelmRelease to be rendered, got:\n%v", built.YamlPrBranch)
+	}
+	if expected := "size: small\n  tier: frontend"; strings.TrimSpace(release) != expected {
+		t.Errorf("Expected release values:\n%v\ngot:\n%v", expected, release)
+	}
+}
+
+// chartArchive packs files into a chart archive as `helm package` does
+func chartArchive(t *testing.T, name string, files map[string]string) string {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for fileName, content := range files {
+		header := &tar.Header{Name: name + "/" + fileName, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestBuilder_Build_ValuesFiles(t *testing.T) {
+	binDir := t.TempDir()
+	testutil.WriteFiles(t, binDir, map[string]string{"helm": fakeHelm})
+	if err := os.Chmod(filepath.Join(binDir, "helm"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	chartsDir := t.TempDir()
+	testutil.WriteFiles(t, chartsDir, map[string]string{
+		"redis-17.0.0.tgz": chartArchive(t, "redis", map[string]string{
+			"Chart.yaml":       "name: redis\nversion: 17.0.0\n",
+			"values-prod.yaml": "size: archived",
+		}),
+	})
+
+	testCases := []struct {
+		name            string
+		chartSpec       string
+		expected        string
+		expectedError   string
+		expectedFailure bool // whether only the app's render fails, rather than the run
+	}{
+		{
+			name: "Case 1: GitRepository values files are relative to the repository",
+			chartSpec: `chart: ./charts/web
+      sourceRef:
+        kind: GitRepository
+        name: flux-system
+      valuesFiles:
+      - ./apps/web/values-prod.yaml`,
+			expected: "size: from-repo",
+		},
+		{
+			name: "Case 2: HelmRepository values files are in the chart archive",
+			chartSpec: `chart: redis
+      version: 17.0.0
+      sourceRef:
+        kind: HelmRepository
+        name: bitnami
+      valuesFiles:
+      - values-prod.yaml`,
+			expected: "size: archived",
+		},
+		{
+			name: "Case 3: missing values file",
+			chartSpec: `chart: ./charts/web
+      sourceRef:
+        kind: GitRepository
+        name: flux-system
+      valuesFiles:
+      - values-prod.yaml`,
+			expectedError: "values file values-prod.yaml not found in GitRepository flux-system",
+		},
+		{
+			name: "Case 4: chart that isn't available",
+			chartSpec: `chart: redis
+      version: 18.0.0
+      sourceRef:
+        kind: HelmRepository
+        name: bitnami`,
+			expectedError:   "chart redis of HelmRelease web/frontend from HelmRepository bitnami isn't available locally",
+			expectedFailure: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			prDir, targetDir := t.TempDir(), t.TempDir()
+			testutil.WriteFiles(t, prDir, map[string]string{
+				"clusters/prod/apps.yaml": `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: web
+  namespace: flux-system
+spec:
+  path: ./apps/web
+  sourceRef:
+    kind: GitRepository
+    name: flux-system
+`,
+				"apps/web/release.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: frontend
+  namespace: web
+spec:
+  chart:
+    spec:
+      ` + testCase.chartSpec + `
+`,
+				"apps/web/kustomization.yaml": "resources: [release.yaml]\n",
+				"apps/web/values-prod.yaml":   "size: from-repo",
+				"charts/web/Chart.yaml":       "name: web\nversion: 0.1.0\n",
+			})
+
+			logger := log.New(io.Discard, "", 0)
+			finder := NewAppFinder(prDir, targetDir, "clusters", logger)
+			if _, err := finder.GetAllAppPaths(); err != nil {
+				t.Fatal(err)
+			}
+			runner := yaml.NewRunner(filesys.MakeFsOnDisk(), yaml.KustomizeLibrary, yaml.KustomizeFlags{}, nil, logger)
+			builder := NewBuilder(finder, prDir, targetDir, chartsDir, nil, runner, logger)
+
+			built, err := builder.Build(context.Background(), "prod/flux-system/web")
+			if testCase.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+					t.Fatalf("Expected error containing %q, got %v", testCase.expectedError, err)
+				}
+				if yaml.IsRenderFailure(err) != testCase.expectedFailure {
+					t.Errorf("Expected the error to be a render failure: %v, got %v", testCase.expectedFailure, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, release, found := strings.Cut(built.YamlPrBranch, "name: frontend\ndata:\n")
+			if !found || strings.TrimSpace(release) != testCase.expected {
+				t.Errorf("Expected release values %q, got:\n%v", testCase.expected, built.YamlPrBranch)
+			}
+		})
+	}
+}
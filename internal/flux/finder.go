@@ -0,0 +1,154 @@
+package flux
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// KustomizationPair holds the definition of a Kustomization in each branch.
+// Either side is nil when the Kustomization was added or removed by the PR.
+type KustomizationPair struct {
+	Cluster      string
+	PrBranch     *Kustomization
+	TargetBranch *Kustomization
+}
+
+// branchObjects is everything found in a single checkout of the Flux directory
+type branchObjects struct {
+	kustomizations map[string]*Kustomization
+	// ConfigMaps and Secrets per cluster, used for postBuild.substituteFrom
+	dataObjects map[string][]dataObject
+}
+
+// AppFinder discovers Flux Kustomizations in both checkouts of the repository.
+// Each app is identified by "<cluster>/<namespace>/<name>", where the cluster is
+// the first directory under fluxDir that the Kustomization is defined in.
+type AppFinder struct {
+	apps      map[string]*KustomizationPair // every Kustomization found, keyed by app path
+	branches  map[string]*branchObjects     // the objects found per branch directory
+	prDir     string                        // the directory where the PR branch is checked out
+	targetDir string                        // the directory where the target branch is checked out
+	fluxDir   string                        // the directory containing each cluster's Flux definitions
+	logger    *log.Logger
+}
+
+func NewAppFinder(prDir, targetDir, fluxDir string, logger *log.Logger) *AppFinder {
+	return &AppFinder{
+		apps:      map[string]*KustomizationPair{},
+		branches:  map[string]*branchObjects{},
+		prDir:     prDir,
+		targetDir: targetDir,
+		fluxDir:   fluxDir,
+		logger:    logger,
+	}
+}
+
+func (F *AppFinder) GetAllAppPaths() (utils.Set, error) {
+	for _, branchDir := range []string{F.prDir, F.targetDir} {
+		objects, err := F.load(branchDir)
+		if err != nil {
+			return nil, err
+		}
+		F.branches[branchDir] = objects
+		for key, kustomization := range objects.kustomizations {
+			pair, ok := F.apps[key]
+			if !ok {
+				pair = &KustomizationPair{Cluster: strings.SplitN(key, "/", 2)[0]}
+				F.apps[key] = pair
+			}
+			if branchDir == F.prDir {
+				pair.PrBranch = kustomization
+			} else {
+				pair.TargetBranch = kustomization
+			}
+		}
+	}
+
+	paths := utils.NewSet()
+	for key := range F.apps {
+		F.logger.Println("found Flux Kustomization:", key)
+		paths.Add(key)
+	}
+	return paths, nil
+}
+
+// App returns the definition of a Kustomization in both branches
+func (F *AppFinder) App(appPath string) (*KustomizationPair, bool) {
+	pair, ok := F.apps[appPath]
+	return pair, ok
+}
+
+// clusterObjects returns the ConfigMaps and Secrets defined for a cluster in a branch
+func (F *AppFinder) clusterObjects(branchDir, cluster string) []dataObject {
+	objects, ok := F.branches[branchDir]
+	if !ok {
+		return nil
+	}
+	return objects.dataObjects[cluster]
+}
+
+func (F *AppFinder) load(branchDir string) (*branchObjects, error) {
+	objects := &branchObjects{
+		kustomizations: map[string]*Kustomization{},
+		dataObjects:    map[string][]dataObject{},
+	}
+	root := filepath.Join(branchDir, F.fluxDir)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		F.logger.Println("flux directory doesn't exist:", root)
+		return objects, nil
+	}
+
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		extension := strings.ToLower(filepath.Ext(filePath))
+		if entry.IsDir() || (extension != ".yaml" && extension != ".yml") {
+			return nil
+		}
+		relative, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		cluster := strings.SplitN(filepath.ToSlash(relative), "/", 2)[0]
+		if cluster == filepath.ToSlash(relative) {
+			// files at the root of the flux directory aren't in a cluster
+			cluster = ""
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		objects.dataObjects[cluster] = append(objects.dataObjects[cluster], dataObjects(string(content))...)
+
+		for _, document := range splitDocuments(string(content)) {
+			var kustomization Kustomization
+			if err := yaml.Unmarshal([]byte(document), &kustomization); err != nil {
+				continue
+			}
+			if kustomization.Kind != KindKustomization || !strings.HasPrefix(kustomization.APIVersion, kustomizeGroup) {
+				continue
+			}
+			key := path.Join(cluster, kustomization.Metadata.Namespace, kustomization.Metadata.Name)
+			if _, ok := objects.kustomizations[key]; ok {
+				return fmt.Errorf("Kustomization %s is defined more than once in %s", key, branchDir)
+			}
+			F.logger.Println("found Kustomization", key, "in", filePath)
+			objects.kustomizations[key] = &kustomization
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
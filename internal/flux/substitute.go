@@ -0,0 +1,111 @@
+package flux
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// variable matches the ${var}, ${var:=default} and ${var:-default} forms
+// that Flux's post-build substitution supports. $${var} escapes substitution.
+var variable = regexp.MustCompile(`\$?\$\{([a-zA-Z_][a-zA-Z0-9_]*)(?::?[-=]([^}]*))?\}`)
+
+// substitute replaces post-build variables in a single document. Variables that
+// are unset and have no default are replaced with an empty string, as Flux does.
+func substitute(document string, vars map[string]string) string {
+	return variable.ReplaceAllStringFunc(document, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		groups := variable.FindStringSubmatch(match)
+		if value, ok := vars[groups[1]]; ok {
+			return value
+		}
+		return groups[2]
+	})
+}
+
+// substituteAll substitutes every document in a multi-document string,
+// skipping those that opt out with the substitute label or annotation.
+func substituteAll(rendered string, vars map[string]string) (string, error) {
+	documents := splitDocuments(rendered)
+	for i, document := range documents {
+		var header struct {
+			Metadata Metadata `yaml:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(document), &header); err != nil {
+			return "", fmt.Errorf("error parsing rendered document: %w", err)
+		}
+		if header.Metadata.Labels[substituteLabel] == "disabled" ||
+			header.Metadata.Annotations[substituteLabel] == "disabled" {
+			continue
+		}
+		documents[i] = substitute(document, vars)
+	}
+	return joinDocuments(documents), nil
+}
+
+// splitDocuments splits a multi-document yaml string on its separators,
+// dropping empty documents.
+func splitDocuments(rendered string) []string {
+	documents := []string{}
+	current := []string{}
+	flush := func() {
+		document := strings.Join(current, "\n")
+		if strings.TrimSpace(document) != "" {
+			documents = append(documents, strings.TrimSuffix(document, "\n")+"\n")
+		}
+		current = []string{}
+	}
+	for _, line := range strings.Split(rendered, "\n") {
+		if strings.TrimRight(line, " ") == "---" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return documents
+}
+
+func joinDocuments(documents []string) string {
+	return strings.Join(documents, "---\n")
+}
+
+// values returns the decoded contents of a ConfigMap or Secret
+func (D dataObject) values() (map[string]string, error) {
+	values := map[string]string{}
+	for key, value := range D.Data {
+		if D.Kind == KindSecret {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding key %s of Secret %s: %w", key, D.Metadata.Name, err)
+			}
+			value = string(decoded)
+		}
+		values[key] = value
+	}
+	for key, value := range D.StringData {
+		values[key] = value
+	}
+	return values, nil
+}
+
+// dataObjects finds every ConfigMap and Secret in a multi-document string
+func dataObjects(rendered string) []dataObject {
+	objects := []dataObject{}
+	for _, document := range splitDocuments(rendered) {
+		var object dataObject
+		if err := yaml.Unmarshal([]byte(document), &object); err != nil {
+			// not every document is guaranteed to be well formed before substitution
+			continue
+		}
+		if object.Kind == KindConfigMap || object.Kind == KindSecret {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
@@ -0,0 +1,90 @@
+package flux
+
+import (
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		vars           map[string]string
+		expectedOutput string
+	}{
+		{
+			name:           "Case 1: Set variable",
+			input:          "replicas: ${replicas}",
+			vars:           map[string]string{"replicas": "3"},
+			expectedOutput: "replicas: 3",
+		},
+		{
+			name:           "Case 2: Default used when unset",
+			input:          "env: ${cluster_env:=dev} ${region:-eu}",
+			vars:           map[string]string{},
+			expectedOutput: "env: dev eu",
+		},
+		{
+			name:           "Case 3: Unset without default is empty",
+			input:          "name: app-${suffix}",
+			vars:           map[string]string{},
+			expectedOutput: "name: app-",
+		},
+		{
+			name:           "Case 4: Escaped variable is kept",
+			input:          "script: echo $${HOME}",
+			vars:           map[string]string{"HOME": "/root"},
+			expectedOutput: "script: echo ${HOME}",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := substitute(testCase.input, testCase.vars)
+			if result != testCase.expectedOutput {
+				t.Errorf("Expected %v, got %v", testCase.expectedOutput, result)
+			}
+		})
+	}
+}
+
+func TestSubstituteAll(t *testing.T) {
+	input := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: substituted
+data:
+  cluster: ${cluster}
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+  annotations:
+    kustomize.toolkit.fluxcd.io/substitute: disabled
+data:
+  cluster: ${cluster}
+`
+	expected := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: substituted
+data:
+  cluster: prod
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+  annotations:
+    kustomize.toolkit.fluxcd.io/substitute: disabled
+data:
+  cluster: ${cluster}
+`
+	result, err := substituteAll(input, map[string]string{"cluster": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,108 @@
+package flux
+
+// The types below mirror the subset of the Flux Kustomization and HelmRelease
+// schemas that affect how manifests are rendered.
+
+const (
+	KindKustomization = "Kustomization"
+	KindHelmRelease   = "HelmRelease"
+	KindConfigMap     = "ConfigMap"
+	KindSecret        = "Secret"
+
+	kustomizeGroup = "kustomize.toolkit.fluxcd.io/"
+	helmGroup      = "helm.toolkit.fluxcd.io/"
+
+	// documents with this label or annotation set to "disabled" are not substituted
+	substituteLabel = "kustomize.toolkit.fluxcd.io/substitute"
+)
+
+type Metadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type Kustomization struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   Metadata          `yaml:"metadata"`
+	Spec       KustomizationSpec `yaml:"spec"`
+}
+
+type KustomizationSpec struct {
+	Path            string                   `yaml:"path"`
+	SourceRef       CrossNamespaceRef        `yaml:"sourceRef"`
+	TargetNamespace string                   `yaml:"targetNamespace"`
+	NamePrefix      string                   `yaml:"namePrefix"`
+	NameSuffix      string                   `yaml:"nameSuffix"`
+	Images          []map[string]interface{} `yaml:"images"`
+	Patches         []map[string]interface{} `yaml:"patches"`
+	Components      []string                 `yaml:"components"`
+	CommonMetadata  *CommonMetadata          `yaml:"commonMetadata"`
+	PostBuild       *PostBuild               `yaml:"postBuild"`
+}
+
+type CrossNamespaceRef struct {
+	Kind      string `yaml:"kind"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+type CommonMetadata struct {
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type PostBuild struct {
+	Substitute     map[string]string `yaml:"substitute"`
+	SubstituteFrom []SubstituteRef   `yaml:"substituteFrom"`
+}
+
+type SubstituteRef struct {
+	Kind     string `yaml:"kind"`
+	Name     string `yaml:"name"`
+	Optional bool   `yaml:"optional"`
+}
+
+type HelmRelease struct {
+	APIVersion string          `yaml:"apiVersion"`
+	Kind       string          `yaml:"kind"`
+	Metadata   Metadata        `yaml:"metadata"`
+	Spec       HelmReleaseSpec `yaml:"spec"`
+}
+
+type HelmReleaseSpec struct {
+	ReleaseName     string                 `yaml:"releaseName"`
+	TargetNamespace string                 `yaml:"targetNamespace"`
+	Chart           HelmChartTemplate      `yaml:"chart"`
+	Values          map[string]interface{} `yaml:"values"`
+	ValuesFrom      []ValuesReference      `yaml:"valuesFrom"`
+}
+
+type HelmChartTemplate struct {
+	Spec HelmChartSpec `yaml:"spec"`
+}
+
+type HelmChartSpec struct {
+	Chart       string            `yaml:"chart"`
+	Version     string            `yaml:"version"`
+	SourceRef   CrossNamespaceRef `yaml:"sourceRef"`
+	ValuesFiles []string          `yaml:"valuesFiles"`
+}
+
+type ValuesReference struct {
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	ValuesKey  string `yaml:"valuesKey"`
+	TargetPath string `yaml:"targetPath"`
+	Optional   bool   `yaml:"optional"`
+}
+
+// dataObject is a ConfigMap or Secret, used for post-build variables and helm values
+type dataObject struct {
+	Kind       string            `yaml:"kind"`
+	Metadata   Metadata          `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+	StringData map[string]string `yaml:"stringData"`
+}
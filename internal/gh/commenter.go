@@ -13,13 +13,11 @@ import (
 
 // Commenter is a struct for interacting with the GitHub API.
 // client: The GitHub client used to interact with the API.
-// ctx: The context used for API requests.
 // owner: The owner of the repository where comments will be posted.
 // repo: The repository where comments will be posted.
 // prNumber: The prNumber of the pull request where comments will be posted.
 type Commenter struct {
 	client          *github.Client
-	ctx             context.Context
 	owner           string
 	repo            string
 	prNumber        int
@@ -31,17 +29,15 @@ type Commenter struct {
 // It takes the owner of the repository, the repository name, and the prNumber of the pull request as parameters.
 // It returns a new instance of Commenter.
 func NewCommenter(owner string, repo string, number int, personalAccessToken string, logger *log.Logger) *Commenter {
-	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: personalAccessToken},
 	)
-	tc := oauth2.NewClient(ctx, ts)
+	tc := oauth2.NewClient(context.Background(), ts)
 
 	client := github.NewClient(tc)
 
 	return &Commenter{
 		client:          client,
-		ctx:             ctx,
 		owner:           owner,
 		repo:            repo,
 		prNumber:        number,
@@ -52,8 +48,8 @@ func NewCommenter(owner string, repo string, number int, personalAccessToken str
 
 // Comment posts comments on a specific pull request.
 // It takes a slice of comments as input and posts each comment on the pull request.
-// It returns an error if any occurs during the process.
-func (c *Commenter) Comment(comments []string) error {
+// It returns an error if any occurs during the process, or once ctx is done.
+func (c *Commenter) Comment(ctx context.Context, comments []string) error {
 	totalComments := 0
 	for i, comment := range comments {
 		commentId := fmt.Sprintf("%s-%d", c.CommentIdPrefix, i)
@@ -70,7 +66,7 @@ func (c *Commenter) Comment(comments []string) error {
 `
 		comment = fmt.Sprintf(template, title, comment, commentId)
 		_, response, err := c.client.Issues.CreateComment(
-			c.ctx,
+			ctx,
 			c.owner,
 			c.repo,
 			c.prNumber,
@@ -89,27 +85,37 @@ func (c *Commenter) Comment(comments []string) error {
 }
 
 // Delete all comments made by the previous run of this tool.
-func (c *Commenter) DeleteAllToolComments() error {
+func (c *Commenter) DeleteAllToolComments(ctx context.Context) error {
 	c.logger.Printf("Listing comments")
-	comments, _, err := c.client.Issues.ListComments(c.ctx, c.owner, c.repo, c.prNumber, &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}})
-	if err != nil {
-		return err
+	comments := []*github.IssueComment{}
+	options := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := c.client.Issues.ListComments(ctx, c.owner, c.repo, c.prNumber, options)
+		if err != nil {
+			return err
+		}
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		options.Page = resp.NextPage
 	}
-	c.logger.Printf("found %d comments to delete", len(comments))
+	c.logger.Printf("found %d comments", len(comments))
 
-	for i, comment := range comments {
-		if strings.Contains(*comment.Body, fmt.Sprintf("%s-%d", c.CommentIdPrefix, i)) {
-			// if true {
-			resp, err := c.client.Issues.DeleteComment(c.ctx, c.owner, c.repo, *comment.ID)
+	// every comment of a run is tagged with the prefix and its index within the run
+	tag := fmt.Sprintf("<!-- %s-", c.CommentIdPrefix)
+	for _, comment := range comments {
+		if strings.Contains(comment.GetBody(), tag) {
+			resp, err := c.client.Issues.DeleteComment(ctx, c.owner, c.repo, comment.GetID())
+			if err != nil {
+				return err
+			}
 			if resp.StatusCode != 204 {
 				c.logger.Println("Failed to delete comment: " + fmt.Sprintf("%v", resp))
 				break
 			}
-			if err != nil {
-				return err
-			}
 		} else {
-			c.logger.Printf("Skipping comment: %s", *comment.Body)
+			c.logger.Printf("Skipping comment: %s", comment.GetBody())
 		}
 	}
 	return nil
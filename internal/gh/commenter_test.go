@@ -0,0 +1,55 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func TestCommenter_DeleteAllToolComments(t *testing.T) {
+	// two pages of comments, with the tool's comments from the last run at the end of the second
+	comments := []map[string]interface{}{}
+	for id := 1; id <= 150; id++ {
+		body := "lgtm"
+		if id > 147 {
+			body = fmt.Sprintf("diff\n<!-- bot-comment-kubediff-%d -->\n", id-148)
+		}
+		comments = append(comments, map[string]interface{}{"id": id, "body": body})
+	}
+	deleted := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page := comments[:100]
+			if r.URL.Query().Get("page") == "2" {
+				page = comments[100:]
+			} else {
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?page=2>; rel="next"`, "http://"+r.Host, r.URL.Path))
+			}
+			_ = json.NewEncoder(w).Encode(page)
+		case http.MethodDelete:
+			var id int
+			_, _ = fmt.Sscanf(r.URL.Path, "/repos/org/repo/issues/comments/%d", &id)
+			deleted = append(deleted, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	commenter := NewCommenter("org", "repo", 1, "token", log.New(io.Discard, "", 0))
+	commenter.client.BaseURL, _ = url.Parse(server.URL + "/")
+	if err := commenter.DeleteAllToolComments(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(deleted)
+	if fmt.Sprint(deleted) != "[148 149 150]" {
+		t.Errorf("Expected the tool's comments on the second page to be deleted, got %v", deleted)
+	}
+}
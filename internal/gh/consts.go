@@ -15,6 +15,15 @@ const MaxGithubCommentLength = 253336 // characters
 //go:embed git-diff-template.txt
 var GitCommentTemplate string
 
+//go:embed report-section-template.txt
+var ReportSectionTemplate string
+
+//go:embed warning-banner-template.txt
+var WarningBannerTemplate string
+
+//go:embed footer-template.txt
+var FooterTemplate string
+
 // 50 is a buffer for the rest of the comment, like the header and footer
 var MaxCommentLength = MaxGithubCommentLength - len(GitCommentTemplate) - 50
 
@@ -0,0 +1,101 @@
+package images
+
+import (
+	"strconv"
+	"strings"
+)
+
+// defaultRegistry is where images without a registry are pulled from
+const defaultRegistry = "docker.io"
+
+// Reference is a parsed container image reference, e.g. ghcr.io/org/app:v1.2.3@sha256:...
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image into its parts, the way the container runtime resolves them
+func ParseReference(image string) Reference {
+	reference := Reference{}
+	name, digest, found := strings.Cut(image, "@")
+	if found {
+		reference.Digest = digest
+	}
+	// a tag follows the last colon, unless that colon is part of a registry's port
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		reference.Tag = name[i+1:]
+		name = name[:i]
+	}
+	first, rest, found := strings.Cut(name, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		reference.Registry = first
+		reference.Repository = rest
+	} else {
+		reference.Registry = defaultRegistry
+		reference.Repository = name
+	}
+	if reference.Tag == "" && reference.Digest == "" {
+		reference.Tag = "latest"
+	}
+	return reference
+}
+
+// Version describes the tag and digest of a reference
+func (R Reference) Version() string {
+	version := R.Tag
+	if R.Digest != "" {
+		digest := R.Digest
+		// the algorithm and a short hash are enough to tell digests apart
+		if algorithm, hash, found := strings.Cut(digest, ":"); found && len(hash) > 12 {
+			digest = algorithm + ":" + hash[:12]
+		}
+		if version != "" {
+			version += "@"
+		}
+		version += digest
+	}
+	return version
+}
+
+// semver is a parsed semantic version tag
+type semver struct {
+	numbers    [3]int
+	prerelease string
+}
+
+// parseSemver parses tags such as 1.2.3, v1.2 and 1.2.3-rc.1, ignoring build metadata
+func parseSemver(tag string) (semver, bool) {
+	version := semver{}
+	tag, _, _ = strings.Cut(strings.TrimPrefix(tag, "v"), "+")
+	tag, version.prerelease, _ = strings.Cut(tag, "-")
+	parts := strings.Split(tag, ".")
+	if len(parts) > 3 {
+		return version, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return version, false
+		}
+		version.numbers[i] = number
+	}
+	return version, true
+}
+
+// less is whether a version comes before another, where prereleases come before their release
+func (S semver) less(other semver) bool {
+	for i := range S.numbers {
+		if S.numbers[i] != other.numbers[i] {
+			return S.numbers[i] < other.numbers[i]
+		}
+	}
+	if S.prerelease == other.prerelease {
+		return false
+	}
+	if S.prerelease == "" || other.prerelease == "" {
+		return S.prerelease != ""
+	}
+	return S.prerelease < other.prerelease
+}
@@ -0,0 +1,213 @@
+package images
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// podSpecPaths are where each kind that runs containers keeps its pod spec
+var podSpecPaths = map[string][]string{
+	"Pod":         {"spec"},
+	"Deployment":  {"spec", "template", "spec"},
+	"StatefulSet": {"spec", "template", "spec"},
+	"DaemonSet":   {"spec", "template", "spec"},
+	"ReplicaSet":  {"spec", "template", "spec"},
+	"Job":         {"spec", "template", "spec"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// Change is a container whose image differs between branches
+type Change struct {
+	AppPath   string
+	ID        manifest.ID
+	Container string
+	OldImage  string // empty for added containers
+	NewImage  string // empty for removed containers
+	Warnings  []string
+}
+
+// Result is every image change of the changed apps
+type Result struct {
+	Changes []Change
+}
+
+// Section summarises the image changes for the PR report
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	warnings := 0
+	for _, change := range R.Changes {
+		oldImage, newImage := report.Code(change.OldImage), report.Code(change.NewImage)
+		if change.OldImage == "" {
+			oldImage = "_added_"
+		}
+		if change.NewImage == "" {
+			newImage = "_removed_"
+		}
+		registry, version := "", ""
+		if change.NewImage != "" {
+			reference := ParseReference(change.NewImage)
+			registry = reference.Registry
+			version = reference.Version()
+		}
+		rows = append(rows, []string{
+			change.AppPath,
+			report.Code(change.ID.String()),
+			change.Container,
+			oldImage + " → " + newImage,
+			registry,
+			report.Code(version),
+			strings.Join(change.Warnings, "\n"),
+		})
+		if len(change.Warnings) > 0 {
+			warnings++
+		}
+	}
+	var body strings.Builder
+	if warnings > 0 {
+		body.WriteString(fmt.Sprintf(":warning: **%d image changes have warnings**\n\n", warnings))
+	}
+	body.WriteString(report.Table([]string{"App", "Workload", "Container", "Image", "Registry", "Tag/Digest", "Warnings"}, rows))
+	return report.Section{
+		Title: fmt.Sprintf("Image changes: %d containers", len(R.Changes)),
+		Body:  body.String(),
+	}
+}
+
+// Summariser lists the container image changes of every workload, so that image
+// bumps can be reviewed without reading the whole diff
+type Summariser struct {
+	registryAllowlist []string
+	logger            *log.Logger
+}
+
+func NewSummariser(registryAllowlist []string, logger *log.Logger) *Summariser {
+	return &Summariser{
+		registryAllowlist: registryAllowlist,
+		logger:            logger,
+	}
+}
+
+// Summarise compares the images of each container in both branches of each app
+func (S *Summariser) Summarise(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	for _, builtYaml := range builtYamls {
+		prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		oldImages := map[manifest.ID][]container{}
+		for _, resource := range targetResources {
+			oldImages[resource.ID] = containers(resource)
+		}
+
+		for _, resource := range prResources {
+			old := oldImages[resource.ID]
+			for _, newContainer := range containers(resource) {
+				oldContainer, _ := find(old, newContainer.name)
+				if oldContainer.image == newContainer.image {
+					continue
+				}
+				result.Changes = append(result.Changes, Change{
+					AppPath:   builtYaml.AppPath,
+					ID:        resource.ID,
+					Container: newContainer.name,
+					OldImage:  oldContainer.image,
+					NewImage:  newContainer.image,
+					Warnings:  S.warnings(oldContainer.image, newContainer.image),
+				})
+			}
+			// containers removed from a workload that still exists
+			for _, oldContainer := range old {
+				if _, ok := find(containers(resource), oldContainer.name); !ok {
+					result.Changes = append(result.Changes, Change{
+						AppPath:   builtYaml.AppPath,
+						ID:        resource.ID,
+						Container: oldContainer.name,
+						OldImage:  oldContainer.image,
+					})
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// warnings lists the problems with a new image
+func (S *Summariser) warnings(oldImage, newImage string) []string {
+	warnings := []string{}
+	reference := ParseReference(newImage)
+	if reference.Digest == "" {
+		warnings = append(warnings, "no digest")
+	}
+	if oldImage != "" {
+		oldReference := ParseReference(oldImage)
+		oldVersion, oldOk := parseSemver(oldReference.Tag)
+		newVersion, newOk := parseSemver(reference.Tag)
+		if oldOk && newOk && oldReference.Repository == reference.Repository && newVersion.less(oldVersion) {
+			warnings = append(warnings, fmt.Sprintf("downgrade from %s", oldReference.Tag))
+		}
+	}
+	if len(S.registryAllowlist) > 0 && !S.allowed(reference) {
+		warnings = append(warnings, fmt.Sprintf("registry %s isn't allowed", reference.Registry))
+	}
+	return warnings
+}
+
+// allowed is whether an image is from an allowed registry, or an allowed repository prefix of one
+func (S *Summariser) allowed(reference Reference) bool {
+	name := reference.Registry + "/" + reference.Repository
+	for _, allowed := range S.registryAllowlist {
+		if strings.HasPrefix(name, strings.TrimSuffix(allowed, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// container is the name and image of a container
+type container struct {
+	name  string
+	image string
+}
+
+// containers lists the containers and init containers of a resource, if it runs any
+func containers(resource manifest.Resource) []container {
+	path, ok := podSpecPaths[resource.ID.Kind]
+	if !ok {
+		return nil
+	}
+	podSpec, _ := manifest.Field(resource.Object, path...).(map[string]interface{})
+	found := []container{}
+	for _, field := range []string{"initContainers", "containers"} {
+		list, _ := podSpec[field].([]interface{})
+		for _, item := range list {
+			item, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			found = append(found, container{
+				name:  manifest.String(item, "name"),
+				image: manifest.String(item, "image"),
+			})
+		}
+	}
+	return found
+}
+
+func find(containers []container, name string) (container, bool) {
+	for _, container := range containers {
+		if container.name == name {
+			return container, true
+		}
+	}
+	return container{}, false
+}
@@ -0,0 +1,115 @@
+package images
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestParseReference(t *testing.T) {
+	testCases := []struct {
+		name     string
+		image    string
+		expected Reference
+	}{
+		{
+			name:     "Case 1: docker hub without a tag",
+			image:    "nginx",
+			expected: Reference{Registry: "docker.io", Repository: "nginx", Tag: "latest"},
+		},
+		{
+			name:     "Case 2: registry with a port",
+			image:    "localhost:5000/team/app:1.2.3",
+			expected: Reference{Registry: "localhost:5000", Repository: "team/app", Tag: "1.2.3"},
+		},
+		{
+			name:     "Case 3: tag and digest",
+			image:    "ghcr.io/org/app:v2@sha256:0123456789abcdef",
+			expected: Reference{Registry: "ghcr.io", Repository: "org/app", Tag: "v2", Digest: "sha256:0123456789abcdef"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := ParseReference(testCase.image)
+			if actual != testCase.expected {
+				t.Errorf("Expected %+v, got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSummariser_Summarise(t *testing.T) {
+	deployment := func(initImage, image string) string {
+		return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: ` + initImage + `
+      containers:
+      - name: web
+        image: ` + image + "\n"
+	}
+
+	testCases := []struct {
+		name       string
+		prYaml     string
+		targetYaml string
+		expected   map[string][]string // the warnings of each changed container
+	}{
+		{
+			name:       "Case 1: pinned upgrade from an allowed registry",
+			prYaml:     deployment("ghcr.io/org/migrate:1.0", "ghcr.io/org/web:1.3.0@sha256:abc"),
+			targetYaml: deployment("ghcr.io/org/migrate:1.0", "ghcr.io/org/web:1.2.0@sha256:def"),
+			expected:   map[string][]string{"web": {}},
+		},
+		{
+			name:       "Case 2: numeric and prerelease upgrades, without a digest",
+			prYaml:     deployment("ghcr.io/org/migrate:v1.10.0", "ghcr.io/org/web:1.2.0@sha256:abc"),
+			targetYaml: deployment("ghcr.io/org/migrate:v1.9.0", "ghcr.io/org/web:1.2.0-rc.1@sha256:def"),
+			expected: map[string][]string{
+				"migrate": {"no digest"},
+				"web":     {},
+			},
+		},
+		{
+			name:       "Case 3: prerelease of a lower version",
+			prYaml:     deployment("ghcr.io/org/migrate:1.0", "ghcr.io/org/web:1.2.0-rc.1@sha256:abc"),
+			targetYaml: deployment("ghcr.io/org/migrate:1.0", "ghcr.io/org/web:1.2.0@sha256:def"),
+			expected:   map[string][]string{"web": {"downgrade from 1.2.0"}},
+		},
+		{
+			name:       "Case 4: registry outside the allowlist",
+			prYaml:     deployment("ghcr.io/org/migrate:1.0", "docker.io/someone/web:1.2.0@sha256:abc"),
+			targetYaml: deployment("ghcr.io/org/migrate:1.0", "ghcr.io/org/web:1.2.0@sha256:def"),
+			expected:   map[string][]string{"web": {"registry docker.io isn't allowed"}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			summariser := NewSummariser([]string{"ghcr.io/org"}, log.New(io.Discard, "", 0))
+			result, err := summariser.Summarise([]yaml.BuiltYaml{{
+				AppPath:          "prod/web",
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.targetYaml,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := map[string][]string{}
+			for _, change := range result.Changes {
+				actual[change.Container] = change.Warnings
+			}
+			if !reflect.DeepEqual(actual, testCase.expected) {
+				t.Errorf("Expected warnings %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
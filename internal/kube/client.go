@@ -0,0 +1,205 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+)
+
+// FieldManager is the field manager kubediff applies objects as
+const FieldManager = "kubediff"
+
+// ErrNotServed is returned for kinds the cluster has no API for, e.g. custom resources whose CRD isn't installed
+var ErrNotServed = errors.New("the cluster doesn't serve")
+
+// Client is a minimal kubernetes API client, covering only what kubediff needs:
+// discovering resources, reading objects and dry-running applies.
+type Client struct {
+	connection *connection
+	resources  map[string]map[string]apiResource // discovered resources, by group version then kind
+}
+
+// apiResource is an entry of an APIResourceList from the discovery API
+type apiResource struct {
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+}
+
+// StatusError is a failed request, carrying the Status the API server returned
+type StatusError struct {
+	Code    int
+	Reason  string
+	Message string
+}
+
+func (E *StatusError) Error() string {
+	if E.Message == "" {
+		return fmt.Sprintf("request failed with status %d", E.Code)
+	}
+	return fmt.Sprintf("%s (%d): %s", E.Reason, E.Code, E.Message)
+}
+
+// NewClient connects to the cluster of a kubeconfig context
+func NewClient(ctx context.Context, kubeconfigPath, contextName string) (*Client, error) {
+	connection, err := loadConnection(ctx, kubeconfigPath, contextName)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		connection: connection,
+		resources:  map[string]map[string]apiResource{},
+	}, nil
+}
+
+// Get fetches an object, returning false if it doesn't exist.
+// Namespaced objects without a namespace use the context's namespace.
+func (C *Client) Get(ctx context.Context, apiVersion, kind, namespace, name string) (map[string]interface{}, bool, error) {
+	objectPath, err := C.objectPath(ctx, apiVersion, kind, namespace, name)
+	if err != nil {
+		return nil, false, err
+	}
+	object := map[string]interface{}{}
+	err = C.do(ctx, http.MethodGet, objectPath, nil, "", &object)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.Code == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return object, true, nil
+}
+
+// DryRunApply server-side applies an object without persisting it, returning
+// the object as the API server would store it after defaulting and admission.
+func (C *Client) DryRunApply(ctx context.Context, object map[string]interface{}) (map[string]interface{}, error) {
+	apiVersion := manifest.String(object, "apiVersion")
+	kind := manifest.String(object, "kind")
+	namespace := manifest.String(object, "metadata", "namespace")
+	name := manifest.String(object, "metadata", "name")
+	objectPath, err := C.objectPath(ctx, apiVersion, kind, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"dryRun":       []string{"All"},
+		"fieldManager": []string{FieldManager},
+		"force":        []string{"true"},
+	}
+	applied := map[string]interface{}{}
+	err = C.do(ctx, http.MethodPatch, objectPath+"?"+query.Encode(), body, "application/apply-patch+yaml", &applied)
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// objectPath builds the REST path of an object, discovering its resource name
+func (C *Client) objectPath(ctx context.Context, apiVersion, kind, namespace, name string) (string, error) {
+	resource, err := C.resource(ctx, apiVersion, kind)
+	if err != nil {
+		return "", err
+	}
+	prefix := "/apis/" + apiVersion
+	if manifest.Group(apiVersion) == "" {
+		prefix = "/api/" + apiVersion
+	}
+	if !resource.Namespaced {
+		return fmt.Sprintf("%s/%s/%s", prefix, resource.Name, url.PathEscape(name)), nil
+	}
+	if namespace == "" {
+		namespace = C.connection.namespace
+	}
+	return fmt.Sprintf("%s/namespaces/%s/%s/%s", prefix, url.PathEscape(namespace), resource.Name, url.PathEscape(name)), nil
+}
+
+// resource looks up the resource serving a kind, caching discovery per group version
+func (C *Client) resource(ctx context.Context, apiVersion, kind string) (apiResource, error) {
+	if _, ok := C.resources[apiVersion]; !ok {
+		discoveryPath := "/apis/" + apiVersion
+		if manifest.Group(apiVersion) == "" {
+			discoveryPath = "/api/" + apiVersion
+		}
+		list := struct {
+			Resources []apiResource `json:"resources"`
+		}{}
+		err := C.do(ctx, http.MethodGet, discoveryPath, nil, "", &list)
+		if statusErr, ok := err.(*StatusError); ok && statusErr.Code == http.StatusNotFound {
+			return apiResource{}, fmt.Errorf("%w: %s", ErrNotServed, apiVersion)
+		}
+		if err != nil {
+			return apiResource{}, fmt.Errorf("error discovering resources of %s: %w", apiVersion, err)
+		}
+		byKind := map[string]apiResource{}
+		for _, resource := range list.Resources {
+			// subresources, e.g. deployments/scale, share the kind of their parent
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			byKind[resource.Kind] = resource
+		}
+		C.resources[apiVersion] = byKind
+	}
+	resource, ok := C.resources[apiVersion][kind]
+	if !ok {
+		return apiResource{}, fmt.Errorf("%w: %s %s", ErrNotServed, apiVersion, kind)
+	}
+	return resource, nil
+}
+
+// do makes a request, decoding a successful JSON response into out
+func (C *Client) do(ctx context.Context, method, requestPath string, body []byte, contentType string, out interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, C.connection.server+requestPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if C.connection.token != "" {
+		request.Header.Set("Authorization", "Bearer "+C.connection.token)
+	} else if C.connection.username != "" {
+		request.SetBasicAuth(C.connection.username, C.connection.password)
+	}
+
+	response, err := C.connection.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		status := struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		}{}
+		// the body isn't always a Status, e.g. from a proxy in front of the API server
+		_ = json.Unmarshal(content, &status)
+		return &StatusError{
+			Code:    response.StatusCode,
+			Reason:  status.Reason,
+			Message: status.Message,
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(content, out)
+}
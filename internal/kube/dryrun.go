@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/sops"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// ResourceError is why a single resource couldn't be applied
+type ResourceError struct {
+	ID      manifest.ID
+	Message string
+}
+
+// DryRunResult is the outcome of dry-running an app's PR branch render
+type DryRunResult struct {
+	AppPath string
+	Errors  []ResourceError // resources rejected by the API server, e.g. by admission webhooks
+	Skipped []ResourceError // resources that couldn't be submitted, and why
+	// the live state and server computed state of the applied resources, normalised for
+	// diffing, with the values of Secrets redacted
+	Live   string
+	DryRun string
+}
+
+// Section describes a dry run for the PR report, given the diff of its live and dry run states
+func (R DryRunResult) Section(diff string) report.Section {
+	var body strings.Builder
+	if len(R.Errors) == 0 {
+		body.WriteString("All resources were accepted by the API server.\n\n")
+	} else {
+		body.WriteString(fmt.Sprintf(":x: **%d resources were rejected by the API server**\n\n", len(R.Errors)))
+		body.WriteString(errorTable(R.Errors))
+		body.WriteString("\n")
+	}
+	if len(R.Skipped) > 0 {
+		body.WriteString(fmt.Sprintf("%d resources couldn't be dry run:\n\n", len(R.Skipped)))
+		body.WriteString(errorTable(R.Skipped))
+		body.WriteString("\n")
+	}
+	if strings.TrimSpace(diff) != "" {
+		body.WriteString("Changes computed by the API server:\n\n")
+		body.WriteString(report.DiffBlock(diff))
+	}
+	return report.Section{
+		Title: "Server-side dry run: " + R.AppPath,
+		Body:  body.String(),
+	}
+}
+
+func errorTable(resourceErrors []ResourceError) string {
+	rows := [][]string{}
+	for _, resourceError := range resourceErrors {
+		rows = append(rows, []string{report.Code(resourceError.ID.String()), resourceError.Message})
+	}
+	return report.Table([]string{"Resource", "Error"}, rows)
+}
+
+// DryRunner submits the PR branch render of each app to the cluster of its
+// environment with a server-side apply dry run, so that admission webhooks
+// and schemas are checked before the change is merged.
+type DryRunner struct {
+	clusters *Clusters
+	logger   *log.Logger
+}
+
+func NewDryRunner(clusters *Clusters, logger *log.Logger) *DryRunner {
+	return &DryRunner{
+		clusters: clusters,
+		logger:   logger,
+	}
+}
+
+// DryRun applies the PR branch render of an app, returning false if no cluster
+// is configured for the app's environment. Failing to reach the cluster is not
+// an error, since the diff is still useful without a dry run.
+func (D *DryRunner) DryRun(ctx context.Context, builtYaml yaml.BuiltYaml) (DryRunResult, bool, error) {
+	result := DryRunResult{AppPath: builtYaml.AppPath}
+	client, ok, err := D.clusters.Client(ctx, builtYaml.AppPath)
+	if err != nil {
+		D.logger.Println("skipping dry run, unable to connect to cluster:", err)
+		result.Skipped = append(result.Skipped, ResourceError{
+			ID:      manifest.ID{Kind: "Environment", Name: file.Environment(builtYaml.AppPath)},
+			Message: err.Error(),
+		})
+		return result, true, nil
+	}
+	if !ok {
+		return result, false, nil
+	}
+	D.logger.Println("dry running app:", builtYaml.AppPath)
+
+	resources, err := manifest.Parse(builtYaml.YamlPrBranch)
+	if err != nil {
+		return result, false, err
+	}
+	// namespaces created by this change can't be depended on by a dry run, as they are never persisted
+	namespaces := map[string]bool{}
+	for _, resource := range resources {
+		if resource.ID.Kind == "Namespace" && resource.ID.Group == "" {
+			namespaces[resource.ID.Name] = true
+		}
+	}
+
+	liveDocuments := []string{}
+	dryRunDocuments := []string{}
+	for _, resource := range resources {
+		// what is applied is decrypted from the document, so its redacted ciphertext isn't submitted
+		if sops.IsEncrypted(resource.Object) {
+			result.Skipped = append(result.Skipped, ResourceError{ID: resource.ID, Message: "encrypted with SOPS"})
+			continue
+		}
+		applied, err := client.DryRunApply(ctx, resource.Object)
+		var statusErr *StatusError
+		switch {
+		case errors.Is(err, ErrNotServed):
+			result.Skipped = append(result.Skipped, ResourceError{ID: resource.ID, Message: err.Error()})
+			continue
+		case errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound && namespaces[resource.ID.Namespace]:
+			result.Skipped = append(result.Skipped, ResourceError{
+				ID:      resource.ID,
+				Message: fmt.Sprintf("namespace %s is created by this change", resource.ID.Namespace),
+			})
+			continue
+		case errors.As(err, &statusErr):
+			result.Errors = append(result.Errors, ResourceError{ID: resource.ID, Message: statusErr.Message})
+			continue
+		case err != nil:
+			D.logger.Println("error dry running", resource.ID, ":", err)
+			result.Skipped = append(result.Skipped, ResourceError{ID: resource.ID, Message: err.Error()})
+			continue
+		}
+		document, err := toYaml(applied)
+		if err != nil {
+			return result, false, err
+		}
+		dryRunDocuments = append(dryRunDocuments, document)
+
+		// only accepted resources are compared against the live state
+		live, found, err := client.Get(ctx, resource.APIVersion, resource.ID.Kind, resource.ID.Namespace, resource.ID.Name)
+		if err != nil {
+			D.logger.Println("error fetching", resource.ID, ":", err)
+		}
+		if err == nil && found {
+			document, err := toYaml(live)
+			if err != nil {
+				return result, false, err
+			}
+			liveDocuments = append(liveDocuments, document)
+		}
+	}
+
+	result.Live, err = manifest.Normalise(strings.Join(liveDocuments, "---\n"))
+	if err != nil {
+		return result, false, err
+	}
+	result.DryRun, err = manifest.Normalise(strings.Join(dryRunDocuments, "---\n"))
+	if err != nil {
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// toYaml serialises an object from the API server for the diff posted to the PR,
+// without its server managed fields or the values of Secrets
+func toYaml(object map[string]interface{}) (string, error) {
+	StripServerFields(object)
+	RedactSecret(object)
+	content, err := goyaml.Marshal(object)
+	return string(content), err
+}
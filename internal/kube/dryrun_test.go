@@ -0,0 +1,116 @@
+package kube
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestDryRunner_DryRun(t *testing.T) {
+	server := newFakeAPIServer(t, nil, map[string]fakeResponse{
+		"/api/v1/namespaces/web/configmaps/app": {
+			code: http.StatusOK,
+			body: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":            "app",
+					"namespace":       "web",
+					"uid":             "1234",
+					"managedFields":   []interface{}{},
+					"resourceVersion": "1",
+				},
+				"data": map[string]interface{}{"level": "info"},
+			},
+		},
+		"/api/v1/namespaces/web/secrets/app": {
+			code: http.StatusOK,
+			body: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "web"},
+				"data":       map[string]interface{}{"password": "aHVudGVyMg=="},
+			},
+		},
+		"/apis/apps/v1/namespaces/web/deployments/app": {
+			code: http.StatusUnprocessableEntity,
+			body: map[string]interface{}{
+				"kind":    "Status",
+				"reason":  "Invalid",
+				"message": `admission webhook "policy.example.com" denied the request: latest tag`,
+			},
+		},
+	})
+	defer server.Close()
+
+	clusters := NewClusters(writeKubeconfig(t, server.URL), map[string]string{"prod": "prod"})
+	dryRunner := NewDryRunner(clusters, log.New(io.Discard, "", 0))
+
+	prYaml := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  level: info
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+stringData:
+  password: hunter2
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: <redacted>
+sops:
+  mac: <redacted>
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: app
+`
+	result, ok, err := dryRunner.DryRun(context.Background(), yaml.BuiltYaml{AppPath: "prod/apps/web", YamlPrBranch: prYaml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Expected a cluster to be configured for prod")
+	}
+	if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Message, "policy.example.com") {
+		t.Errorf("Expected the Deployment to be rejected, got %v", result.Errors)
+	}
+	// the encrypted Secret is never applied, which the fake API server would fail the test on
+	if len(result.Skipped) != 2 || result.Skipped[0].ID.Name != "db" || result.Skipped[0].Message != "encrypted with SOPS" || result.Skipped[1].ID.Kind != "Widget" {
+		t.Errorf("Expected the encrypted Secret and the unserved Widget to be skipped, got %v", result.Skipped)
+	}
+	if result.Live != "" {
+		t.Errorf("Expected nothing to be live, got:\n%v", result.Live)
+	}
+	if !strings.Contains(result.DryRun, "level: info") || strings.Contains(result.DryRun, "managedFields") {
+		t.Errorf("Expected the stripped dry run result, got:\n%v", result.DryRun)
+	}
+
+	if strings.Contains(result.DryRun, "aHVudGVyMg==") || !strings.Contains(result.DryRun, "password: <redacted ") {
+		t.Errorf("Expected the Secret's values to be redacted, got:\n%v", result.DryRun)
+	}
+
+	section := result.Section("")
+	if !strings.Contains(section.Body, "1 resources were rejected") {
+		t.Errorf("Expected the rejection to be reported, got:\n%v", section.Body)
+	}
+}
@@ -0,0 +1,253 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// requestTimeout bounds each request to a cluster, so that an API server that stops
+// responding fails the request rather than holding up the run
+const requestTimeout = 30 * time.Second
+
+// kubeconfig models the parts of a kubeconfig file needed to reach a cluster
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string  `yaml:"name"`
+		Cluster cluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User user   `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string      `yaml:"name"`
+		Context contextSpec `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type cluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type user struct {
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  *execConfig `yaml:"exec"`
+}
+
+// execConfig is a client-go credential plugin, as used by EKS, GKE and AKS
+type execConfig struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Env     []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+type contextSpec struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+// connection is everything needed to make requests to a cluster
+type connection struct {
+	server     string
+	namespace  string // the default namespace of the context
+	httpClient *http.Client
+	token      string
+	username   string
+	password   string
+}
+
+// DefaultKubeconfigPath follows kubectl: $KUBECONFIG, otherwise ~/.kube/config.
+// Only the first file of a list in $KUBECONFIG is used.
+func DefaultKubeconfigPath() string {
+	if fromEnv := os.Getenv("KUBECONFIG"); fromEnv != "" {
+		return filepath.SplitList(fromEnv)[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// loadConnection reads a kubeconfig and resolves a context into a connection.
+// An empty context name uses the current context.
+func loadConnection(ctx context.Context, kubeconfigPath, contextName string) (*connection, error) {
+	content, err := os.ReadFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading kubeconfig: %w", err)
+	}
+	config := kubeconfig{}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("error parsing kubeconfig %s: %w", kubeconfigPath, err)
+	}
+	if contextName == "" {
+		contextName = config.CurrentContext
+	}
+
+	var context *contextSpec
+	for i := range config.Contexts {
+		if config.Contexts[i].Name == contextName {
+			context = &config.Contexts[i].Context
+		}
+	}
+	if context == nil {
+		return nil, fmt.Errorf("context %s not found in kubeconfig %s", contextName, kubeconfigPath)
+	}
+	var clusterConfig *cluster
+	for i := range config.Clusters {
+		if config.Clusters[i].Name == context.Cluster {
+			clusterConfig = &config.Clusters[i].Cluster
+		}
+	}
+	if clusterConfig == nil {
+		return nil, fmt.Errorf("cluster %s of context %s not found in kubeconfig", context.Cluster, contextName)
+	}
+	userConfig := user{}
+	for i := range config.Users {
+		if config.Users[i].Name == context.User {
+			userConfig = config.Users[i].User
+		}
+	}
+
+	folder := filepath.Dir(kubeconfigPath)
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: clusterConfig.InsecureSkipTLSVerify,
+		ServerName:         clusterConfig.TLSServerName,
+	}
+	caData, err := dataOrFile(clusterConfig.CertificateAuthorityData, clusterConfig.CertificateAuthority, folder)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate authority: %w", err)
+	}
+	if len(caData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in certificate authority of cluster %s", context.Cluster)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certData, err := dataOrFile(userConfig.ClientCertificateData, userConfig.ClientCertificate, folder)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client certificate: %w", err)
+	}
+	keyData, err := dataOrFile(userConfig.ClientKeyData, userConfig.ClientKey, folder)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client key: %w", err)
+	}
+	if len(certData) > 0 && len(keyData) > 0 {
+		certificate, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	token := userConfig.Token
+	if token == "" && userConfig.TokenFile != "" {
+		content, err := os.ReadFile(resolvePath(userConfig.TokenFile, folder))
+		if err != nil {
+			return nil, fmt.Errorf("error reading token file: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token == "" && userConfig.Exec != nil {
+		token, err = execToken(ctx, *userConfig.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("error running credential plugin %s: %w", userConfig.Exec.Command, err)
+		}
+	}
+
+	namespace := context.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	// the default transport's proxy settings are kept, as runners may only reach the API server through HTTPS_PROXY
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &connection{
+		server:    strings.TrimSuffix(clusterConfig.Server, "/"),
+		namespace: namespace,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   requestTimeout,
+		},
+		token:    token,
+		username: userConfig.Username,
+		password: userConfig.Password,
+	}, nil
+}
+
+// execToken runs a credential plugin and returns the token from its ExecCredential
+func execToken(ctx context.Context, config execConfig) (string, error) {
+	cmd := exec.CommandContext(ctx, config.Command, config.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	var out bytes.Buffer
+	var outErr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &outErr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, outErr.String())
+	}
+	credential := struct {
+		Status struct {
+			Token string `json:"token"`
+		} `json:"status"`
+	}{}
+	if err := json.Unmarshal(out.Bytes(), &credential); err != nil {
+		return "", fmt.Errorf("error parsing ExecCredential: %w", err)
+	}
+	if credential.Status.Token == "" {
+		return "", fmt.Errorf("ExecCredential has no token")
+	}
+	return credential.Status.Token, nil
+}
+
+// dataOrFile returns base64 decoded data if set, otherwise the contents of a file
+func dataOrFile(data, file, folder string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(resolvePath(file, folder))
+	}
+	return nil, nil
+}
+
+// resolvePath resolves paths relative to the kubeconfig, as kubectl does
+func resolvePath(path, folder string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(folder, path)
+}
@@ -0,0 +1,275 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/sops"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// LiveSuffix is appended to the app path of diffs against a live cluster
+const LiveSuffix = " (live)"
+
+// Clusters connects to the cluster of each environment, as configured by a
+// kubeconfig context per environment. Connections are made on first use.
+type Clusters struct {
+	kubeconfigPath string
+	contexts       map[string]string // the kubeconfig context for each environment
+	clients        map[string]*Client
+}
+
+func NewClusters(kubeconfigPath string, contexts map[string]string) *Clusters {
+	return &Clusters{
+		kubeconfigPath: kubeconfigPath,
+		contexts:       contexts,
+		clients:        map[string]*Client{},
+	}
+}
+
+// Client returns a client for the cluster of an app's environment,
+// or false if no cluster is configured for it
+func (C *Clusters) Client(ctx context.Context, appPath string) (*Client, bool, error) {
+	environment := file.Environment(appPath)
+	contextName, ok := C.contexts[environment]
+	if !ok {
+		return nil, false, nil
+	}
+	if client, ok := C.clients[environment]; ok {
+		return client, true, nil
+	}
+	client, err := NewClient(ctx, C.kubeconfigPath, contextName)
+	if err != nil {
+		return nil, false, fmt.Errorf("error connecting to cluster of environment %s: %w", environment, err)
+	}
+	C.clients[environment] = client
+	return client, true, nil
+}
+
+// LiveFetcher reads the current state of an app's objects from its cluster,
+// so the PR branch can be diffed against what is actually deployed.
+type LiveFetcher struct {
+	clusters *Clusters
+	logger   *log.Logger
+}
+
+func NewLiveFetcher(clusters *Clusters, logger *log.Logger) *LiveFetcher {
+	return &LiveFetcher{
+		clusters: clusters,
+		logger:   logger,
+	}
+}
+
+// Fetch returns the live state of every object rendered in either branch, in
+// place of the target branch, or false if the app's environment has no cluster.
+// Both sides are normalised, as the API server doesn't preserve formatting, live
+// objects only keep the fields their render sets, and the values of Secrets are redacted, as the diff is posted to the PR. Documents
+// encrypted with SOPS are left out, as their live state is decrypted.
+func (L *LiveFetcher) Fetch(ctx context.Context, builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, bool, error) {
+	client, ok, err := L.clusters.Client(ctx, builtYaml.AppPath)
+	if err != nil || !ok {
+		return yaml.BuiltYaml{}, ok, err
+	}
+	L.logger.Println("fetching live objects for app:", builtYaml.AppPath)
+
+	prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, false, err
+	}
+	targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, false, err
+	}
+
+	// the ciphertext of encrypted documents can't be compared with what was decrypted and applied
+	encrypted := map[manifest.ID]bool{}
+	for _, resource := range append(prResources, targetResources...) {
+		if sops.IsEncrypted(resource.Object) {
+			encrypted[resource.ID] = true
+		}
+	}
+
+	prDocuments := []string{}
+	for _, resource := range prResources {
+		if encrypted[resource.ID] {
+			continue
+		}
+		RedactSecret(resource.Object)
+		content, err := goyaml.Marshal(resource.Object)
+		if err != nil {
+			return yaml.BuiltYaml{}, false, err
+		}
+		prDocuments = append(prDocuments, string(content))
+	}
+
+	// objects only in the target branch are still live until the PR is merged
+	seen := map[manifest.ID]bool{}
+	liveDocuments := []string{}
+	for _, resource := range append(prResources, targetResources...) {
+		if seen[resource.ID] {
+			continue
+		}
+		seen[resource.ID] = true
+		if encrypted[resource.ID] {
+			L.logger.Println("not fetching", resource.ID, "as it is encrypted with SOPS")
+			continue
+		}
+
+		object, found, err := client.Get(ctx, resource.APIVersion, resource.ID.Kind, resource.ID.Namespace, resource.ID.Name)
+		// kinds the cluster doesn't serve yet, e.g. custom resources whose CRD is in this change, aren't live
+		if errors.Is(err, ErrNotServed) {
+			L.logger.Println("not fetching", resource.ID, "as", err)
+			continue
+		}
+		if err != nil {
+			return yaml.BuiltYaml{}, false, fmt.Errorf("error fetching %s: %w", resource.ID, err)
+		}
+		if !found {
+			continue
+		}
+		StripServerFields(object)
+		PruneUnrendered(object, resource.Object)
+		RedactSecret(object)
+		content, err := goyaml.Marshal(object)
+		if err != nil {
+			return yaml.BuiltYaml{}, false, err
+		}
+		liveDocuments = append(liveDocuments, string(content))
+	}
+
+	liveYaml, err := manifest.Normalise(strings.Join(liveDocuments, "---\n"))
+	if err != nil {
+		return yaml.BuiltYaml{}, false, err
+	}
+	prYaml, err := manifest.Normalise(strings.Join(prDocuments, "---\n"))
+	if err != nil {
+		return yaml.BuiltYaml{}, false, err
+	}
+	return yaml.BuiltYaml{
+		AppPath:          builtYaml.AppPath + LiveSuffix,
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: liveYaml,
+	}, true, nil
+}
+
+// PruneUnrendered removes the fields of a live object that its render doesn't set, such as those
+// the API server defaults and controllers add, so that only drift in the fields the render
+// manages is diffed. Items of lists are matched by their name where they have one, otherwise
+// by their position, and items the render doesn't have are kept whole.
+func PruneUnrendered(live, rendered map[string]interface{}) {
+	// a Secret's stringData is stored as data
+	if manifest.String(rendered, "apiVersion") == "v1" && manifest.String(rendered, "kind") == "Secret" {
+		if stringData, ok := rendered["stringData"].(map[string]interface{}); ok {
+			data := map[string]interface{}{}
+			if renderedData, ok := rendered["data"].(map[string]interface{}); ok {
+				for key, value := range renderedData {
+					data[key] = value
+				}
+			}
+			for key, value := range stringData {
+				data[key] = value
+			}
+			withData := map[string]interface{}{}
+			for key, value := range rendered {
+				withData[key] = value
+			}
+			withData["data"] = data
+			rendered = withData
+		}
+	}
+	pruneMap(live, rendered)
+}
+
+func pruneMap(live, rendered map[string]interface{}) {
+	for key, liveValue := range live {
+		renderedValue, ok := rendered[key]
+		if !ok {
+			delete(live, key)
+			continue
+		}
+		prune(liveValue, renderedValue)
+	}
+}
+
+func prune(live, rendered interface{}) {
+	switch live := live.(type) {
+	case map[string]interface{}:
+		if rendered, ok := rendered.(map[string]interface{}); ok {
+			pruneMap(live, rendered)
+		}
+	case []interface{}:
+		rendered, ok := rendered.([]interface{})
+		if !ok {
+			return
+		}
+		for i, liveItem := range live {
+			if renderedItem, ok := matchingItem(rendered, liveItem, i); ok {
+				prune(liveItem, renderedItem)
+			}
+		}
+	}
+}
+
+// matchingItem finds the rendered counterpart of a live list item
+func matchingItem(rendered []interface{}, liveItem interface{}, index int) (interface{}, bool) {
+	if liveMap, ok := liveItem.(map[string]interface{}); ok {
+		if name, ok := liveMap["name"].(string); ok {
+			for _, renderedItem := range rendered {
+				if renderedMap, ok := renderedItem.(map[string]interface{}); ok && renderedMap["name"] == name {
+					return renderedItem, true
+				}
+			}
+			return nil, false
+		}
+	}
+	if index < len(rendered) {
+		return rendered[index], true
+	}
+	return nil, false
+}
+
+// serverMetadata are the metadata fields set by the API server rather than by users
+var serverMetadata = []string{
+	"uid",
+	"resourceVersion",
+	"generation",
+	"creationTimestamp",
+	"deletionTimestamp",
+	"deletionGracePeriodSeconds",
+	"managedFields",
+	"selfLink",
+}
+
+// serverAnnotations are annotations written by controllers and kubectl
+var serverAnnotations = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	"deployment.kubernetes.io/revision",
+}
+
+// StripServerFields removes the status and server managed metadata from a live object
+func StripServerFields(object map[string]interface{}) {
+	delete(object, "status")
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, field := range serverMetadata {
+		delete(metadata, field)
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, annotation := range serverAnnotations {
+		delete(annotations, annotation)
+	}
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+}
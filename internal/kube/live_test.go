@@ -0,0 +1,340 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// fakeResponse is what the fake API server returns to a server-side apply
+type fakeResponse struct {
+	code int
+	body map[string]interface{}
+}
+
+// newFakeAPIServer serves discovery for the core and apps groups, the given
+// objects by path, and the given responses to dry run applies by path.
+// Every request must carry the token from the kubeconfig.
+func newFakeAPIServer(t *testing.T, objects map[string]interface{}, applies map[string]fakeResponse) *httptest.Server {
+	discovery := map[string]interface{}{
+		"/api/v1": map[string]interface{}{
+			"resources": []map[string]interface{}{
+				{"name": "configmaps", "kind": "ConfigMap", "namespaced": true},
+				{"name": "secrets", "kind": "Secret", "namespaced": true},
+				{"name": "namespaces", "kind": "Namespace", "namespaced": false},
+			},
+		},
+		"/apis/apps/v1": map[string]interface{}{
+			"resources": []map[string]interface{}{
+				{"name": "deployments", "kind": "Deployment", "namespaced": true},
+				{"name": "deployments/scale", "kind": "Scale", "namespaced": true},
+			},
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if r.URL.Query().Get("dryRun") != "All" || r.Header.Get("Content-Type") != "application/apply-patch+yaml" {
+				t.Errorf("Expected a server-side apply dry run, got %v %v", r.URL, r.Header)
+			}
+			apply, ok := applies[r.URL.Path]
+			if !ok {
+				t.Errorf("Unexpected apply to %v", r.URL.Path)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(apply.code)
+			_ = json.NewEncoder(w).Encode(apply.body)
+			return
+		}
+		response, ok := discovery[r.URL.Path]
+		if !ok {
+			response, ok = objects[r.URL.Path]
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "reason": "NotFound"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func writeKubeconfig(t *testing.T, server string) string {
+	kubeconfigPath := filepath.Join(t.TempDir(), "config")
+	content := `apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: prod
+  cluster:
+    server: ` + server + `
+users:
+- name: prod
+  user:
+    token: test-token
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+    namespace: web
+`
+	if err := os.WriteFile(kubeconfigPath, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return kubeconfigPath
+}
+
+func TestLiveFetcher_Fetch(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]interface{}{
+		"/api/v1/namespaces/web/configmaps/app": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":            "app",
+				"namespace":       "web",
+				"uid":             "1234",
+				"resourceVersion": "99",
+				"annotations": map[string]interface{}{
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				},
+			},
+			"data": map[string]interface{}{"level": "edited-by-hand"},
+		},
+		// defaulted by the API server, with the image edited by hand
+		"/apis/apps/v1/namespaces/web/deployments/app": map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "app", "namespace": "web", "generation": 3},
+			"spec": map[string]interface{}{
+				"replicas": 2,
+				"strategy": map[string]interface{}{"type": "RollingUpdate"},
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"dnsPolicy": "ClusterFirst",
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name":                   "app",
+								"image":                  "app:hotfix",
+								"imagePullPolicy":        "IfNotPresent",
+								"terminationMessagePath": "/dev/termination-log",
+								"ports":                  []interface{}{map[string]interface{}{"containerPort": 80, "protocol": "TCP"}},
+							},
+							map[string]interface{}{"name": "debug", "image": "busybox"},
+						},
+					},
+				},
+			},
+			"status": map[string]interface{}{"replicas": 2},
+		},
+	}, nil)
+	defer server.Close()
+
+	clusters := NewClusters(writeKubeconfig(t, server.URL), map[string]string{"prod": "prod"})
+	fetcher := NewLiveFetcher(clusters, log.New(io.Discard, "", 0))
+
+	prYaml := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  level: info
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        ports:
+        - containerPort: 80
+`
+	targetYaml := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: removed
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: unserved
+`
+	live, ok, err := fetcher.Fetch(context.Background(), yaml.BuiltYaml{
+		AppPath:          "prod/apps/web",
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: targetYaml,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Expected a cluster to be configured for prod")
+	}
+	if live.AppPath != "prod/apps/web"+LiveSuffix {
+		t.Errorf("Unexpected app path %v", live.AppPath)
+	}
+
+	// only the fields the render sets are kept, along with containers the render doesn't have
+	expected := `apiVersion: v1
+data:
+  level: edited-by-hand
+kind: ConfigMap
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - image: app:hotfix
+          name: app
+          ports:
+            - containerPort: 80
+        - image: busybox
+          name: debug
+`
+	if live.YamlTargetBranch != expected {
+		t.Errorf("Expected live yaml:\n%v\ngot:\n%v", expected, live.YamlTargetBranch)
+	}
+	if !strings.Contains(live.YamlPrBranch, "level: info") {
+		t.Errorf("Expected the PR branch to be kept, got:\n%v", live.YamlPrBranch)
+	}
+
+	_, ok, err = fetcher.Fetch(context.Background(), yaml.BuiltYaml{AppPath: "dev/apps/web"})
+	if err != nil || ok {
+		t.Errorf("Expected environments without a cluster to be skipped, got %v, %v", ok, err)
+	}
+}
+
+func TestLoadConnection_Proxy(t *testing.T) {
+	connection, err := loadConnection(context.Background(), writeKubeconfig(t, "https://127.0.0.1:6443"), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the proxy is read from the environment once per process, so only its use is checked
+	transport, ok := connection.httpClient.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		t.Errorf("Expected requests to go through the proxy from the environment, got %#v", connection.httpClient.Transport)
+	}
+}
+
+func TestClient_Get_Cancelled(t *testing.T) {
+	// an API server that never responds
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client, err := NewClient(context.Background(), writeKubeconfig(t, server.URL), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = client.Get(ctx, "v1", "ConfigMap", "web", "app")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the request to stop with its context, took %s", elapsed)
+	}
+}
+
+func TestLiveFetcher_Fetch_Secrets(t *testing.T) {
+	server := newFakeAPIServer(t, map[string]interface{}{
+		"/api/v1/namespaces/web/secrets/app": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "app", "namespace": "web"},
+			"data": map[string]interface{}{
+				"user":     "YWRtaW4=",     // admin
+				"password": "aHVudGVyMg==", // hunter2
+			},
+		},
+		"/api/v1/namespaces/web/secrets/db": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "db", "namespace": "web"},
+			"data":       map[string]interface{}{"password": "aHVudGVyMg=="},
+		},
+	}, nil)
+	defer server.Close()
+
+	clusters := NewClusters(writeKubeconfig(t, server.URL), map[string]string{"prod": "prod"})
+	fetcher := NewLiveFetcher(clusters, log.New(io.Discard, "", 0))
+
+	prYaml := `apiVersion: v1
+kind: Secret
+metadata:
+  name: app
+stringData:
+  user: admin
+  password: correct-horse
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: <redacted>
+sops:
+  mac: <redacted>
+`
+	live, ok, err := fetcher.Fetch(context.Background(), yaml.BuiltYaml{AppPath: "prod/apps/web", YamlPrBranch: prYaml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Expected a cluster to be configured for prod")
+	}
+
+	for _, rendered := range []string{live.YamlPrBranch, live.YamlTargetBranch} {
+		for _, secret := range []string{"YWRtaW4=", "aHVudGVyMg==", "admin", "hunter2", "correct-horse"} {
+			if strings.Contains(rendered, secret) {
+				t.Errorf("Expected %q to be redacted, got:\n%v", secret, rendered)
+			}
+		}
+	}
+	// the ciphertext of encrypted documents can't be compared with their decrypted live state
+	for _, rendered := range []string{live.YamlPrBranch, live.YamlTargetBranch} {
+		if strings.Contains(rendered, "name: db") {
+			t.Errorf("Expected the encrypted Secret to be left out, got:\n%v", rendered)
+		}
+	}
+	// the same value is redacted the same way, whether it was encoded or not
+	user := regexp.MustCompile(`user: <redacted [0-9a-f]+>`)
+	if prUser, liveUser := user.FindString(live.YamlPrBranch), user.FindString(live.YamlTargetBranch); prUser == "" || prUser != liveUser {
+		t.Errorf("Expected the unchanged user to match, got %q and %q", prUser, liveUser)
+	}
+	password := regexp.MustCompile(`password: <redacted [0-9a-f]+>`)
+	if prPassword, livePassword := password.FindString(live.YamlPrBranch), password.FindString(live.YamlTargetBranch); prPassword == "" || prPassword == livePassword {
+		t.Errorf("Expected the changed password to differ, got %q and %q", prPassword, livePassword)
+	}
+}
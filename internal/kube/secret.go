@@ -0,0 +1,52 @@
+package kube
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+)
+
+// secretHashKey keys the hashes Secret values are replaced with. It is new on every run,
+// so hashes show which values differ between two objects without revealing guessable values.
+var secretHashKey = newSecretHashKey()
+
+func newSecretHashKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("error generating key for redacting secrets: %v", err))
+	}
+	return key
+}
+
+// RedactSecret replaces the data and stringData values of a v1 Secret with a short hash of
+// their decoded value, as the diffs of live objects are posted to the PR. The same value
+// hashes the same way in either field, so the diffs still show which values changed.
+func RedactSecret(object map[string]interface{}) {
+	if manifest.String(object, "apiVersion") != "v1" || manifest.String(object, "kind") != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := object[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range values {
+			plaintext := []byte(fmt.Sprint(value))
+			if decoded, err := base64.StdEncoding.DecodeString(string(plaintext)); err == nil && field == "data" {
+				plaintext = decoded
+			}
+			values[key] = redactedValue(plaintext)
+		}
+	}
+}
+
+func redactedValue(plaintext []byte) string {
+	mac := hmac.New(sha256.New, secretHashKey)
+	mac.Write(plaintext)
+	return fmt.Sprintf("<redacted %s>", hex.EncodeToString(mac.Sum(nil))[:12])
+}
@@ -0,0 +1,169 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// generatorSuffix matches the content hash kustomize appends to generated
+// ConfigMaps and Secrets: ten characters of hex, with 0, 1, 3, a and e replaced
+// by g, h, k, m and t so that the hash never spells a word.
+var generatorSuffix = regexp.MustCompile(`^(.+)-([2456789bcdfghkmt]{10})$`)
+
+// HashPlaceholder replaces the hash of paired generated names
+const HashPlaceholder = "<hash>"
+
+// Rename is a generated ConfigMap or Secret whose hash suffix changed between renders
+type Rename struct {
+	From       ID
+	To         ID
+	Referenced bool // whether other objects refer to it by name, and so were updated too
+}
+
+// Note describes a rename as a single line for reviewers
+func (R Rename) Note() string {
+	if !R.Referenced {
+		return fmt.Sprintf("%s renamed to %s by its content hash", R.From, R.To.Name)
+	}
+	return fmt.Sprintf("%s renamed to %s by its content hash, references were updated", R.From, R.To.Name)
+}
+
+// generatorKinds are the kinds kustomize generators produce
+var generatorKinds = map[string]bool{
+	"ConfigMap": true,
+	"Secret":    true,
+}
+
+// GeneratorBase returns the name of a generated object without its hash suffix
+func GeneratorBase(id ID) (string, bool) {
+	if id.Group != "" || !generatorKinds[id.Kind] {
+		return "", false
+	}
+	match := generatorSuffix.FindStringSubmatch(id.Name)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// FindRenames pairs generated objects that only exist in one render with an
+// object of the same kind, namespace and base name that only exists in the other.
+func FindRenames(oldResources, newResources []Resource) []Rename {
+	oldIDs := map[ID]bool{}
+	for _, resource := range oldResources {
+		oldIDs[resource.ID] = true
+	}
+	newIDs := map[ID]bool{}
+	for _, resource := range newResources {
+		newIDs[resource.ID] = true
+	}
+
+	// group the unpaired generated objects by everything but their hash
+	type generated struct{ old, new []ID }
+	candidates := map[ID]*generated{}
+	add := func(id ID, isOld bool) {
+		base, ok := GeneratorBase(id)
+		if !ok {
+			return
+		}
+		key := ID{Group: id.Group, Kind: id.Kind, Namespace: id.Namespace, Name: base}
+		if candidates[key] == nil {
+			candidates[key] = &generated{}
+		}
+		if isOld {
+			candidates[key].old = append(candidates[key].old, id)
+		} else {
+			candidates[key].new = append(candidates[key].new, id)
+		}
+	}
+	for _, resource := range oldResources {
+		if !newIDs[resource.ID] {
+			add(resource.ID, true)
+		}
+	}
+	for _, resource := range newResources {
+		if !oldIDs[resource.ID] {
+			add(resource.ID, false)
+		}
+	}
+
+	renames := []Rename{}
+	for _, resource := range oldResources {
+		base, ok := GeneratorBase(resource.ID)
+		if !ok {
+			continue
+		}
+		key := ID{Group: resource.ID.Group, Kind: resource.ID.Kind, Namespace: resource.ID.Namespace, Name: base}
+		pair := candidates[key]
+		// anything other than one of each is ambiguous, so is left as an add and delete
+		if pair == nil || len(pair.old) != 1 || len(pair.new) != 1 || pair.old[0] != resource.ID {
+			continue
+		}
+		renames = append(renames, Rename{From: pair.old[0], To: pair.new[0]})
+	}
+	return renames
+}
+
+// MaskRenamedHashes replaces the hash suffix of renamed objects, and of every
+// reference to them, with HashPlaceholder in both renders. What remains to
+// diff is the content change, with the rename described by each Rename's note.
+func MaskRenamedHashes(oldRendered, newRendered string) (string, string, []Rename, error) {
+	oldResources, err := Parse(oldRendered)
+	if err != nil {
+		return "", "", nil, err
+	}
+	newResources, err := Parse(newRendered)
+	if err != nil {
+		return "", "", nil, err
+	}
+	renames := FindRenames(oldResources, newResources)
+	if len(renames) == 0 {
+		return oldRendered, newRendered, nil, nil
+	}
+
+	oldMasks := map[string]string{}
+	newMasks := map[string]string{}
+	for _, rename := range renames {
+		base, _ := GeneratorBase(rename.From)
+		oldMasks[rename.From.Name] = base + "-" + HashPlaceholder
+		newMasks[rename.To.Name] = base + "-" + HashPlaceholder
+	}
+	oldReplaced := map[string]int{}
+	for _, resource := range oldResources {
+		replaceScalars(resource.Node, oldMasks, oldReplaced)
+	}
+	newReplaced := map[string]int{}
+	for _, resource := range newResources {
+		replaceScalars(resource.Node, newMasks, newReplaced)
+	}
+	// every renamed object's own name is replaced once, anything more is a reference to it
+	for i, rename := range renames {
+		renames[i].Referenced = oldReplaced[rename.From.Name] > 1 || newReplaced[rename.To.Name] > 1
+	}
+
+	oldMasked, err := Encode(oldResources)
+	if err != nil {
+		return "", "", nil, err
+	}
+	newMasked, err := Encode(newResources)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return oldMasked, newMasked, renames, nil
+}
+
+// replaceScalars replaces every string scalar that exactly equals a key of replacements,
+// counting the replacements of each key
+func replaceScalars(node *yaml.Node, replacements map[string]string, replaced map[string]int) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		if replacement, ok := replacements[node.Value]; ok {
+			replaced[node.Value]++
+			node.Value = replacement
+		}
+	}
+	for _, child := range node.Content {
+		replaceScalars(child, replacements, replaced)
+	}
+}
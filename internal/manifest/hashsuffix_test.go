@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskRenamedHashes(t *testing.T) {
+	oldRendered := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config-7c4h2g5d9b
+  namespace: web
+data:
+  level: info
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: web
+spec:
+  template:
+    spec:
+      volumes:
+      - name: config
+        configMap:
+          name: app-config-7c4h2g5d9b
+`
+	newRendered := strings.NewReplacer("7c4h2g5d9b", "k8m2t6f9bc", "info", "debug").Replace(oldRendered)
+
+	maskedOld, maskedNew, renames, err := MaskRenamedHashes(oldRendered, newRendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renames) != 1 {
+		t.Fatalf("Expected 1 rename, got %v", renames)
+	}
+	if renames[0].From.Name != "app-config-7c4h2g5d9b" || renames[0].To.Name != "app-config-k8m2t6f9bc" {
+		t.Errorf("Unexpected rename %v", renames[0])
+	}
+	if !strings.HasSuffix(renames[0].Note(), "references were updated") {
+		t.Errorf("Expected the note to mention the updated reference, got %v", renames[0].Note())
+	}
+	// only the content change should be left once the hashes are masked
+	if strings.Replace(maskedOld, "info", "debug", 1) != maskedNew {
+		t.Errorf("Expected only the content to differ, got:\n%v\nand:\n%v", maskedOld, maskedNew)
+	}
+	if strings.Contains(maskedNew, "k8m2t6f9bc") {
+		t.Errorf("Expected the reference to be masked, got:\n%v", maskedNew)
+	}
+}
+
+func TestMaskRenamedHashes_Unreferenced(t *testing.T) {
+	oldRendered := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config-7c4h2g5d9b
+data:
+  level: info
+`
+	newRendered := strings.NewReplacer("7c4h2g5d9b", "k8m2t6f9bc", "info", "debug").Replace(oldRendered)
+
+	_, _, renames, err := MaskRenamedHashes(oldRendered, newRendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renames) != 1 {
+		t.Fatalf("Expected 1 rename, got %v", renames)
+	}
+	if expected := "ConfigMap/app-config-7c4h2g5d9b renamed to app-config-k8m2t6f9bc by its content hash"; renames[0].Note() != expected {
+		t.Errorf("Expected note %q, got %q", expected, renames[0].Note())
+	}
+}
+
+func TestFindRenames_Ambiguous(t *testing.T) {
+	oldResources := []Resource{
+		{ID: ID{Kind: "ConfigMap", Name: "app-config-7c4h2g5d9b"}},
+	}
+	newResources := []Resource{
+		{ID: ID{Kind: "ConfigMap", Name: "app-config-k8m2t6f9bc"}},
+		{ID: ID{Kind: "ConfigMap", Name: "app-config-bbbbbbbbbb"}},
+	}
+	if renames := FindRenames(oldResources, newResources); len(renames) != 0 {
+		t.Errorf("Expected ambiguous renames to be left unpaired, got %v", renames)
+	}
+}
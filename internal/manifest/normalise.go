@@ -0,0 +1,106 @@
+package manifest
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// Normaliser re-serialises rendered yaml canonically before it is diffed, so that
+// key order, quoting, indentation and document order don't show up as changes.
+type Normaliser struct {
+	appPatterns []string // glob patterns of the apps to normalise
+	logger      *log.Logger
+}
+
+func NewNormaliser(appPatterns []string, logger *log.Logger) *Normaliser {
+	return &Normaliser{
+		appPatterns: appPatterns,
+		logger:      logger,
+	}
+}
+
+func (N *Normaliser) Normalise(builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, error) {
+	if !utils.GlobMatchAny(N.appPatterns, builtYaml.AppPath) {
+		return builtYaml, nil
+	}
+	N.logger.Println("normalising yaml for app:", builtYaml.AppPath)
+
+	prYaml, err := Normalise(builtYaml.YamlPrBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, fmt.Errorf("error normalising PR branch yaml for %s: %w", builtYaml.AppPath, err)
+	}
+	targetYaml, err := Normalise(builtYaml.YamlTargetBranch)
+	if err != nil {
+		return yaml.BuiltYaml{}, fmt.Errorf("error normalising target branch yaml for %s: %w", builtYaml.AppPath, err)
+	}
+	return yaml.BuiltYaml{
+		AppPath:          builtYaml.AppPath,
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: targetYaml,
+	}, nil
+}
+
+// Normalise sorts the documents in rendered yaml by kind, namespace and name,
+// then writes each with sorted keys, block style and no comments.
+func Normalise(rendered string) (string, error) {
+	resources, err := Parse(rendered)
+	if err != nil {
+		return "", err
+	}
+	SortResources(resources)
+	for _, resource := range resources {
+		canonicalise(resource.Node)
+	}
+	return Encode(resources)
+}
+
+// SortResources orders resources by kind, namespace, name then group
+func SortResources(resources []Resource) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := resources[i].ID, resources[j].ID
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Group < b.Group
+	})
+}
+
+// canonicalise sorts mapping keys and resets styles and comments throughout a node.
+// Scalars keep their resolved tags, so the encoder quotes any string that
+// would otherwise be read back as another type.
+func canonicalise(node *goyaml.Node) {
+	node.HeadComment = ""
+	node.LineComment = ""
+	node.FootComment = ""
+	node.Style = 0
+
+	for _, child := range node.Content {
+		canonicalise(child)
+	}
+
+	if node.Kind != goyaml.MappingNode {
+		return
+	}
+	pairs := make([][2]*goyaml.Node, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		pairs = append(pairs, [2]*goyaml.Node{node.Content[i], node.Content[i+1]})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i][0].Value < pairs[j][0].Value
+	})
+	for i, pair := range pairs {
+		node.Content[2*i] = pair[0]
+		node.Content[2*i+1] = pair[1]
+	}
+}
@@ -0,0 +1,112 @@
+package manifest
+
+import (
+	"testing"
+)
+
+func TestNormalise(t *testing.T) {
+	testCases := []struct {
+		name  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{
+			name: "Case 1: Key order, quoting and comments are ignored",
+			a: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app # the app config
+data:
+  enabled: "true"
+  greeting: 'hello'
+`,
+			b: `kind: ConfigMap
+apiVersion: "v1"
+data: {greeting: hello, enabled: "true"}
+metadata:
+    name: app
+`,
+			equal: true,
+		},
+		{
+			name: "Case 2: Document order is ignored",
+			a: `apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`,
+			b: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`,
+			equal: true,
+		},
+		{
+			name: "Case 3: Block scalar style is ignored",
+			a: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  script: |
+    echo hello
+    echo world
+`,
+			b: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  script: "echo hello\necho world\n"
+`,
+			equal: true,
+		},
+		{
+			name: "Case 4: A string and a boolean stay different",
+			a: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  enabled: "true"
+`,
+			b: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  enabled: true
+`,
+			equal: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a, err := Normalise(testCase.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Normalise(testCase.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (a == b) != testCase.equal {
+				t.Errorf("Expected equality to be %v, got:\n%v\nand:\n%v", testCase.equal, a, b)
+			}
+		})
+	}
+}
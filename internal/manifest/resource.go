@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ID identifies a kubernetes object within a single render
+type ID struct {
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// String formats an ID the way kubectl does, e.g. Deployment.apps/namespace/name
+func (I ID) String() string {
+	kind := I.Kind
+	if I.Group != "" {
+		kind += "." + I.Group
+	}
+	if I.Namespace == "" {
+		return kind + "/" + I.Name
+	}
+	return kind + "/" + I.Namespace + "/" + I.Name
+}
+
+// Resource is a single document from rendered yaml
+type Resource struct {
+	ID         ID
+	APIVersion string
+	Node       *yaml.Node             // the document node, kept for re-serialising
+	Object     map[string]interface{} // the decoded object, for inspecting fields
+}
+
+// Parse splits rendered yaml into its resources, dropping empty documents
+func Parse(rendered string) ([]Resource, error) {
+	resources := []Resource{}
+	decoder := yaml.NewDecoder(strings.NewReader(rendered))
+	for {
+		node := &yaml.Node{}
+		err := decoder.Decode(node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing rendered yaml: %w", err)
+		}
+		if len(node.Content) == 0 || node.Content[0].Tag == "!!null" {
+			continue
+		}
+
+		object := map[string]interface{}{}
+		if err := node.Decode(&object); err != nil {
+			return nil, fmt.Errorf("rendered document is not an object: %w", err)
+		}
+		resource := Resource{
+			APIVersion: String(object, "apiVersion"),
+			Node:       node,
+			Object:     object,
+		}
+		resource.ID = ID{
+			Group:     Group(resource.APIVersion),
+			Kind:      String(object, "kind"),
+			Namespace: String(object, "metadata", "namespace"),
+			Name:      String(object, "metadata", "name"),
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// Encode serialises resources back into a multi-document string
+func Encode(resources []Resource) (string, error) {
+	// the encoder fails to close without any documents
+	if len(resources) == 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, resource := range resources {
+		if err := encoder.Encode(resource.Node); err != nil {
+			return "", err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Group returns the API group of an apiVersion, which is empty for the core group
+func Group(apiVersion string) string {
+	group, _, found := strings.Cut(apiVersion, "/")
+	if !found {
+		return ""
+	}
+	return group
+}
+
+// Field walks an object along a path of keys, returning nil if any is missing
+func Field(object map[string]interface{}, keys ...string) interface{} {
+	var current interface{} = object
+	for _, key := range keys {
+		asMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = asMap[key]
+	}
+	return current
+}
+
+// String returns the string at a path in an object, or "" if it isn't a string
+func String(object map[string]interface{}, keys ...string) string {
+	value, _ := Field(object, keys...).(string)
+	return value
+}
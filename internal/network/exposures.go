@@ -0,0 +1,291 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+)
+
+// exposure is something a resource makes reachable, described for reviewers
+type exposure struct {
+	id          manifest.ID
+	description string
+}
+
+// exposures lists what each networking resource of a render makes reachable
+func exposures(resources []manifest.Resource) []exposure {
+	found := []exposure{}
+	for _, resource := range resources {
+		descriptions := []string{}
+		switch resource.ID.Group + "/" + resource.ID.Kind {
+		case "networking.k8s.io/Ingress", "extensions/Ingress":
+			descriptions = ingress(resource.Object)
+		case "gateway.networking.k8s.io/Gateway":
+			descriptions = gateway(resource.Object)
+		case "gateway.networking.k8s.io/HTTPRoute", "gateway.networking.k8s.io/GRPCRoute",
+			"gateway.networking.k8s.io/TLSRoute", "gateway.networking.k8s.io/TCPRoute", "gateway.networking.k8s.io/UDPRoute":
+			descriptions = route(resource.Object)
+		case "/Service":
+			descriptions = service(resource.Object)
+		case "networking.k8s.io/NetworkPolicy":
+			descriptions = networkPolicy(resource.Object)
+		}
+		for _, description := range descriptions {
+			found = append(found, exposure{id: resource.ID, description: description})
+		}
+	}
+	return found
+}
+
+func ingress(object map[string]interface{}) []string {
+	tlsHosts := map[string]bool{}
+	for _, tls := range list(object, "spec", "tls") {
+		for _, host := range stringList(tls, "hosts") {
+			tlsHosts[host] = true
+		}
+	}
+	descriptions := []string{}
+	if defaultBackend := backend(manifest.Field(object, "spec", "defaultBackend")); defaultBackend != "" {
+		descriptions = append(descriptions, "default backend → "+defaultBackend)
+	}
+	for _, rule := range list(object, "spec", "rules") {
+		host := manifest.String(rule, "host")
+		scheme := "http://"
+		if tlsHosts[host] {
+			scheme = "https://"
+		}
+		if host == "" {
+			host = "*"
+		}
+		for _, path := range list(rule, "http", "paths") {
+			descriptions = append(descriptions, fmt.Sprintf("%s%s%s → %s", scheme, host, manifest.String(path, "path"), backend(path["backend"])))
+		}
+	}
+	return descriptions
+}
+
+// backend describes an Ingress backend, in either the networking.k8s.io/v1 or the older form
+func backend(value interface{}) string {
+	object, _ := value.(map[string]interface{})
+	if object == nil {
+		return ""
+	}
+	if name := manifest.String(object, "service", "name"); name != "" {
+		port := manifest.Field(object, "service", "port", "name")
+		if port == nil || port == "" {
+			port = manifest.Field(object, "service", "port", "number")
+		}
+		return fmt.Sprintf("service %s:%v", name, port)
+	}
+	if name := manifest.String(object, "serviceName"); name != "" {
+		return fmt.Sprintf("service %s:%v", name, object["servicePort"])
+	}
+	if name := manifest.String(object, "resource", "name"); name != "" {
+		return fmt.Sprintf("%s %s", manifest.String(object, "resource", "kind"), name)
+	}
+	return ""
+}
+
+func gateway(object map[string]interface{}) []string {
+	descriptions := []string{}
+	for _, listener := range list(object, "spec", "listeners") {
+		hostname := manifest.String(listener, "hostname")
+		if hostname == "" {
+			hostname = "*"
+		}
+		descriptions = append(descriptions, fmt.Sprintf("listener %s %v for %s", manifest.String(listener, "protocol"), listener["port"], hostname))
+	}
+	return descriptions
+}
+
+func route(object map[string]interface{}) []string {
+	parents := []string{}
+	for _, parent := range list(object, "spec", "parentRefs") {
+		name := manifest.String(parent, "name")
+		if section := manifest.String(parent, "sectionName"); section != "" {
+			name += "/" + section
+		}
+		parents = append(parents, name)
+	}
+	hostnames := stringList(object["spec"], "hostnames")
+	if len(hostnames) == 0 {
+		hostnames = []string{"*"}
+	}
+
+	descriptions := []string{}
+	for _, rule := range list(object, "spec", "rules") {
+		backends := []string{}
+		for _, backendRef := range list(rule, "backendRefs") {
+			backends = append(backends, fmt.Sprintf("%s:%v", manifest.String(backendRef, "name"), backendRef["port"]))
+		}
+		paths := []string{}
+		for _, match := range list(rule, "matches") {
+			if path := manifest.String(match, "path", "value"); path != "" {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 0 {
+			paths = []string{""}
+		}
+		for _, hostname := range hostnames {
+			for _, path := range paths {
+				descriptions = append(descriptions, fmt.Sprintf("%s%s via gateway %s → %s",
+					hostname, path, strings.Join(parents, ", "), strings.Join(backends, ", ")))
+			}
+		}
+	}
+	return descriptions
+}
+
+func service(object map[string]interface{}) []string {
+	serviceType := manifest.String(object, "spec", "type")
+	descriptions := []string{}
+	for _, ip := range stringList(object["spec"], "externalIPs") {
+		descriptions = append(descriptions, "external IP "+ip)
+	}
+	if serviceType != "LoadBalancer" && serviceType != "NodePort" {
+		return descriptions
+	}
+	for _, port := range list(object, "spec", "ports") {
+		protocol := manifest.String(port, "protocol")
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		description := fmt.Sprintf("%s %s %v → %v", serviceType, protocol, port["port"], port["targetPort"])
+		if serviceType == "NodePort" {
+			nodePort := port["nodePort"]
+			if nodePort == nil {
+				nodePort = "any"
+			}
+			description = fmt.Sprintf("NodePort %s %v → %v", protocol, nodePort, port["port"])
+		}
+		descriptions = append(descriptions, description)
+	}
+	if sourceRanges := stringList(object["spec"], "loadBalancerSourceRanges"); len(sourceRanges) > 0 && serviceType == "LoadBalancer" {
+		descriptions = append(descriptions, "LoadBalancer source ranges "+strings.Join(sourceRanges, ", "))
+	}
+	return descriptions
+}
+
+// networkPolicy summarises a NetworkPolicy as the traffic it allows, one peer and port at a time
+func networkPolicy(object map[string]interface{}) []string {
+	pods := selector(manifest.Field(object, "spec", "podSelector"), "all pods")
+	policyTypes := stringList(object["spec"], "policyTypes")
+	if len(policyTypes) == 0 {
+		policyTypes = []string{"Ingress"}
+		if manifest.Field(object, "spec", "egress") != nil {
+			policyTypes = append(policyTypes, "Egress")
+		}
+	}
+
+	descriptions := []string{}
+	for _, policyType := range policyTypes {
+		// peers are listed under the word that describes them, e.g. ingress rules are from peers
+		direction, peersField := "ingress to", "from"
+		if policyType == "Egress" {
+			direction, peersField = "egress from", "to"
+		}
+		rules := list(object, "spec", strings.ToLower(policyType))
+		if len(rules) == 0 {
+			descriptions = append(descriptions, fmt.Sprintf("deny all %s %s", direction, pods))
+			continue
+		}
+		for _, rule := range rules {
+			peers := []string{}
+			for _, peer := range list(rule, peersField) {
+				peers = append(peers, describePeer(peer))
+			}
+			if len(peers) == 0 {
+				peers = []string{"anywhere"}
+			}
+			ports := []string{}
+			for _, port := range list(rule, "ports") {
+				protocol := manifest.String(port, "protocol")
+				if protocol == "" {
+					protocol = "TCP"
+				}
+				description := fmt.Sprintf("%s/%v", protocol, port["port"])
+				if port["port"] == nil {
+					description = protocol + "/any"
+				}
+				if endPort, ok := port["endPort"]; ok {
+					description += fmt.Sprintf("-%v", endPort)
+				}
+				ports = append(ports, description)
+			}
+			if len(ports) == 0 {
+				ports = []string{"any port"}
+			}
+			for _, peer := range peers {
+				for _, port := range ports {
+					descriptions = append(descriptions, fmt.Sprintf("allow %s %s %s %s on %s", direction, pods, peersField, peer, port))
+				}
+			}
+		}
+	}
+	return descriptions
+}
+
+func describePeer(peer map[string]interface{}) string {
+	if cidr := manifest.String(peer, "ipBlock", "cidr"); cidr != "" {
+		except := stringList(peer["ipBlock"], "except")
+		if len(except) > 0 {
+			return fmt.Sprintf("%s except %s", cidr, strings.Join(except, ", "))
+		}
+		return cidr
+	}
+	parts := []string{}
+	if namespaces, ok := peer["namespaceSelector"]; ok {
+		parts = append(parts, "namespaces "+selector(namespaces, "all namespaces"))
+	}
+	if pods, ok := peer["podSelector"]; ok {
+		parts = append(parts, "pods "+selector(pods, "all pods"))
+	}
+	return strings.Join(parts, " ")
+}
+
+// selector formats a label selector, or everything if it is empty
+func selector(value interface{}, everything string) string {
+	object, _ := value.(map[string]interface{})
+	requirements := []string{}
+	labels, _ := object["matchLabels"].(map[string]interface{})
+	for key, value := range labels {
+		requirements = append(requirements, fmt.Sprintf("%s=%v", key, value))
+	}
+	for _, expression := range list(object, "matchExpressions") {
+		requirements = append(requirements, fmt.Sprintf("%s %s (%s)",
+			manifest.String(expression, "key"), manifest.String(expression, "operator"), strings.Join(stringList(expression, "values"), ",")))
+	}
+	if len(requirements) == 0 {
+		return everything
+	}
+	sort.Strings(requirements)
+	return strings.Join(requirements, ",")
+}
+
+// list returns the objects in the list at a path of an object
+func list(object map[string]interface{}, keys ...string) []map[string]interface{} {
+	items, _ := manifest.Field(object, keys...).([]interface{})
+	objects := []map[string]interface{}{}
+	for _, item := range items {
+		if itemObject, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, itemObject)
+		}
+	}
+	return objects
+}
+
+// stringList returns the strings in the list at a key of an object
+func stringList(value interface{}, key string) []string {
+	object, _ := value.(map[string]interface{})
+	items, _ := object[key].([]interface{})
+	values := []string{}
+	for _, item := range items {
+		if itemValue, ok := item.(string); ok {
+			values = append(values, itemValue)
+		}
+	}
+	return values
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// Change is something that becomes reachable, or stops being reachable, in an environment
+type Change struct {
+	Environment string
+	ID          manifest.ID
+	Exposure    string // e.g. https://shop.example.com/api → service api:http
+	Added       bool
+}
+
+// Result is every exposure change of the changed apps
+type Result struct {
+	Changes []Change
+}
+
+// Section lists the exposure changes for the PR report
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	for _, change := range R.Changes {
+		status := ":heavy_plus_sign: added"
+		if !change.Added {
+			status = ":heavy_minus_sign: removed"
+		}
+		rows = append(rows, []string{change.Environment, status, report.Code(change.ID.String()), change.Exposure})
+	}
+	return report.Section{
+		Title: fmt.Sprintf("Network exposure: %d changes", len(R.Changes)),
+		Body:  report.Table([]string{"Environment", "Change", "Resource", "Exposure"}, rows),
+	}
+}
+
+// Reporter lists what Ingresses, Gateway API routes, LoadBalancer and NodePort Services
+// and NetworkPolicies make reachable in each branch, and reports the difference
+type Reporter struct {
+	logger *log.Logger
+}
+
+func NewReporter(logger *log.Logger) *Reporter {
+	return &Reporter{
+		logger: logger,
+	}
+}
+
+// Report compares the exposures of each environment between branches
+func (R *Reporter) Report(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	environments := []string{}
+	prExposures := map[string][]exposure{}
+	targetExposures := map[string][]exposure{}
+	for _, builtYaml := range builtYamls {
+		environment := file.Environment(builtYaml.AppPath)
+		if _, ok := prExposures[environment]; !ok {
+			environments = append(environments, environment)
+			prExposures[environment] = []exposure{}
+		}
+		resources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		prExposures[environment] = append(prExposures[environment], exposures(resources)...)
+		resources, err = manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		targetExposures[environment] = append(targetExposures[environment], exposures(resources)...)
+	}
+
+	for _, environment := range environments {
+		added := difference(prExposures[environment], targetExposures[environment])
+		removed := difference(targetExposures[environment], prExposures[environment])
+		for _, exposure := range added {
+			result.Changes = append(result.Changes, Change{Environment: environment, ID: exposure.id, Exposure: exposure.description, Added: true})
+		}
+		for _, exposure := range removed {
+			result.Changes = append(result.Changes, Change{Environment: environment, ID: exposure.id, Exposure: exposure.description})
+		}
+	}
+	return result, nil
+}
+
+// difference lists the exposures of a that aren't in b, ordered by resource then description
+func difference(a, b []exposure) []exposure {
+	inB := map[exposure]bool{}
+	for _, exposure := range b {
+		inB[exposure] = true
+	}
+	found := []exposure{}
+	for _, exposure := range a {
+		if !inB[exposure] {
+			inB[exposure] = true
+			found = append(found, exposure)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].id != found[j].id {
+			return found[i].id.String() < found[j].id.String()
+		}
+		return strings.Compare(found[i].description, found[j].description) < 0
+	})
+	return found
+}
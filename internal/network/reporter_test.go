@@ -0,0 +1,148 @@
+package network
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestReporter_Report(t *testing.T) {
+	ingress := func(path string) string {
+		return `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: shop
+  namespace: web
+spec:
+  tls:
+  - hosts: [shop.example.com]
+  rules:
+  - host: shop.example.com
+    http:
+      paths:
+      - path: ` + path + `
+        backend:
+          service:
+            name: api
+            port:
+              name: http
+`
+	}
+	service := func(serviceType string) string {
+		return `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+  namespace: web
+spec:
+  type: ` + serviceType + `
+  ports:
+  - port: 80
+    targetPort: http
+`
+	}
+	networkPolicy := `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: api
+  namespace: web
+spec:
+  podSelector:
+    matchLabels:
+      app: api
+  policyTypes: [Ingress, Egress]
+  ingress:
+  - from:
+    - namespaceSelector:
+        matchLabels:
+          team: shop
+      podSelector: {}
+    ports:
+    - port: 8080
+`
+	route := `apiVersion: gateway.networking.k8s.io/v1
+kind: HTTPRoute
+metadata:
+  name: shop
+  namespace: web
+spec:
+  parentRefs:
+  - name: public
+  hostnames: [shop.example.com]
+  rules:
+  - matches:
+    - path:
+        value: /cart
+    backendRefs:
+    - name: cart
+      port: 8080
+`
+
+	testCases := []struct {
+		name       string
+		prYaml     string
+		targetYaml string
+		expected   []string
+	}{
+		{
+			name:       "Case 1: changed ingress path",
+			prYaml:     ingress("/v2"),
+			targetYaml: ingress("/v1"),
+			expected: []string{
+				"added Ingress.networking.k8s.io/web/shop https://shop.example.com/v2 → service api:http",
+				"removed Ingress.networking.k8s.io/web/shop https://shop.example.com/v1 → service api:http",
+			},
+		},
+		{
+			name:       "Case 2: service made external",
+			prYaml:     service("LoadBalancer"),
+			targetYaml: service("ClusterIP"),
+			expected:   []string{"added Service/web/api LoadBalancer TCP 80 → http"},
+		},
+		{
+			name:   "Case 3: new network policy",
+			prYaml: networkPolicy,
+			expected: []string{
+				"added NetworkPolicy.networking.k8s.io/web/api allow ingress to app=api from namespaces team=shop pods all pods on TCP/8080",
+				"added NetworkPolicy.networking.k8s.io/web/api deny all egress from app=api",
+			},
+		},
+		{
+			name:       "Case 4: removed route",
+			targetYaml: route,
+			expected:   []string{"removed HTTPRoute.gateway.networking.k8s.io/web/shop shop.example.com/cart via gateway public → cart:8080"},
+		},
+		{
+			name:       "Case 5: unchanged exposure",
+			prYaml:     service("NodePort") + "  externalTrafficPolicy: Local\n",
+			targetYaml: service("NodePort"),
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reporter := NewReporter(log.New(io.Discard, "", 0))
+			result, err := reporter.Report([]yaml.BuiltYaml{{
+				AppPath:          "prod/web",
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.targetYaml,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, change := range result.Changes {
+				status := "added"
+				if !change.Added {
+					status = "removed"
+				}
+				actual = append(actual, status+" "+change.ID.String()+" "+change.Exposure)
+			}
+			if len(actual)+len(testCase.expected) > 0 && !reflect.DeepEqual(actual, testCase.expected) {
+				t.Errorf("Expected changes:\n%v\ngot:\n%v", testCase.expected, actual)
+			}
+		})
+	}
+}
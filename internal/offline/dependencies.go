@@ -0,0 +1,206 @@
+package offline
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// The kinds of remote dependency a kustomization can have
+const (
+	DependencyChart    = "chart"    // a helmCharts entry pulled from a repository
+	DependencyResource = "resource" // a resource, component or base fetched from a URL
+)
+
+// Dependency is something a kustomization fetches from the network when it is built
+type Dependency struct {
+	Kind    string
+	Name    string // the chart's name, or the resource's URL
+	Version string // the chart's version, empty when it isn't pinned
+	Repo    string // the chart's repository
+	UsedBy  []string
+
+	chartHomes []string // where kustomize looks for the chart before pulling it, one per UsedBy
+}
+
+// String describes a dependency the way it is written in a kustomization
+func (D Dependency) String() string {
+	if D.Kind == DependencyResource {
+		return D.Name
+	}
+	version := D.Version
+	if version == "" {
+		version = "(unpinned)"
+	}
+	return D.Name + " " + version + " from " + D.Repo
+}
+
+// Dependencies finds the remote dependencies of every kustomization under a directory, and of
+// the local bases, components and resources they use wherever those are, each listed once with
+// the directories of the kustomizations that use it, relative to root.
+// Charts already unpacked where kustomize looks for them aren't fetched, so aren't listed.
+func Dependencies(root string) ([]Dependency, error) {
+	found := map[string]*Dependency{}
+	visited := map[string]bool{}
+	var visit func(dir string) error
+	visit = func(dir string) error {
+		if visited[dir] {
+			return nil
+		}
+		visited[dir] = true
+		kustomizationPath, ok := findKustomization(dir)
+		if !ok {
+			return nil
+		}
+		usedBy, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		dependencies, localDirs, err := kustomizationDependencies(kustomizationPath)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			key := dependency.Kind + "\x00" + dependency.String()
+			if found[key] == nil {
+				found[key] = &Dependency{Kind: dependency.Kind, Name: dependency.Name, Version: dependency.Version, Repo: dependency.Repo}
+			}
+			found[key].UsedBy = append(found[key].UsedBy, filepath.ToSlash(usedBy))
+			found[key].chartHomes = append(found[key].chartHomes, dependency.chartHomes...)
+		}
+		for _, localDir := range localDirs {
+			if err := visit(localDir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isKustomization(entry.Name()) {
+			return nil
+		}
+		return visit(filepath.Dir(filePath))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies := []Dependency{}
+	for _, dependency := range found {
+		dependencies = append(dependencies, *dependency)
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		if dependencies[i].Kind != dependencies[j].Kind {
+			return dependencies[i].Kind < dependencies[j].Kind
+		}
+		return dependencies[i].String() < dependencies[j].String()
+	})
+	return dependencies, nil
+}
+
+func isKustomization(name string) bool {
+	for _, kustomizationName := range yaml.KustomizationNames {
+		if name == kustomizationName {
+			return true
+		}
+	}
+	return false
+}
+
+// findKustomization returns the path of the kustomization in a directory, if it has one
+func findKustomization(dir string) (string, bool) {
+	for _, kustomizationName := range yaml.KustomizationNames {
+		kustomizationPath := filepath.Join(dir, kustomizationName)
+		if info, err := os.Stat(kustomizationPath); err == nil && !info.IsDir() {
+			return kustomizationPath, true
+		}
+	}
+	return "", false
+}
+
+// kustomizationDependencies finds the remote charts and resources of a single kustomization,
+// along with the local directories it uses, which may be kustomizations of their own
+func kustomizationDependencies(kustomizationPath string) ([]Dependency, []string, error) {
+	content, err := os.ReadFile(kustomizationPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	kustomization := struct {
+		Resources   []string `yaml:"resources"`
+		Components  []string `yaml:"components"`
+		Bases       []string `yaml:"bases"`
+		HelmGlobals struct {
+			ChartHome string `yaml:"chartHome"`
+		} `yaml:"helmGlobals"`
+		HelmCharts []struct {
+			Name    string `yaml:"name"`
+			Version string `yaml:"version"`
+			Repo    string `yaml:"repo"`
+		} `yaml:"helmCharts"`
+	}{}
+	if err := goyaml.Unmarshal(content, &kustomization); err != nil {
+		return nil, nil, err
+	}
+
+	dir := filepath.Dir(kustomizationPath)
+	dependencies := []Dependency{}
+	localDirs := []string{}
+	for _, references := range [][]string{kustomization.Resources, kustomization.Components, kustomization.Bases} {
+		for _, reference := range references {
+			if isRemote(dir, reference) {
+				dependencies = append(dependencies, Dependency{Kind: DependencyResource, Name: reference})
+			} else if localDir := filepath.Join(dir, reference); isDir(localDir) {
+				localDirs = append(localDirs, localDir)
+			}
+		}
+	}
+
+	chartHome := kustomization.HelmGlobals.ChartHome
+	if chartHome == "" {
+		chartHome = "charts"
+	}
+	if !filepath.IsAbs(chartHome) {
+		chartHome = filepath.Join(dir, chartHome)
+	}
+	for _, chart := range kustomization.HelmCharts {
+		// kustomize uses a chart in the chart home over pulling it, whatever its version
+		if chart.Repo == "" || isDir(filepath.Join(chartHome, chart.Name)) {
+			continue
+		}
+		dependencies = append(dependencies, Dependency{
+			Kind:       DependencyChart,
+			Name:       chart.Name,
+			Version:    chart.Version,
+			Repo:       chart.Repo,
+			chartHomes: []string{chartHome},
+		})
+	}
+	return dependencies, localDirs, nil
+}
+
+// isRemote reports whether kustomize would fetch a reference from the network rather than
+// read it from disk. A missing local path fails the build instead.
+func isRemote(dir, reference string) bool {
+	if _, err := os.Stat(filepath.Join(dir, reference)); err == nil {
+		return false
+	}
+	return yaml.IsRemoteReference(reference)
+}
+
+func isDir(dirPath string) bool {
+	info, err := os.Stat(dirPath)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,271 @@
+package offline
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// ociChartMediaType is the media type of the layer holding a chart in an OCI artifact
+const ociChartMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// Resolver prepares checkouts to be built without network access, using charts from a
+// mirror populated ahead of time. A chart in the mirror is either an archive named
+// <chart>-<version>.tgz, as `helm pull` writes it, an unpacked <chart>/ directory,
+// or a <chart>/ OCI image layout tagged with the chart's versions.
+type Resolver struct {
+	mirrorDir string
+	logger    *log.Logger
+}
+
+func NewResolver(mirrorDir string, logger *log.Logger) *Resolver {
+	return &Resolver{
+		mirrorDir: mirrorDir,
+		logger:    logger,
+	}
+}
+
+// Resolve finds the remote dependencies of every kustomization in each directory, failing
+// with every chart missing from the mirror and every remote resource if there are any,
+// and otherwise unpacks each chart to where kustomize looks for it before pulling it
+func (R *Resolver) Resolve(dirs ...string) error {
+	problems := []string{}
+	staged := 0
+	for _, dir := range dirs {
+		// a branch without the directory, such as one that adds it, has nothing to resolve in it
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		dependencies, err := Dependencies(dir)
+		if err != nil {
+			return err
+		}
+		for _, dependency := range dependencies {
+			if dependency.Kind == DependencyResource {
+				problems = append(problems, fmt.Sprintf("remote resource %s, in %s", dependency, strings.Join(dependency.UsedBy, ", ")))
+				continue
+			}
+			unpack, ok := R.find(dependency.Name, dependency.Version)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("chart %s missing from the mirror, used by %s", dependency, strings.Join(dependency.UsedBy, ", ")))
+				continue
+			}
+			for _, chartHome := range dependency.chartHomes {
+				if err := unpack(chartHome); err != nil {
+					return fmt.Errorf("unpacking chart %s into %s: %w", dependency, chartHome, err)
+				}
+				staged++
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("can't build without network access:\n- %s", strings.Join(problems, "\n- "))
+	}
+	R.logger.Printf("unpacked %d charts from %s", staged, R.mirrorDir)
+	return nil
+}
+
+// find looks for a version of a chart in the mirror, returning a function that unpacks it
+// into a chart home. An unpinned chart can only be found unpacked.
+func (R *Resolver) find(name, version string) (func(chartHome string) error, bool) {
+	if R.mirrorDir == "" {
+		return nil, false
+	}
+	if version != "" {
+		archive := filepath.Join(R.mirrorDir, fmt.Sprintf("%s-%s.tgz", name, version))
+		if _, err := os.Stat(archive); err == nil {
+			return func(chartHome string) error { return untarFile(archive, chartHome) }, true
+		}
+	}
+
+	chartDir := filepath.Join(R.mirrorDir, name)
+	if _, err := os.Stat(filepath.Join(chartDir, "oci-layout")); err == nil {
+		if version == "" {
+			return nil, false
+		}
+		blob, err := ociChartBlob(chartDir, version)
+		if err != nil {
+			R.logger.Printf("chart %s %s not found in OCI layout %s: %s", name, version, chartDir, err)
+			return nil, false
+		}
+		return func(chartHome string) error { return untarFile(blob, chartHome) }, true
+	}
+
+	content, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return nil, false
+	}
+	chart := struct {
+		Version string `yaml:"version"`
+	}{}
+	if err := goyaml.Unmarshal(content, &chart); err != nil {
+		R.logger.Printf("reading %s: %s", filepath.Join(chartDir, "Chart.yaml"), err)
+		return nil, false
+	}
+	if version != "" && strings.TrimPrefix(chart.Version, "v") != strings.TrimPrefix(version, "v") {
+		return nil, false
+	}
+	return func(chartHome string) error { return copyDir(chartDir, filepath.Join(chartHome, name)) }, true
+}
+
+// ociChartBlob finds the chart layer of the manifest tagged with a version in an
+// OCI image layout, checking that its content matches its digest
+func ociChartBlob(layoutDir, version string) (string, error) {
+	type descriptor struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	index := struct {
+		Manifests []descriptor `json:"manifests"`
+	}{}
+	if err := readJSON(filepath.Join(layoutDir, "index.json"), &index); err != nil {
+		return "", err
+	}
+	for _, manifestDescriptor := range index.Manifests {
+		if manifestDescriptor.Annotations["org.opencontainers.image.ref.name"] != version {
+			continue
+		}
+		manifest := struct {
+			Layers []descriptor `json:"layers"`
+		}{}
+		if err := readJSON(blobPath(layoutDir, manifestDescriptor.Digest), &manifest); err != nil {
+			return "", err
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != ociChartMediaType {
+				continue
+			}
+			blob := blobPath(layoutDir, layer.Digest)
+			if err := verifyDigest(blob, layer.Digest); err != nil {
+				return "", err
+			}
+			return blob, nil
+		}
+		return "", fmt.Errorf("manifest %s has no chart layer", manifestDescriptor.Digest)
+	}
+	return "", fmt.Errorf("no manifest tagged %s", version)
+}
+
+func blobPath(layoutDir, digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(layoutDir, "blobs", algorithm, encoded)
+}
+
+func verifyDigest(blob, digest string) error {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	if algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	file, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != encoded {
+		return fmt.Errorf("blob %s doesn't match its digest", blob)
+	}
+	return nil
+}
+
+func readJSON(filePath string, into interface{}) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, into)
+}
+
+// untarFile unpacks a gzipped chart archive into a directory, as `helm pull --untar` does,
+// refusing entries that would be written outside it
+func untarFile(archive, dir string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, header.Name)
+		if relative, err := filepath.Rel(dir, target); err != nil || strings.HasPrefix(relative, "..") {
+			return fmt.Errorf("archive entry %s is outside the chart", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tarReader); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyDir copies an unpacked chart
+func copyDir(from, to string) error {
+	return filepath.WalkDir(from, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(from, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, relative)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return writeFile(target, file)
+	})
+}
+
+func writeFile(target string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
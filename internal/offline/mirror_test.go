@@ -0,0 +1,163 @@
+package offline
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+)
+
+// chartArchive packs a chart as `helm package` does
+func chartArchive(t *testing.T, name, version string) string {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+	content := fmt.Sprintf("name: %s\nversion: %s\n", name, version)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: name + "/Chart.yaml", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tarWriter.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	mirror := t.TempDir()
+	chart := chartArchive(t, "redis", "17.0.0")
+	manifest := fmt.Sprintf(`{"layers": [{"mediaType": %q, "digest": "sha256:%s"}]}`, ociChartMediaType, digest(chart))
+	testutil.WriteFiles(t, mirror, map[string]string{
+		"ingress-nginx-4.7.1.tgz":                chartArchive(t, "ingress-nginx", "4.7.1"),
+		"redis/oci-layout":                       `{"imageLayoutVersion": "1.0.0"}`,
+		"redis/index.json":                       fmt.Sprintf(`{"manifests": [{"digest": "sha256:%s", "annotations": {"org.opencontainers.image.ref.name": "17.0.0"}}]}`, digest(manifest)),
+		"redis/blobs/sha256/" + digest(manifest): manifest,
+		"redis/blobs/sha256/" + digest(chart):    chart,
+		"cert-manager/Chart.yaml":                "name: cert-manager\nversion: v1.12.0\n",
+		"cert-manager/templates/deployment.yaml": "kind: Deployment\n",
+	})
+
+	testCases := []struct {
+		name          string
+		kustomization string
+		expectedFile  string
+		expectedError string
+	}{
+		{
+			name:          "Case 1: chart archive",
+			kustomization: "helmCharts:\n- name: ingress-nginx\n  version: 4.7.1\n  repo: https://kubernetes.github.io/ingress-nginx\n",
+			expectedFile:  "charts/ingress-nginx/Chart.yaml",
+		},
+		{
+			name:          "Case 2: chart in an OCI layout, in a custom chart home",
+			kustomization: "helmGlobals:\n  chartHome: vendor\nhelmCharts:\n- name: redis\n  version: 17.0.0\n  repo: oci://registry-1.docker.io/bitnamicharts\n",
+			expectedFile:  "vendor/redis/Chart.yaml",
+		},
+		{
+			name:          "Case 3: unpacked chart",
+			kustomization: "helmCharts:\n- name: cert-manager\n  version: 1.12.0\n  repo: https://charts.jetstack.io\n",
+			expectedFile:  "charts/cert-manager/templates/deployment.yaml",
+		},
+		{
+			name:          "Case 4: chart version missing from the mirror",
+			kustomization: "helmCharts:\n- name: redis\n  version: 18.0.0\n  repo: oci://registry-1.docker.io/bitnamicharts\n",
+			expectedError: "chart redis 18.0.0 from oci://registry-1.docker.io/bitnamicharts missing from the mirror, used by app",
+		},
+		{
+			name:          "Case 5: remote resource",
+			kustomization: "resources:\n- github.com/org/repo//deploy?ref=v1\n- service.yaml\n",
+			expectedError: "remote resource github.com/org/repo//deploy?ref=v1, in app",
+		},
+		{
+			name:          "Case 6: local chart and resources",
+			kustomization: "resources:\n- ../base\nhelmCharts:\n- name: local\n",
+		},
+		{
+			name:          "Case 7: missing local resource named like a host",
+			kustomization: "resources:\n- config.d/extra.yaml\n",
+		},
+		{
+			name:          "Case 8: repository on another host",
+			kustomization: "resources:\n- git.example.com/org/manifests.git//deploy?ref=v1\n",
+			expectedError: "remote resource git.example.com/org/manifests.git//deploy?ref=v1, in app",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			root := t.TempDir()
+			testutil.WriteFiles(t, root, map[string]string{
+				"app/kustomization.yaml":  testCase.kustomization,
+				"base/kustomization.yaml": "resources: []\n",
+			})
+			err := NewResolver(mirror, log.New(io.Discard, "", 0)).Resolve(root)
+			if testCase.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedError) {
+					t.Fatalf("Expected error containing %q, got %v", testCase.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if testCase.expectedFile != "" {
+				if _, err := os.Stat(filepath.Join(root, "app", testCase.expectedFile)); err != nil {
+					t.Errorf("Expected %s to be unpacked: %v", testCase.expectedFile, err)
+				}
+			}
+			// once unpacked, nothing is left to fetch
+			dependencies, err := Dependencies(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dependencies) != 0 {
+				t.Errorf("Expected no remote dependencies after resolving, got %v", dependencies)
+			}
+		})
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	root := t.TempDir()
+	testutil.WriteFiles(t, root, map[string]string{
+		"envs/prod/app/kustomization.yaml": "resources:\n- ../../../base\n- gh:org/repo//crds\n",
+		"base/kustomization.yaml":          "helmCharts:\n- name: redis\n  version: 17.0.0\n  repo: oci://registry-1.docker.io/bitnamicharts\n",
+		// kustomizations outside the directory that no app uses aren't built, so aren't needed
+		"test/kustomization.yaml": "resources:\n- https://example.com/fixture.yaml\n",
+	})
+
+	dependencies, err := Dependencies(filepath.Join(root, "envs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, dependency := range dependencies {
+		actual = append(actual, fmt.Sprintf("%s %s: %s", dependency.Kind, dependency, strings.Join(dependency.UsedBy, ",")))
+	}
+	expected := []string{
+		"chart redis 17.0.0 from oci://registry-1.docker.io/bitnamicharts: ../base",
+		"resource gh:org/repo//crds: prod/app",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
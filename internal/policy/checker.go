@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// Violation is an added or modified resource that doesn't follow a policy
+type Violation struct {
+	AppPath  string
+	ID       manifest.ID
+	Policy   string
+	Severity string
+	Message  string
+}
+
+// Result is every violation of the changed apps, in app order
+type Result struct {
+	Violations []Violation
+}
+
+// Failed is whether any violation is an error
+func (R Result) Failed() bool {
+	for _, violation := range R.Violations {
+		if violation.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Section describes the violations for the PR report, grouped by app
+func (R Result) Section() report.Section {
+	var body strings.Builder
+	if len(R.Violations) == 0 {
+		body.WriteString("All added and modified resources follow the policies.\n")
+	}
+	counts := map[string]int{}
+	for _, violation := range R.Violations {
+		counts[violation.Severity]++
+	}
+	if len(R.Violations) > 0 {
+		summary := []string{}
+		for _, severity := range severities {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+		body.WriteString(fmt.Sprintf("**%d policy violations**: %s\n", len(R.Violations), strings.Join(summary, ", ")))
+	}
+	for start := 0; start < len(R.Violations); {
+		appPath := R.Violations[start].AppPath
+		end := start
+		for end < len(R.Violations) && R.Violations[end].AppPath == appPath {
+			end++
+		}
+		rows := [][]string{}
+		for _, violation := range R.Violations[start:end] {
+			rows = append(rows, []string{
+				severityIcons[violation.Severity] + " " + violation.Severity,
+				report.Code(violation.ID.String()),
+				report.Code(violation.Policy),
+				violation.Message,
+			})
+		}
+		body.WriteString("\n#### " + appPath + "\n\n")
+		body.WriteString(report.Table([]string{"Severity", "Resource", "Policy", "Message"}, rows))
+		start = end
+	}
+	return report.Section{
+		Title: "Policy checks",
+		Body:  body.String(),
+	}
+}
+
+var severityIcons = map[string]string{
+	SeverityError:   ":x:",
+	SeverityWarning: ":warning:",
+	SeverityInfo:    ":information_source:",
+}
+
+// Checker evaluates the policies in a directory against every resource a change
+// adds or modifies, entirely offline
+type Checker struct {
+	policyDir string
+	logger    *log.Logger
+}
+
+func NewChecker(policyDir string, logger *log.Logger) *Checker {
+	return &Checker{
+		policyDir: policyDir,
+		logger:    logger,
+	}
+}
+
+// Check evaluates every policy against the added and modified resources of each app
+func (C *Checker) Check(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	policies, err := LoadPolicies(C.policyDir)
+	if err != nil {
+		return result, err
+	}
+	C.logger.Println("loaded", len(policies), "policies from", C.policyDir)
+
+	for _, builtYaml := range builtYamls {
+		prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		oldObjects := map[manifest.ID]map[string]interface{}{}
+		for _, resource := range targetResources {
+			oldObjects[resource.ID] = resource.Object
+		}
+
+		violations := []Violation{}
+		for _, resource := range prResources {
+			oldObject, existed := oldObjects[resource.ID]
+			if existed && reflect.DeepEqual(oldObject, resource.Object) {
+				continue
+			}
+			variables := map[string]interface{}{
+				"object":      resource.Object,
+				"oldObject":   nil,
+				"appPath":     builtYaml.AppPath,
+				"environment": file.Environment(builtYaml.AppPath),
+			}
+			if existed {
+				variables["oldObject"] = oldObject
+			}
+			for _, policy := range policies {
+				if !policy.matches(resource.ID.Kind) {
+					continue
+				}
+				passed, err := policy.evaluate(variables)
+				if err == nil && passed {
+					continue
+				}
+				message := policy.Message
+				if err != nil {
+					message = fmt.Sprintf("couldn't evaluate rule: %v", err)
+				}
+				violations = append(violations, Violation{
+					AppPath:  builtYaml.AppPath,
+					ID:       resource.ID,
+					Policy:   policy.Name,
+					Severity: policy.Severity,
+					Message:  message,
+				})
+			}
+		}
+		// the most severe violations of each app come first
+		sort.SliceStable(violations, func(i, j int) bool {
+			return severityRank(violations[i].Severity) < severityRank(violations[j].Severity)
+		})
+		result.Violations = append(result.Violations, violations...)
+	}
+	return result, nil
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+const policies = `name: no-latest-tag
+severity: error
+message: images must be pinned to a tag other than latest
+match:
+  kinds: [Deployment]
+rule: object.spec.template.spec.containers.all(c, !c.image.endsWith(":latest"))
+---
+name: limits-required
+severity: warning
+message: containers must set resource limits
+match:
+  kinds: [Deployment]
+rule: object.spec.template.spec.containers.all(c, has(c.resources) && has(c.resources.limits))
+---
+name: no-scale-down-in-prod
+severity: info
+message: replicas were reduced in prod
+rule: environment != "prod" || oldObject == null || !has(object.spec.replicas) || object.spec.replicas >= oldObject.spec.replicas
+`
+
+func deployment(image string, replicas int, limits bool) string {
+	container := testutil.Container{Name: "web", Image: image}
+	if limits {
+		container.Limits = map[string]string{"cpu": "100m"}
+	}
+	return testutil.Deployment{Replicas: replicas, Containers: []testutil.Container{container}}.Yaml()
+}
+
+func TestChecker_Check(t *testing.T) {
+	policyDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(policyDir, "house-rules.yaml"), []byte(policies), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		appPath    string
+		prYaml     string
+		targetYaml string
+		expected   []string // the policy of each violation
+	}{
+		{
+			name:    "Case 1: added resource following every policy",
+			appPath: "prod/web",
+			prYaml:  deployment("web:1.0", 2, true),
+		},
+		{
+			name:     "Case 2: added resource breaking policies, most severe first",
+			appPath:  "prod/web",
+			prYaml:   deployment("web:latest", 2, false),
+			expected: []string{"no-latest-tag", "limits-required"},
+		},
+		{
+			name:       "Case 3: unchanged resources aren't checked",
+			appPath:    "prod/web",
+			prYaml:     deployment("web:latest", 2, false),
+			targetYaml: deployment("web:latest", 2, false),
+		},
+		{
+			name:       "Case 4: rules comparing the old object",
+			appPath:    "prod/web",
+			prYaml:     deployment("web:1.0", 1, true),
+			targetYaml: deployment("web:1.0", 3, true),
+			expected:   []string{"no-scale-down-in-prod"},
+		},
+		{
+			name:       "Case 5: rules using the environment",
+			appPath:    "dev/web",
+			prYaml:     deployment("web:1.0", 1, true),
+			targetYaml: deployment("web:1.0", 3, true),
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			checker := NewChecker(policyDir, log.New(io.Discard, "", 0))
+			result, err := checker.Check([]yaml.BuiltYaml{{
+				AppPath:          testCase.appPath,
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.targetYaml,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, violation := range result.Violations {
+				actual = append(actual, violation.Policy)
+			}
+			if len(actual) != len(testCase.expected) {
+				t.Fatalf("Expected violations %v, got %v", testCase.expected, result.Violations)
+			}
+			for i := range actual {
+				if actual[i] != testCase.expected[i] {
+					t.Errorf("Expected violations %v, got %v", testCase.expected, result.Violations)
+				}
+			}
+		})
+	}
+}
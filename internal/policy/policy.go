@@ -0,0 +1,153 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// The severities of a policy, from most to least severe
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+var severities = []string{SeverityError, SeverityWarning, SeverityInfo}
+
+// Policy is a house rule that every added or modified resource it matches must follow
+type Policy struct {
+	Name     string `yaml:"name"`
+	Severity string `yaml:"severity"`
+	Message  string `yaml:"message"`
+	Match    struct {
+		Kinds []string `yaml:"kinds"` // all kinds when empty
+	} `yaml:"match"`
+	// a CEL expression that is true when a resource follows the policy, given
+	// object, oldObject (null for added resources), appPath and environment
+	Rule string `yaml:"rule"`
+
+	file    string
+	program cel.Program
+}
+
+// matches is whether a policy applies to a kind
+func (P *Policy) matches(kind string) bool {
+	if len(P.Match.Kinds) == 0 {
+		return true
+	}
+	for _, matchKind := range P.Match.Kinds {
+		if matchKind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate runs a policy's rule, returning whether it passed
+func (P *Policy) evaluate(variables map[string]interface{}) (bool, error) {
+	value, _, err := P.program.Eval(variables)
+	if err != nil {
+		return false, err
+	}
+	passed, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("rule evaluated to %v, not a boolean", value)
+	}
+	return passed, nil
+}
+
+// newEnv declares the variables available to rules
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("oldObject", cel.DynType),
+		cel.Variable("appPath", cel.StringType),
+		cel.Variable("environment", cel.StringType),
+	)
+}
+
+// LoadPolicies reads and compiles every policy from the yaml files under a
+// directory, where each file may hold several policies as separate documents
+func LoadPolicies(dir string) ([]*Policy, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	policies := []*Policy{}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !(strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		decoder := goyaml.NewDecoder(strings.NewReader(string(content)))
+		for {
+			policy := &Policy{}
+			err := decoder.Decode(policy)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error parsing policies in %s: %w", path, err)
+			}
+			if policy.Rule == "" {
+				continue
+			}
+			policy.file = path
+			if err := policy.compile(env); err != nil {
+				return err
+			}
+			policies = append(policies, policy)
+		}
+		return nil
+	})
+	return policies, err
+}
+
+func (P *Policy) compile(env *cel.Env) error {
+	if P.Name == "" {
+		return fmt.Errorf("policy in %s has no name", P.file)
+	}
+	if P.Severity == "" {
+		P.Severity = SeverityError
+	}
+	if severityRank(P.Severity) == len(severities) {
+		return fmt.Errorf("policy %s: severity must be one of %s: got %s", P.Name, strings.Join(severities, ", "), P.Severity)
+	}
+	ast, issues := env.Compile(P.Rule)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("error compiling policy %s: %w", P.Name, issues.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return fmt.Errorf("policy %s: rule must evaluate to a boolean, not %v", P.Name, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return fmt.Errorf("error compiling policy %s: %w", P.Name, err)
+	}
+	P.program = program
+	return nil
+}
+
+// severityRank orders severities from most severe, placing unknown ones last
+func severityRank(severity string) int {
+	for i, known := range severities {
+		if known == severity {
+			return i
+		}
+	}
+	return len(severities)
+}
@@ -0,0 +1,367 @@
+package rbac
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// ClusterWide is the scope of permissions granted by ClusterRoleBindings
+const ClusterWide = "cluster-wide"
+
+// escalatingVerbs let a subject grant itself more than it is given
+var escalatingVerbs = map[string]bool{
+	"bind":        true,
+	"escalate":    true,
+	"impersonate": true,
+}
+
+// Grant is the change in a subject's verbs on a resource in a scope
+type Grant struct {
+	Environment string
+	Subject     string // e.g. ServiceAccount web/app, User jane or Group admins
+	Scope       string // a namespace, or ClusterWide
+	Resource    string // e.g. deployments.apps, secrets/db-password or the URL /metrics
+	Added       []string
+	Removed     []string
+	Risks       []string // why added verbs are high risk, if they are
+}
+
+// UnknownBinding is a binding added or removed for a role that no changed app renders,
+// so whose permissions are unknown
+type UnknownBinding struct {
+	Environment string
+	Subject     string
+	Scope       string
+	Role        string // e.g. ClusterRole admin
+	Added       bool   // whether the binding is added, rather than removed
+}
+
+// Result is every change in permissions across the changed apps
+type Result struct {
+	Grants          []Grant
+	UnknownBindings []UnknownBinding
+}
+
+// Section tabulates the permission changes for the PR report
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	subjects := map[string]bool{}
+	risky := 0
+	for _, grant := range R.Grants {
+		subjects[grant.Environment+" "+grant.Subject] = true
+		risk := ""
+		if len(grant.Risks) > 0 {
+			risk = ":rotating_light: " + strings.Join(grant.Risks, ", ")
+			risky++
+		}
+		rows = append(rows, []string{
+			grant.Environment,
+			report.Code(grant.Subject),
+			grant.Scope,
+			report.Code(grant.Resource),
+			strings.Join(grant.Added, ", "),
+			strings.Join(grant.Removed, ", "),
+			risk,
+		})
+	}
+	var body strings.Builder
+	if risky > 0 {
+		body.WriteString(fmt.Sprintf(":rotating_light: **%d high risk permissions are granted**\n\n", risky))
+	}
+	if len(rows) > 0 {
+		body.WriteString(report.Table([]string{"Environment", "Subject", "Scope", "Resource", "Added verbs", "Removed verbs", "Risk"}, rows))
+	}
+	if len(R.UnknownBindings) > 0 {
+		unknownRows := [][]string{}
+		for _, binding := range R.UnknownBindings {
+			subjects[binding.Environment+" "+binding.Subject] = true
+			change := "removed"
+			if binding.Added {
+				change = "added"
+			}
+			unknownRows = append(unknownRows, []string{binding.Environment, report.Code(binding.Subject), binding.Scope, report.Code(binding.Role), change})
+		}
+		if len(rows) > 0 {
+			body.WriteString("\n")
+		}
+		body.WriteString(fmt.Sprintf("%d bindings are for roles that no changed app renders, so the permissions they grant are unknown:\n\n", len(R.UnknownBindings)))
+		body.WriteString(report.Table([]string{"Environment", "Subject", "Scope", "Role", "Binding"}, unknownRows))
+	}
+	return report.Section{
+		Title: fmt.Sprintf("RBAC changes: %d subjects", len(subjects)),
+		Body:  body.String(),
+	}
+}
+
+// Analyser computes the effective permissions of each subject from the Roles, ClusterRoles
+// and bindings rendered in each branch, and reports how they change
+type Analyser struct {
+	logger *log.Logger
+}
+
+func NewAnalyser(logger *log.Logger) *Analyser {
+	return &Analyser{
+		logger: logger,
+	}
+}
+
+// Analyse compares permissions per environment, since a binding in one app may reference a role in another
+func (A *Analyser) Analyse(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	environments := []string{}
+	prResources := map[string][]manifest.Resource{}
+	targetResources := map[string][]manifest.Resource{}
+	for _, builtYaml := range builtYamls {
+		environment := file.Environment(builtYaml.AppPath)
+		if _, ok := prResources[environment]; !ok {
+			environments = append(environments, environment)
+		}
+		resources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		prResources[environment] = append(prResources[environment], resources...)
+		resources, err = manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		targetResources[environment] = append(targetResources[environment], resources...)
+	}
+
+	for _, environment := range environments {
+		newPermissions, newUnknown := A.permissions(prResources[environment])
+		oldPermissions, oldUnknown := A.permissions(targetResources[environment])
+
+		grants := map[grantKey]*Grant{}
+		add := func(permission permission, added bool) {
+			key := grantKey{subject: permission.subject, scope: permission.scope, resource: permission.resource}
+			if grants[key] == nil {
+				grants[key] = &Grant{
+					Environment: environment,
+					Subject:     permission.subject,
+					Scope:       permission.scope,
+					Resource:    permission.resource,
+				}
+			}
+			if added {
+				grants[key].Added = append(grants[key].Added, permission.verb)
+			} else {
+				grants[key].Removed = append(grants[key].Removed, permission.verb)
+			}
+		}
+		for permission := range newPermissions {
+			if !oldPermissions[permission] {
+				add(permission, true)
+			}
+		}
+		for permission := range oldPermissions {
+			if !newPermissions[permission] {
+				add(permission, false)
+			}
+		}
+
+		keys := []grantKey{}
+		for key := range grants {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+		for _, key := range keys {
+			grant := grants[key]
+			sort.Strings(grant.Added)
+			sort.Strings(grant.Removed)
+			grant.Risks = risks(*grant)
+			result.Grants = append(result.Grants, *grant)
+		}
+
+		unknownBindings := []UnknownBinding{}
+		for binding := range newUnknown {
+			if !oldUnknown[binding] {
+				unknownBindings = append(unknownBindings, binding.unknown(environment, true))
+			}
+		}
+		for binding := range oldUnknown {
+			if !newUnknown[binding] {
+				unknownBindings = append(unknownBindings, binding.unknown(environment, false))
+			}
+		}
+		sort.Slice(unknownBindings, func(i, j int) bool {
+			a, b := unknownBindings[i], unknownBindings[j]
+			if a.Subject != b.Subject {
+				return a.Subject < b.Subject
+			}
+			if a.Scope != b.Scope {
+				return a.Scope < b.Scope
+			}
+			return a.Role < b.Role
+		})
+		result.UnknownBindings = append(result.UnknownBindings, unknownBindings...)
+	}
+	return result, nil
+}
+
+// risks lists why the verbs a grant adds are high risk
+func risks(grant Grant) []string {
+	if len(grant.Added) == 0 {
+		return nil
+	}
+	var found []string
+	if grant.Scope == ClusterWide {
+		found = append(found, "cluster-wide")
+	}
+	wildcard := strings.Contains(grant.Resource, "*")
+	for _, verb := range grant.Added {
+		wildcard = wildcard || verb == "*"
+	}
+	if wildcard {
+		found = append(found, "wildcard")
+	}
+	for _, verb := range grant.Added {
+		if escalatingVerbs[verb] {
+			found = append(found, verb)
+		}
+	}
+	return found
+}
+
+// permission is a single verb a subject may use on a resource in a scope
+type permission struct {
+	subject  string
+	scope    string
+	resource string
+	verb     string
+}
+
+// binding is a subject bound to a role in a scope
+type binding struct {
+	subject string
+	scope   string
+	role    string
+}
+
+func (B binding) unknown(environment string, added bool) UnknownBinding {
+	return UnknownBinding{Environment: environment, Subject: B.subject, Scope: B.scope, Role: B.role, Added: added}
+}
+
+type grantKey struct {
+	subject  string
+	scope    string
+	resource string
+}
+
+func (K grantKey) less(other grantKey) bool {
+	if K.subject != other.subject {
+		return K.subject < other.subject
+	}
+	if K.scope != other.scope {
+		return K.scope < other.scope
+	}
+	return K.resource < other.resource
+}
+
+// permissions expands every binding among resources into the permissions of its subjects,
+// returning the bindings of subjects to roles that aren't among the resources separately
+func (A *Analyser) permissions(resources []manifest.Resource) (map[permission]bool, map[binding]bool) {
+	roles := map[string][]interface{}{}
+	for _, resource := range resources {
+		if resource.ID.Group != "rbac.authorization.k8s.io" || (resource.ID.Kind != "Role" && resource.ID.Kind != "ClusterRole") {
+			continue
+		}
+		rules, _ := manifest.Field(resource.Object, "rules").([]interface{})
+		roles[roleKey(resource.ID.Kind, resource.ID.Namespace, resource.ID.Name)] = rules
+	}
+
+	permissions := map[permission]bool{}
+	unknown := map[binding]bool{}
+	for _, resource := range resources {
+		if resource.ID.Group != "rbac.authorization.k8s.io" || (resource.ID.Kind != "RoleBinding" && resource.ID.Kind != "ClusterRoleBinding") {
+			continue
+		}
+		scope := resource.ID.Namespace
+		if resource.ID.Kind == "ClusterRoleBinding" {
+			scope = ClusterWide
+		}
+		roleKind := manifest.String(resource.Object, "roleRef", "kind")
+		roleName := manifest.String(resource.Object, "roleRef", "name")
+		roleNamespace := ""
+		if roleKind == "Role" {
+			roleNamespace = resource.ID.Namespace
+		}
+		rules, known := roles[roleKey(roleKind, roleNamespace, roleName)]
+		if !known {
+			A.logger.Println("role", roleKind, roleName, "referenced by", resource.ID, "isn't rendered by a changed app, so its rules are unknown")
+		}
+
+		subjects, _ := manifest.Field(resource.Object, "subjects").([]interface{})
+		for _, subject := range subjects {
+			subject, ok := subject.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name := manifest.String(subject, "kind") + " " + manifest.String(subject, "name")
+			if manifest.String(subject, "kind") == "ServiceAccount" {
+				namespace := manifest.String(subject, "namespace")
+				if namespace == "" {
+					namespace = resource.ID.Namespace
+				}
+				name = "ServiceAccount " + namespace + "/" + manifest.String(subject, "name")
+			}
+			if !known {
+				unknown[binding{subject: name, scope: scope, role: roleKind + " " + roleName}] = true
+				continue
+			}
+			for _, rule := range rules {
+				rule, _ := rule.(map[string]interface{})
+				for _, target := range ruleResources(rule) {
+					for _, verb := range stringList(rule, "verbs") {
+						permissions[permission{subject: name, scope: scope, resource: target, verb: verb}] = true
+					}
+				}
+			}
+		}
+	}
+	return permissions, unknown
+}
+
+// ruleResources lists what a rule applies to, in kubectl's resource.group form
+func ruleResources(rule map[string]interface{}) []string {
+	targets := []string{}
+	names := stringList(rule, "resourceNames")
+	for _, group := range stringList(rule, "apiGroups") {
+		for _, resource := range stringList(rule, "resources") {
+			target := resource
+			if group != "" {
+				target += "." + group
+			}
+			if len(names) == 0 {
+				targets = append(targets, target)
+			}
+			for _, name := range names {
+				targets = append(targets, target+"/"+name)
+			}
+		}
+	}
+	return append(targets, stringList(rule, "nonResourceURLs")...)
+}
+
+// stringList returns the list of strings at a key of a rule
+func stringList(rule map[string]interface{}, key string) []string {
+	items, _ := rule[key].([]interface{})
+	values := []string{}
+	for _, item := range items {
+		if value, ok := item.(string); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func roleKey(kind, namespace, name string) string {
+	return kind + " " + namespace + "/" + name
+}
@@ -0,0 +1,147 @@
+package rbac
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestAnalyser_Analyse(t *testing.T) {
+	role := func(verbs string) string {
+		return `apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: reader
+  namespace: web
+rules:
+- apiGroups: ["", "apps"]
+  resources: [configmaps, deployments]
+  verbs: ` + verbs + `
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: reader
+  namespace: web
+roleRef:
+  kind: Role
+  name: reader
+subjects:
+- kind: ServiceAccount
+  name: app
+`
+	}
+	clusterRole := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: impersonator
+rules:
+- apiGroups: [""]
+  resources: [users]
+  verbs: [impersonate]
+`
+	clusterRoleBinding := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: impersonator
+roleRef:
+  kind: ClusterRole
+  name: impersonator
+subjects:
+- kind: Group
+  name: support
+`
+
+	testCases := []struct {
+		name            string
+		builtYaml       []yaml.BuiltYaml
+		expected        []Grant
+		expectedUnknown []UnknownBinding
+	}{
+		{
+			name: "Case 1: verbs added and removed in a namespace",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/web",
+				YamlPrBranch:     role("[get, watch]"),
+				YamlTargetBranch: role("[get, list]"),
+			}},
+			expected: []Grant{
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "configmaps", Added: []string{"watch"}, Removed: []string{"list"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "configmaps.apps", Added: []string{"watch"}, Removed: []string{"list"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "deployments", Added: []string{"watch"}, Removed: []string{"list"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "deployments.apps", Added: []string{"watch"}, Removed: []string{"list"}},
+			},
+		},
+		{
+			name: "Case 2: cluster-wide escalation, with the role and binding in different apps",
+			builtYaml: []yaml.BuiltYaml{
+				{AppPath: "prod/bindings", YamlPrBranch: clusterRoleBinding},
+				{AppPath: "prod/roles", YamlPrBranch: clusterRole},
+			},
+			expected: []Grant{
+				{Environment: "prod", Subject: "Group support", Scope: ClusterWide, Resource: "users", Added: []string{"impersonate"}, Risks: []string{"cluster-wide", "impersonate"}},
+			},
+		},
+		{
+			name: "Case 3: wildcards",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/web",
+				YamlPrBranch:     role(`["*"]`),
+				YamlTargetBranch: role("[get]"),
+			}},
+			expected: []Grant{
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "configmaps", Added: []string{"*"}, Removed: []string{"get"}, Risks: []string{"wildcard"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "configmaps.apps", Added: []string{"*"}, Removed: []string{"get"}, Risks: []string{"wildcard"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "deployments", Added: []string{"*"}, Removed: []string{"get"}, Risks: []string{"wildcard"}},
+				{Environment: "prod", Subject: "ServiceAccount web/app", Scope: "web", Resource: "deployments.apps", Added: []string{"*"}, Removed: []string{"get"}, Risks: []string{"wildcard"}},
+			},
+		},
+		{
+			name: "Case 4: binding to a role that isn't rendered",
+			builtYaml: []yaml.BuiltYaml{
+				{AppPath: "prod/bindings", YamlPrBranch: clusterRoleBinding},
+			},
+			expectedUnknown: []UnknownBinding{
+				{Environment: "prod", Subject: "Group support", Scope: ClusterWide, Role: "ClusterRole impersonator", Added: true},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			analyser := NewAnalyser(log.New(io.Discard, "", 0))
+			result, err := analyser.Analyse(testCase.builtYaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Grants, testCase.expected) {
+				t.Errorf("Expected grants %+v, got %+v", testCase.expected, result.Grants)
+			}
+			if !reflect.DeepEqual(result.UnknownBindings, testCase.expectedUnknown) {
+				t.Errorf("Expected unknown bindings %+v, got %+v", testCase.expectedUnknown, result.UnknownBindings)
+			}
+		})
+	}
+}
+
+func TestResult_Section_UnknownBindings(t *testing.T) {
+	result := Result{UnknownBindings: []UnknownBinding{
+		{Environment: "prod", Subject: "Group support", Scope: ClusterWide, Role: "ClusterRole admin", Added: true},
+	}}
+	section := result.Section()
+	if section.Title != "RBAC changes: 1 subjects" {
+		t.Errorf("Unexpected title %q", section.Title)
+	}
+	// a binding whose permissions are unknown isn't presented as a permission, or flagged as a risk
+	for _, unexpected := range []string{"high risk", ":rotating_light:", "Added verbs"} {
+		if strings.Contains(section.Body, unexpected) {
+			t.Errorf("Expected no %q in the section, got:\n%v", unexpected, section.Body)
+		}
+	}
+	if !strings.Contains(section.Body, "| prod | `Group support` | cluster-wide | `ClusterRole admin` | added |") {
+		t.Errorf("Expected the binding to be listed, got:\n%v", section.Body)
+	}
+}
@@ -0,0 +1,45 @@
+package report
+
+import (
+	"strings"
+)
+
+// Section is a titled part of the report posted ahead of the per-app diffs
+type Section struct {
+	Title string
+	Body  string // markdown
+}
+
+// Table formats rows as a markdown table
+func Table(headers []string, rows [][]string) string {
+	var builder strings.Builder
+	builder.WriteString("| " + strings.Join(escapeCells(headers), " | ") + " |\n")
+	builder.WriteString("|" + strings.Repeat(" --- |", len(headers)) + "\n")
+	for _, row := range rows {
+		builder.WriteString("| " + strings.Join(escapeCells(row), " | ") + " |\n")
+	}
+	return builder.String()
+}
+
+// escapeCells keeps cell contents from breaking out of their table cell
+func escapeCells(cells []string) []string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		cell = strings.ReplaceAll(cell, "|", "\\|")
+		escaped[i] = strings.ReplaceAll(strings.TrimSpace(cell), "\n", "<br>")
+	}
+	return escaped
+}
+
+// Code formats text as inline code
+func Code(text string) string {
+	if text == "" {
+		return ""
+	}
+	return "`" + strings.ReplaceAll(text, "`", "'") + "`"
+}
+
+// DiffBlock formats a unified diff as a fenced diff block
+func DiffBlock(diff string) string {
+	return "```diff\n" + strings.TrimSuffix(diff, "\n") + "\n```\n"
+}
@@ -0,0 +1,192 @@
+package risk
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// The kinds of destructive change
+const (
+	ChangeDeleted    = "deleted"
+	ChangeRecreated  = "recreated"
+	ChangeNamespaced = "moved namespace"
+)
+
+// DefaultKinds are the stateful and cluster-scoped kinds whose deletion loses data or takes other resources with it
+var DefaultKinds = []string{
+	"CustomResourceDefinition",
+	"Namespace",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"StatefulSet",
+	"StorageClass",
+}
+
+// DefaultImmutableFields are the fields, as Kind:path, that can't be changed without deleting and recreating the resource
+var DefaultImmutableFields = []string{
+	"DaemonSet:spec.selector",
+	"Deployment:spec.selector",
+	"Job:spec.selector",
+	"Job:spec.template",
+	"PersistentVolumeClaim:spec.storageClassName",
+	"PersistentVolumeClaim:spec.volumeName",
+	"Service:spec.clusterIP",
+	"StatefulSet:spec.podManagementPolicy",
+	"StatefulSet:spec.selector",
+	"StatefulSet:spec.serviceName",
+	"StatefulSet:spec.volumeClaimTemplates",
+}
+
+// Change is a change to a resource that destroys it or something it holds
+type Change struct {
+	AppPath string
+	ID      manifest.ID
+	Type    string
+	Detail  string
+}
+
+// Result is every destructive change of the changed apps
+type Result struct {
+	Changes []Change
+}
+
+// Banner describes the destructive changes for the top of the report
+func (R Result) Banner() string {
+	var body strings.Builder
+	for _, change := range R.Changes {
+		body.WriteString(fmt.Sprintf("- **%s** %s in %s", change.Type, report.Code(change.ID.String()), report.Code(change.AppPath)))
+		if change.Detail != "" {
+			body.WriteString(": " + change.Detail)
+		}
+		body.WriteString("\n")
+	}
+	return body.String()
+}
+
+// Classifier finds the changes that destroy resources rather than update them in place:
+// deletions of dangerous kinds, changes to immutable fields and moves between namespaces
+type Classifier struct {
+	kinds           []string
+	immutableFields []string
+	logger          *log.Logger
+}
+
+func NewClassifier(kinds, immutableFields []string, logger *log.Logger) *Classifier {
+	return &Classifier{
+		kinds:           kinds,
+		immutableFields: immutableFields,
+		logger:          logger,
+	}
+}
+
+// immutableField is a parsed Kind:path
+type immutableField struct {
+	kind string
+	path []string
+}
+
+// Classify compares both branches of each app
+func (C *Classifier) Classify(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	fields := []immutableField{}
+	for _, field := range C.immutableFields {
+		kind, path, found := strings.Cut(field, ":")
+		if !found || kind == "" || path == "" {
+			return result, fmt.Errorf("immutable fields must be given as Kind:path, got %s", field)
+		}
+		fields = append(fields, immutableField{kind: kind, path: strings.Split(path, ".")})
+	}
+	kinds := map[string]bool{}
+	for _, kind := range C.kinds {
+		kinds[kind] = true
+	}
+
+	type branches struct {
+		pr     []manifest.Resource
+		target []manifest.Resource
+	}
+	apps := []branches{}
+	// resources moving between apps of an environment are not deleted
+	rendered := map[string]bool{}
+	for _, builtYaml := range builtYamls {
+		prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		apps = append(apps, branches{pr: prResources, target: targetResources})
+		for _, resource := range prResources {
+			rendered[file.Environment(builtYaml.AppPath)+" "+resource.ID.String()] = true
+		}
+	}
+
+	for i, builtYaml := range builtYamls {
+		prObjects := map[manifest.ID]map[string]interface{}{}
+		for _, resource := range apps[i].pr {
+			prObjects[resource.ID] = resource.Object
+		}
+		targetIDs := map[manifest.ID]bool{}
+		for _, resource := range apps[i].target {
+			targetIDs[resource.ID] = true
+		}
+
+		for _, resource := range apps[i].target {
+			newObject, kept := prObjects[resource.ID]
+			if kept {
+				for _, field := range fields {
+					if field.kind != resource.ID.Kind {
+						continue
+					}
+					if !reflect.DeepEqual(manifest.Field(resource.Object, field.path...), manifest.Field(newObject, field.path...)) {
+						result.Changes = append(result.Changes, Change{
+							AppPath: builtYaml.AppPath,
+							ID:      resource.ID,
+							Type:    ChangeRecreated,
+							Detail:  report.Code(strings.Join(field.path, ".")) + " is immutable",
+						})
+					}
+				}
+				continue
+			}
+
+			if moved, ok := movedTo(resource.ID, apps[i].pr, targetIDs); ok {
+				result.Changes = append(result.Changes, Change{
+					AppPath: builtYaml.AppPath,
+					ID:      resource.ID,
+					Type:    ChangeNamespaced,
+					Detail:  fmt.Sprintf("to %s, deleting it from %s", report.Code(moved.Namespace), report.Code(resource.ID.Namespace)),
+				})
+				continue
+			}
+			if kinds[resource.ID.Kind] && !rendered[file.Environment(builtYaml.AppPath)+" "+resource.ID.String()] {
+				result.Changes = append(result.Changes, Change{
+					AppPath: builtYaml.AppPath,
+					ID:      resource.ID,
+					Type:    ChangeDeleted,
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+// movedTo finds a new resource of the same kind and name as a removed one, in another namespace
+func movedTo(id manifest.ID, prResources []manifest.Resource, targetIDs map[manifest.ID]bool) (manifest.ID, bool) {
+	for _, resource := range prResources {
+		other := resource.ID
+		if other.Group == id.Group && other.Kind == id.Kind && other.Name == id.Name && other.Namespace != id.Namespace && !targetIDs[other] {
+			return other, true
+		}
+	}
+	return manifest.ID{}, false
+}
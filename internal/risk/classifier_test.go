@@ -0,0 +1,115 @@
+package risk
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func TestClassifier_Classify(t *testing.T) {
+	pvc := `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: db
+`
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: db
+`
+	statefulSet := func(selector, storage string) string {
+		return `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: db
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: ` + selector + `
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      resources:
+        requests:
+          storage: ` + storage + "\n"
+	}
+
+	testCases := []struct {
+		name      string
+		builtYaml []yaml.BuiltYaml
+		expected  []string // the type and resource of each change
+	}{
+		{
+			name: "Case 1: deleting dangerous and safe kinds",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/db",
+				YamlTargetBranch: pvc + "---\n" + configMap,
+			}},
+			expected: []string{"deleted PersistentVolumeClaim/db/data"},
+		},
+		{
+			name: "Case 2: changing immutable fields",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/db",
+				YamlPrBranch:     statefulSet("database", "20Gi"),
+				YamlTargetBranch: statefulSet("db", "10Gi"),
+			}},
+			expected: []string{
+				"recreated StatefulSet.apps/db/db",
+				"recreated StatefulSet.apps/db/db",
+			},
+		},
+		{
+			name: "Case 3: changing mutable fields",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/db",
+				YamlPrBranch:     statefulSet("db", "10Gi") + "  minReadySeconds: 10\n",
+				YamlTargetBranch: statefulSet("db", "10Gi"),
+			}},
+		},
+		{
+			name: "Case 4: moving namespace",
+			builtYaml: []yaml.BuiltYaml{{
+				AppPath:          "prod/db",
+				YamlPrBranch:     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: database\n",
+				YamlTargetBranch: configMap,
+			}},
+			expected: []string{"moved namespace ConfigMap/db/settings"},
+		},
+		{
+			name: "Case 5: moving between apps of an environment",
+			builtYaml: []yaml.BuiltYaml{
+				{AppPath: "prod/db", YamlTargetBranch: pvc},
+				{AppPath: "prod/storage", YamlPrBranch: pvc},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			classifier := NewClassifier(DefaultKinds, DefaultImmutableFields, log.New(io.Discard, "", 0))
+			result, err := classifier.Classify(testCase.builtYaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := []string{}
+			for _, change := range result.Changes {
+				actual = append(actual, change.Type+" "+change.ID.String())
+			}
+			if len(actual) != len(testCase.expected) {
+				t.Fatalf("Expected changes %v, got %v", testCase.expected, result.Changes)
+			}
+			for i := range actual {
+				if actual[i] != testCase.expected[i] {
+					t.Errorf("Expected changes %v, got %v", testCase.expected, result.Changes)
+				}
+			}
+		})
+	}
+}
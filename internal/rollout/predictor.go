@@ -0,0 +1,177 @@
+package rollout
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/file"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// workloadKinds are the kinds that replace their pods when their pod template changes
+var workloadKinds = map[string]bool{
+	"apps/Deployment":  true,
+	"apps/StatefulSet": true,
+	"apps/DaemonSet":   true,
+	"batch/Job":        true,
+}
+
+// Rollout is a workload whose pods will be replaced, and the fields that cause it
+type Rollout struct {
+	AppPath  string
+	ID       manifest.ID
+	Triggers []string
+}
+
+// Result is every workload of the changed apps that will roll out
+type Result struct {
+	Rollouts []Rollout
+}
+
+// Section describes the rollouts for the PR report
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	for _, rollout := range R.Rollouts {
+		rows = append(rows, []string{
+			report.Code(rollout.ID.Kind + "/" + rollout.ID.Name),
+			rollout.ID.Namespace,
+			file.Environment(rollout.AppPath),
+			strings.Join(rollout.Triggers, "\n"),
+		})
+	}
+	return report.Section{
+		Title: fmt.Sprintf("Rollouts: %d workloads will restart their pods", len(R.Rollouts)),
+		Body:  report.Table([]string{"Workload", "Namespace", "Environment", "Trigger"}, rows),
+	}
+}
+
+// Predictor finds the modified workloads whose pod template changed, so will
+// replace their pods, including through a generated ConfigMap or Secret being renamed
+type Predictor struct {
+	logger *log.Logger
+}
+
+func NewPredictor(logger *log.Logger) *Predictor {
+	return &Predictor{
+		logger: logger,
+	}
+}
+
+// Predict compares the pod templates of workloads in both branches of each app
+func (P *Predictor) Predict(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+	for _, builtYaml := range builtYamls {
+		prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, err
+		}
+		targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+		if err != nil {
+			return result, err
+		}
+		// a rename means the generated object's content changed
+		renames := map[string]manifest.Rename{}
+		for _, rename := range manifest.FindRenames(targetResources, prResources) {
+			renames[rename.From.Name+" "+rename.To.Name] = rename
+		}
+		oldObjects := map[manifest.ID]map[string]interface{}{}
+		for _, resource := range targetResources {
+			oldObjects[resource.ID] = resource.Object
+		}
+
+		for _, resource := range prResources {
+			if !workloadKinds[resource.ID.Group+"/"+resource.ID.Kind] {
+				continue
+			}
+			oldObject, ok := oldObjects[resource.ID]
+			if !ok {
+				continue
+			}
+			changes := []change{}
+			diffFields("spec.template", manifest.Field(oldObject, "spec", "template"), manifest.Field(resource.Object, "spec", "template"), &changes)
+			if len(changes) == 0 {
+				continue
+			}
+			triggers := []string{}
+			for _, change := range changes {
+				triggers = append(triggers, trigger(change, renames))
+			}
+			P.logger.Println("predicting rollout of", resource.ID, "in", builtYaml.AppPath)
+			result.Rollouts = append(result.Rollouts, Rollout{
+				AppPath:  builtYaml.AppPath,
+				ID:       resource.ID,
+				Triggers: triggers,
+			})
+		}
+	}
+	return result, nil
+}
+
+// change is a field whose value differs between branches
+type change struct {
+	path     string
+	oldValue interface{}
+	newValue interface{}
+}
+
+// trigger describes why a change rolls a workload, naming indirect causes where it can
+func trigger(change change, renames map[string]manifest.Rename) string {
+	if annotation, ok := strings.CutPrefix(change.path, "spec.template.metadata.annotations."); ok && strings.Contains(annotation, "checksum") {
+		return "checksum annotation " + report.Code(annotation)
+	}
+	oldName, oldOk := change.oldValue.(string)
+	newName, newOk := change.newValue.(string)
+	if rename, ok := renames[oldName+" "+newName]; oldOk && newOk && ok {
+		base, _ := manifest.GeneratorBase(rename.To)
+		return fmt.Sprintf("content of generated %s %s, referenced by %s", rename.To.Kind, report.Code(base), report.Code(change.path))
+	}
+	return report.Code(change.path)
+}
+
+// diffFields lists the paths of the fields that differ between two values. Lists of
+// the same length are compared item by item, labelling items by name where they have one.
+func diffFields(path string, oldValue, newValue interface{}, changes *[]change) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		sortedKeys := []string{}
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+		for _, key := range sortedKeys {
+			diffFields(path+"."+key, oldMap[key], newMap[key], changes)
+		}
+		return
+	}
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList && len(oldList) == len(newList) {
+		for i := range oldList {
+			label := fmt.Sprint(i)
+			oldItem, _ := oldList[i].(map[string]interface{})
+			newItem, _ := newList[i].(map[string]interface{})
+			if name := manifest.String(newItem, "name"); name != "" && name == manifest.String(oldItem, "name") {
+				label = name
+			}
+			diffFields(fmt.Sprintf("%s[%s]", path, label), oldList[i], newList[i], changes)
+		}
+		return
+	}
+	*changes = append(*changes, change{path: path, oldValue: oldValue, newValue: newValue})
+}
@@ -0,0 +1,83 @@
+package rollout
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+func deployment(replicas int, image, configMap, checksum string) string {
+	return testutil.Deployment{
+		Namespace:   "shop",
+		Replicas:    replicas,
+		Annotations: map[string]string{"checksum/config": checksum},
+		Containers:  []testutil.Container{{Name: "web", Image: image, ConfigMap: configMap}},
+	}.Yaml()
+}
+
+func configMap(name string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n  namespace: shop\n---\n"
+}
+
+func TestPredictor_Predict(t *testing.T) {
+	testCases := []struct {
+		name       string
+		prYaml     string
+		targetYaml string
+		expected   [][]string // the triggers of each rollout
+	}{
+		{
+			name:       "Case 1: scaling doesn't roll",
+			prYaml:     deployment(3, "web:1.0", "settings", "abc"),
+			targetYaml: deployment(2, "web:1.0", "settings", "abc"),
+		},
+		{
+			name:       "Case 2: image change",
+			prYaml:     deployment(2, "web:1.1", "settings", "abc"),
+			targetYaml: deployment(2, "web:1.0", "settings", "abc"),
+			expected:   [][]string{{"`spec.template.spec.containers[web].image`"}},
+		},
+		{
+			name:       "Case 3: checksum annotation",
+			prYaml:     deployment(2, "web:1.0", "settings", "def"),
+			targetYaml: deployment(2, "web:1.0", "settings", "abc"),
+			expected:   [][]string{{"checksum annotation `checksum/config`"}},
+		},
+		{
+			name:       "Case 4: generated ConfigMap renamed by its content",
+			prYaml:     configMap("settings-9d7bk2hm6t") + deployment(2, "web:1.0", "settings-9d7bk2hm6t", "abc"),
+			targetYaml: configMap("settings-h6f5g7t2c8") + deployment(2, "web:1.0", "settings-h6f5g7t2c8", "abc"),
+			expected: [][]string{{
+				"content of generated ConfigMap `settings`, referenced by `spec.template.spec.containers[web].envFrom[0].configMapRef.name`",
+			}},
+		},
+		{
+			name:   "Case 5: added workloads aren't rollouts",
+			prYaml: deployment(2, "web:1.0", "settings", "abc"),
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			predictor := NewPredictor(log.New(io.Discard, "", 0))
+			result, err := predictor.Predict([]yaml.BuiltYaml{{
+				AppPath:          "prod/shop",
+				YamlPrBranch:     testCase.prYaml,
+				YamlTargetBranch: testCase.targetYaml,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := [][]string{}
+			for _, rollout := range result.Rollouts {
+				actual = append(actual, rollout.Triggers)
+			}
+			if len(actual)+len(testCase.expected) > 0 && !reflect.DeepEqual(actual, testCase.expected) {
+				t.Errorf("Expected triggers %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of JSON schema and OpenAPI v3 used by kubernetes
+// resource schemas, both the standalone JSON schemas generated from the
+// kubernetes OpenAPI spec and the openAPIV3Schema of CRDs.
+type Schema struct {
+	Type                  types              `json:"type"`
+	Properties            map[string]*Schema `json:"properties"`
+	AdditionalProperties  *additional        `json:"additionalProperties"`
+	Required              []string           `json:"required"`
+	Items                 *Schema            `json:"items"`
+	Enum                  []interface{}      `json:"enum"`
+	OneOf                 []*Schema          `json:"oneOf"`
+	AnyOf                 []*Schema          `json:"anyOf"`
+	AllOf                 []*Schema          `json:"allOf"`
+	Nullable              bool               `json:"nullable"`
+	Format                string             `json:"format"`
+	IntOrString           bool               `json:"x-kubernetes-int-or-string"`
+	PreserveUnknownFields bool               `json:"x-kubernetes-preserve-unknown-fields"`
+}
+
+// types is a JSON schema type, which is either a single type or a list of them
+type types []string
+
+func (T *types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*T = types{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*T = list
+	return nil
+}
+
+// additional is additionalProperties, which is either a boolean or a schema
+type additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (A *additional) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		A.Allowed = allowed
+		return nil
+	}
+	A.Allowed = true
+	A.Schema = &Schema{}
+	return json.Unmarshal(data, A.Schema)
+}
+
+// FieldError is a single problem with a field of an object
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (E FieldError) String() string {
+	return E.Path + ": " + E.Message
+}
+
+// rootFields are set on every object, but often left out of CRD schemas
+var rootFields = map[string]bool{
+	"apiVersion": true,
+	"kind":       true,
+	"metadata":   true,
+}
+
+// Validate checks an object against a schema. Objects are strict: fields
+// that aren't in the schema are errors unless the schema allows them.
+func (S *Schema) Validate(object map[string]interface{}) []FieldError {
+	errors := []FieldError{}
+	S.validate("", object, true, &errors)
+	return errors
+}
+
+func (S *Schema) validate(path string, value interface{}, root bool, errors *[]FieldError) {
+	fail := func(format string, args ...interface{}) {
+		fieldPath := path
+		if fieldPath == "" {
+			fieldPath = "."
+		}
+		*errors = append(*errors, FieldError{Path: fieldPath, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if S.Nullable || S.Type.allows("null") || len(S.Type) == 0 {
+			return
+		}
+		fail("must not be null")
+		return
+	}
+
+	for _, schema := range S.AllOf {
+		schema.validate(path, value, root, errors)
+	}
+	if alternatives := append(append([]*Schema{}, S.OneOf...), S.AnyOf...); len(alternatives) > 0 {
+		matched := false
+		for _, alternative := range alternatives {
+			alternativeErrors := []FieldError{}
+			alternative.validate(path, value, root, &alternativeErrors)
+			if len(alternativeErrors) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("doesn't match any of the allowed schemas")
+			return
+		}
+	}
+
+	if S.IntOrString {
+		if _, ok := value.(string); !ok && !isInteger(value) {
+			fail("must be an integer or a string, got %s", typeOf(value))
+		}
+		return
+	}
+
+	if len(S.Type) > 0 && !S.Type.allows(typeOf(value)) && !(S.Type.allows("number") && typeOf(value) == "integer") {
+		fail("must be %s, got %s", strings.Join(S.Type, " or "), typeOf(value))
+		return
+	}
+
+	if len(S.Enum) > 0 {
+		found := false
+		for _, allowed := range S.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			fail("must be one of %v, got %v", S.Enum, value)
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		S.validateObject(path, typed, root, errors, fail)
+	case []interface{}:
+		if S.Items == nil {
+			return
+		}
+		for i, item := range typed {
+			S.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, false, errors)
+		}
+	}
+}
+
+func (S *Schema) validateObject(path string, object map[string]interface{}, root bool, errors *[]FieldError, fail func(string, ...interface{})) {
+	for _, field := range S.Required {
+		if _, ok := object[field]; !ok {
+			fail("missing required field %s", field)
+		}
+	}
+
+	// iterate in order so that errors are reported the same way every run
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		fieldPath := path + "." + field
+		if property, ok := S.Properties[field]; ok {
+			property.validate(fieldPath, object[field], false, errors)
+			continue
+		}
+		switch {
+		case S.AdditionalProperties != nil && S.AdditionalProperties.Schema != nil:
+			S.AdditionalProperties.Schema.validate(fieldPath, object[field], false, errors)
+		case S.AdditionalProperties != nil && S.AdditionalProperties.Allowed:
+		case S.PreserveUnknownFields:
+		case root && rootFields[field]:
+		case len(S.Properties) == 0 && S.AdditionalProperties == nil:
+			// a schema without any properties says nothing about the object's fields
+		default:
+			*errors = append(*errors, FieldError{Path: fieldPath, Message: "unknown field"})
+		}
+	}
+}
+
+func (T types) allows(name string) bool {
+	for _, allowed := range T {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf names the JSON schema type of a decoded yaml value
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	if isInteger(value) {
+		return "integer"
+	}
+	switch value.(type) {
+	case float32, float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func isInteger(value interface{}) bool {
+	switch typed := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	case float64:
+		return typed == math.Trunc(typed) && !math.IsInf(typed, 0)
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// ResourceFailure is a resource that doesn't match its schema
+type ResourceFailure struct {
+	AppPath string
+	ID      manifest.ID
+	Errors  []FieldError
+}
+
+// Result is the outcome of validating the PR branch render of every app
+type Result struct {
+	Failures []ResourceFailure
+	// the apiVersion and kind of resources that had no schema, so weren't validated
+	Missing []string
+}
+
+// Failed is whether any resource failed validation
+func (R Result) Failed() bool {
+	return len(R.Failures) > 0
+}
+
+// Section describes the validation for the PR report
+func (R Result) Section() report.Section {
+	var body strings.Builder
+	if len(R.Failures) == 0 {
+		body.WriteString("All resources match their schemas.\n\n")
+	} else {
+		body.WriteString(fmt.Sprintf(":x: **%d resources don't match their schemas**\n\n", len(R.Failures)))
+		rows := [][]string{}
+		for _, failure := range R.Failures {
+			messages := []string{}
+			for _, fieldError := range failure.Errors {
+				messages = append(messages, report.Code(fieldError.Path)+" "+fieldError.Message)
+			}
+			rows = append(rows, []string{failure.AppPath, report.Code(failure.ID.String()), strings.Join(messages, "\n")})
+		}
+		body.WriteString(report.Table([]string{"App", "Resource", "Errors"}, rows))
+		body.WriteString("\n")
+	}
+	if len(R.Missing) > 0 {
+		missing := []string{}
+		for _, kind := range R.Missing {
+			missing = append(missing, report.Code(kind))
+		}
+		body.WriteString("No schema was found for " + strings.Join(missing, ", ") + ", so these weren't validated.\n")
+	}
+	return report.Section{
+		Title: "Schema validation",
+		Body:  body.String(),
+	}
+}
+
+// Validator checks rendered resources against kubernetes JSON schemas read from
+// a local directory, and the schemas of CRDs found in the repo and in renders,
+// so that it works without access to a cluster or the internet.
+type Validator struct {
+	schemaDir         string
+	kubernetesVersion string
+	crdDirs           []string
+	schemas           map[string]*Schema // by apiVersion and kind, nil when there is no schema
+	logger            *log.Logger
+}
+
+func NewValidator(schemaDir, kubernetesVersion string, crdDirs []string, logger *log.Logger) *Validator {
+	return &Validator{
+		schemaDir:         schemaDir,
+		kubernetesVersion: kubernetesVersion,
+		crdDirs:           crdDirs,
+		schemas:           map[string]*Schema{},
+		logger:            logger,
+	}
+}
+
+// Validate checks every document of the PR branch renders
+func (V *Validator) Validate(builtYamls []yaml.BuiltYaml) (Result, error) {
+	result := Result{}
+
+	// CRDs may be rendered by any app, not just the one using them
+	apps := map[string][]manifest.Resource{}
+	for _, builtYaml := range builtYamls {
+		resources, err := manifest.Parse(builtYaml.YamlPrBranch)
+		if err != nil {
+			return result, fmt.Errorf("error parsing %s: %w", builtYaml.AppPath, err)
+		}
+		apps[builtYaml.AppPath] = resources
+		V.addCRDs(resources)
+	}
+	for _, dir := range V.crdDirs {
+		if err := V.loadCRDs(dir); err != nil {
+			return result, err
+		}
+	}
+	schemaDir := V.versionDir()
+
+	missing := utils.NewSet()
+	for _, builtYaml := range builtYamls {
+		for _, resource := range apps[builtYaml.AppPath] {
+			schema, err := V.schema(schemaDir, resource.APIVersion, resource.ID.Kind)
+			if err != nil {
+				return result, err
+			}
+			if schema == nil {
+				missing[resource.APIVersion+" "+resource.ID.Kind] = struct{}{}
+				continue
+			}
+			if errors := schema.Validate(resource.Object); len(errors) > 0 {
+				result.Failures = append(result.Failures, ResourceFailure{
+					AppPath: builtYaml.AppPath,
+					ID:      resource.ID,
+					Errors:  errors,
+				})
+			}
+		}
+	}
+	result.Missing = missing.Sorted(func(a, b string) bool { return a < b })
+	return result, nil
+}
+
+// versionDir finds the schemas of the target kubernetes version, laid out as in
+// github.com/yannh/kubernetes-json-schema, falling back to the directory itself
+func (V *Validator) versionDir() string {
+	if V.kubernetesVersion == "" {
+		return V.schemaDir
+	}
+	version := "v" + strings.TrimPrefix(V.kubernetesVersion, "v")
+	for _, name := range []string{version + "-standalone-strict", version + "-standalone", version} {
+		dir := filepath.Join(V.schemaDir, name)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	V.logger.Println("no schemas found for kubernetes version", V.kubernetesVersion, "using", V.schemaDir)
+	return V.schemaDir
+}
+
+// schema finds the schema of a kind, preferring CRDs over files
+func (V *Validator) schema(schemaDir, apiVersion, kind string) (*Schema, error) {
+	key := schemaKey(apiVersion, kind)
+	if schema, ok := V.schemas[key]; ok {
+		return schema, nil
+	}
+
+	group := manifest.Group(apiVersion)
+	version := apiVersion[strings.LastIndex(apiVersion, "/")+1:]
+	kind = strings.ToLower(kind)
+	candidates := []string{kind + "-" + version + ".json"}
+	if group != "" {
+		// the standalone kubernetes schemas name files by the first part of the group,
+		// CRD catalogs nest them under the full group
+		candidates = []string{
+			kind + "-" + strings.Split(group, ".")[0] + "-" + version + ".json",
+			filepath.Join(group, kind+"_"+version+".json"),
+		}
+	}
+
+	V.schemas[key] = nil
+	for _, candidate := range candidates {
+		content, err := os.ReadFile(filepath.Join(schemaDir, candidate))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		schema := &Schema{}
+		if err := json.Unmarshal(content, schema); err != nil {
+			return nil, fmt.Errorf("error parsing schema %s: %w", candidate, err)
+		}
+		V.schemas[key] = schema
+		break
+	}
+	return V.schemas[key], nil
+}
+
+// loadCRDs reads the CRDs from every yaml file under a directory
+func (V *Validator) loadCRDs(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !(strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		resources, err := manifest.Parse(string(content))
+		if err != nil {
+			// templates, e.g. of helm charts, aren't valid yaml until rendered
+			V.logger.Println("skipping unparseable file when loading CRDs:", path)
+			return nil
+		}
+		V.addCRDs(resources)
+		return nil
+	})
+}
+
+// addCRDs registers the schemas of every version of the CRDs among resources
+func (V *Validator) addCRDs(resources []manifest.Resource) {
+	for _, resource := range resources {
+		if resource.ID.Kind != "CustomResourceDefinition" || resource.ID.Group != "apiextensions.k8s.io" {
+			continue
+		}
+		group := manifest.String(resource.Object, "spec", "group")
+		kind := manifest.String(resource.Object, "spec", "names", "kind")
+		versions, _ := manifest.Field(resource.Object, "spec", "versions").([]interface{})
+		for _, version := range versions {
+			version, ok := version.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			// apiextensions.k8s.io/v1beta1 CRDs may share one schema between versions
+			openAPISchema := manifest.Field(version, "schema", "openAPIV3Schema")
+			if openAPISchema == nil {
+				openAPISchema = manifest.Field(resource.Object, "spec", "validation", "openAPIV3Schema")
+			}
+			if openAPISchema == nil {
+				continue
+			}
+			schema, err := toSchema(openAPISchema)
+			if err != nil {
+				V.logger.Println("skipping invalid schema of CRD", resource.ID.Name, ":", err)
+				continue
+			}
+			V.schemas[schemaKey(group+"/"+manifest.String(version, "name"), kind)] = schema
+		}
+	}
+}
+
+func toSchema(object interface{}) (*Schema, error) {
+	content, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	schema := &Schema{}
+	return schema, json.Unmarshal(content, schema)
+}
+
+func schemaKey(apiVersion, kind string) string {
+	return apiVersion + " " + kind
+}
@@ -0,0 +1,172 @@
+package schema
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+const configMapSchema = `{
+  "type": "object",
+  "required": ["metadata"],
+  "properties": {
+    "apiVersion": {"type": "string"},
+    "kind": {"type": "string"},
+    "metadata": {
+      "type": "object",
+      "properties": {"name": {"type": ["string", "null"]}},
+      "additionalProperties": false
+    },
+    "data": {"type": "object", "additionalProperties": {"type": "string"}}
+  },
+  "additionalProperties": false
+}`
+
+const widgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+  versions:
+  - name: v1
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              replicas:
+                type: integer
+              port:
+                x-kubernetes-int-or-string: true
+              mode:
+                type: string
+                enum: [fast, slow]
+              extra:
+                type: object
+                x-kubernetes-preserve-unknown-fields: true
+`
+
+func TestValidator_Validate(t *testing.T) {
+	schemaDir := t.TempDir()
+	versionDir := filepath.Join(schemaDir, "v1.29.0-standalone-strict")
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, "configmap-v1.json"), []byte(configMapSchema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	crdDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(crdDir, "widgets.yaml"), []byte(widgetCRD), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		yaml            string
+		expectedErrors  []FieldError
+		expectedMissing []string
+	}{
+		{
+			name: "Case 1: valid resources",
+			yaml: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  level: info
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: app
+spec:
+  replicas: 2
+  port: http
+  mode: fast
+  extra:
+    anything: [1, 2]
+`,
+		},
+		{
+			name: "Case 2: unknown fields",
+			yaml: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  lables: {}
+datas: {}
+`,
+			expectedErrors: []FieldError{
+				{Path: ".datas", Message: "unknown field"},
+				{Path: ".metadata.lables", Message: "unknown field"},
+			},
+		},
+		{
+			name: "Case 3: type errors",
+			yaml: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: app
+spec:
+  replicas: "2"
+  port: 1.5
+  mode: medium
+`,
+			expectedErrors: []FieldError{
+				{Path: ".spec.mode", Message: "must be one of [fast slow], got medium"},
+				{Path: ".spec.port", Message: "must be an integer or a string, got number"},
+				{Path: ".spec.replicas", Message: "must be integer, got string"},
+			},
+		},
+		{
+			name: "Case 4: missing required fields and schemas",
+			yaml: `apiVersion: v1
+kind: ConfigMap
+data:
+  replicas: 2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`,
+			expectedErrors: []FieldError{
+				{Path: ".", Message: "missing required field metadata"},
+				{Path: ".data.replicas", Message: "must be string, got integer"},
+			},
+			expectedMissing: []string{"apps/v1 Deployment"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			validator := NewValidator(schemaDir, "1.29.0", []string{crdDir}, log.New(io.Discard, "", 0))
+			result, err := validator.Validate([]yaml.BuiltYaml{{AppPath: "prod/web", YamlPrBranch: testCase.yaml}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			errors := []FieldError{}
+			for _, failure := range result.Failures {
+				errors = append(errors, failure.Errors...)
+			}
+			if len(errors) != len(testCase.expectedErrors) || (len(errors) > 0 && !reflect.DeepEqual(errors, testCase.expectedErrors)) {
+				t.Errorf("Expected errors %v, got %v", testCase.expectedErrors, errors)
+			}
+			if result.Failed() != (len(testCase.expectedErrors) > 0) {
+				t.Errorf("Expected failed to be %v", len(testCase.expectedErrors) > 0)
+			}
+			if !reflect.DeepEqual(result.Missing, testCase.expectedMissing) && len(result.Missing)+len(testCase.expectedMissing) > 0 {
+				t.Errorf("Expected missing schemas %v, got %v", testCase.expectedMissing, result.Missing)
+			}
+		})
+	}
+}
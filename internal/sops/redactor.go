@@ -0,0 +1,283 @@
+package sops
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// How an encrypted value changed between branches
+const (
+	ChangeAdded       = "added"
+	ChangeRemoved     = "removed"
+	ChangeChanged     = "changed"
+	ChangeReencrypted = "re-encrypted" // its ciphertext changed, but without a key we can't tell if its value did
+)
+
+// What encrypted values are replaced with in the diffs
+const (
+	redacted            = "<redacted>"
+	redactedChanged     = "<redacted, changed>"
+	redactedReencrypted = "<redacted, re-encrypted>"
+)
+
+// volatileMetadata are the fields of a sops block that change whenever a document is re-encrypted
+var volatileMetadata = []string{"mac", "lastmodified"}
+
+// Change is an encrypted value added, removed or changed in an app
+type Change struct {
+	App    string
+	ID     manifest.ID
+	Key    string // the path of the value, e.g. data.password
+	Change string
+}
+
+// Result is every change to encrypted values of the changed apps
+type Result struct {
+	Changes []Change
+}
+
+// Section lists the changed keys of encrypted documents for the PR report, never their values
+func (R Result) Section() report.Section {
+	rows := [][]string{}
+	reencrypted := false
+	for _, change := range R.Changes {
+		rows = append(rows, []string{change.App, report.Code(change.ID.String()), report.Code(change.Key), change.Change})
+		reencrypted = reencrypted || change.Change == ChangeReencrypted
+	}
+	body := report.Table([]string{"App", "Resource", "Key", "Change"}, rows)
+	if reencrypted {
+		body += "\nWithout a key to decrypt them, values that were re-encrypted may or may not have changed.\n"
+	}
+	return report.Section{
+		Title: fmt.Sprintf("Encrypted secrets: %d changes", len(R.Changes)),
+		Body:  body,
+	}
+}
+
+// Redactor finds documents encrypted with SOPS in each branch and replaces their
+// ciphertext, which changes on every edit, with markers of which values changed.
+// With age identities that can decrypt them, values are compared as plaintext,
+// otherwise a value whose ciphertext changed is assumed to be re-encrypted.
+type Redactor struct {
+	identities []age.Identity
+	logger     *log.Logger
+}
+
+func NewRedactor(identities []age.Identity, logger *log.Logger) *Redactor {
+	return &Redactor{
+		identities: identities,
+		logger:     logger,
+	}
+}
+
+// document is an encrypted document in a single branch
+type document struct {
+	id     manifest.ID
+	values map[string]value // by the path shown in the report
+	key    []byte           // nil if the document couldn't be decrypted
+}
+
+// value is an encrypted value and the path SOPS authenticates it with
+type value struct {
+	node *goyaml.Node
+	path []string
+}
+
+// Redact replaces the encrypted values of an app's documents in both branches,
+// returning the changes to them. Apps without encrypted documents are left as they are.
+func (R *Redactor) Redact(builtYaml yaml.BuiltYaml) (yaml.BuiltYaml, []Change, error) {
+	prResources, err := manifest.Parse(builtYaml.YamlPrBranch)
+	if err != nil {
+		return builtYaml, nil, err
+	}
+	targetResources, err := manifest.Parse(builtYaml.YamlTargetBranch)
+	if err != nil {
+		return builtYaml, nil, err
+	}
+	prDocuments := R.documents(prResources)
+	targetDocuments := R.documents(targetResources)
+	if len(prDocuments) == 0 && len(targetDocuments) == 0 {
+		return builtYaml, nil, nil
+	}
+
+	ids := []manifest.ID{}
+	for id := range prDocuments {
+		ids = append(ids, id)
+	}
+	for id := range targetDocuments {
+		if _, ok := prDocuments[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	changes := []Change{}
+	for _, id := range ids {
+		prDocument, targetDocument := prDocuments[id], targetDocuments[id]
+		if prDocument == nil {
+			prDocument = &document{id: id, values: map[string]value{}}
+		}
+		if targetDocument == nil {
+			targetDocument = &document{id: id, values: map[string]value{}}
+		}
+		for _, key := range keys(prDocument, targetDocument) {
+			prValue, inPr := prDocument.values[key]
+			targetValue, inTarget := targetDocument.values[key]
+			change := ""
+			switch {
+			case !inTarget:
+				change = ChangeAdded
+			case !inPr:
+				change = ChangeRemoved
+			default:
+				change = R.compare(prDocument, targetDocument, prValue, targetValue)
+			}
+			if inPr {
+				prValue.node.Value = redacted
+			}
+			if inTarget {
+				targetValue.node.Value = redacted
+			}
+			switch change {
+			case ChangeChanged:
+				prValue.node.Value = redactedChanged
+			case ChangeReencrypted:
+				prValue.node.Value = redactedReencrypted
+			}
+			if change != "" {
+				changes = append(changes, Change{App: builtYaml.AppPath, ID: id, Key: key, Change: change})
+			}
+		}
+	}
+
+	builtYaml.YamlPrBranch, err = manifest.Encode(prResources)
+	if err != nil {
+		return builtYaml, nil, err
+	}
+	builtYaml.YamlTargetBranch, err = manifest.Encode(targetResources)
+	if err != nil {
+		return builtYaml, nil, err
+	}
+	return builtYaml, changes, nil
+}
+
+// compare works out how a value in both branches changed, or returns "" if it didn't
+func (R *Redactor) compare(prDocument, targetDocument *document, prValue, targetValue value) string {
+	if prValue.node.Value == targetValue.node.Value {
+		return ""
+	}
+	if prDocument.key == nil || targetDocument.key == nil {
+		return ChangeReencrypted
+	}
+	prPlaintext, err := decrypt(prValue.node.Value, prDocument.key, prValue.path)
+	if err != nil {
+		R.logger.Printf("couldn't decrypt %s of %s: %s", strings.Join(prValue.path, "."), prDocument.id, err)
+		return ChangeReencrypted
+	}
+	targetPlaintext, err := decrypt(targetValue.node.Value, targetDocument.key, targetValue.path)
+	if err != nil {
+		R.logger.Printf("couldn't decrypt %s of %s: %s", strings.Join(targetValue.path, "."), targetDocument.id, err)
+		return ChangeReencrypted
+	}
+	if prPlaintext == targetPlaintext {
+		return ""
+	}
+	return ChangeChanged
+}
+
+// documents finds the encrypted documents of a branch, decrypting their data keys when
+// possible, and redacts the metadata that changes on every encryption
+func (R *Redactor) documents(resources []manifest.Resource) map[manifest.ID]*document {
+	documents := map[manifest.ID]*document{}
+	for _, resource := range resources {
+		root := resource.Node.Content[0]
+		sopsNode := mappingValue(root, metadataKey)
+		if sopsNode == nil || sopsNode.Kind != goyaml.MappingNode {
+			continue
+		}
+		found := &document{id: resource.ID, values: map[string]value{}}
+		key, err := dataKey(sopsNode, R.identities)
+		if err != nil {
+			if len(R.identities) > 0 {
+				R.logger.Printf("couldn't decrypt the data key of %s: %s", resource.ID, err)
+			}
+		} else {
+			found.key = key
+		}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != metadataKey {
+				encryptedValues(root.Content[i+1], []string{root.Content[i].Value}, root.Content[i].Value, found.values)
+			}
+		}
+		for _, field := range volatileMetadata {
+			if node := mappingValue(sopsNode, field); node != nil && node.Kind == goyaml.ScalarNode {
+				node.Value = redacted
+			}
+		}
+		if recipients := mappingValue(sopsNode, "age"); recipients != nil {
+			for _, recipient := range recipients.Content {
+				if node := mappingValue(recipient, "enc"); node != nil {
+					node.Value = redacted
+				}
+			}
+		}
+		documents[resource.ID] = found
+	}
+	return documents
+}
+
+// encryptedValues finds the encrypted values under a node. Items of a list are shown by
+// their index, but SOPS doesn't include it in the path it authenticates them with.
+func encryptedValues(node *goyaml.Node, path []string, shown string, into map[string]value) {
+	switch node.Kind {
+	case goyaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			encryptedValues(node.Content[i+1], append(append([]string{}, path...), key), shown+"."+key, into)
+		}
+	case goyaml.SequenceNode:
+		for i, item := range node.Content {
+			encryptedValues(item, path, shown+"["+strconv.Itoa(i)+"]", into)
+		}
+	case goyaml.ScalarNode:
+		if encryptedValue.MatchString(node.Value) {
+			into[shown] = value{node: node, path: path}
+		}
+	}
+}
+
+func mappingValue(node *goyaml.Node, key string) *goyaml.Node {
+	if node.Kind != goyaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// keys lists the paths of the encrypted values of either branch of a document
+func keys(prDocument, targetDocument *document) []string {
+	all := []string{}
+	for key := range prDocument.values {
+		all = append(all, key)
+	}
+	for key := range targetDocument.values {
+		if _, ok := prDocument.values[key]; !ok {
+			all = append(all, key)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
@@ -0,0 +1,151 @@
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/cyclingwithelephants/kubediff/internal/manifest"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+)
+
+// encryptedSecret encrypts the data of a Secret as SOPS does, with a new data key for an age recipient
+func encryptedSecret(t *testing.T, recipient *age.X25519Recipient, data map[string]string) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	var encryptedKey bytes.Buffer
+	armorWriter := armor.NewWriter(&encryptedKey)
+	ageWriter, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ageWriter.Write(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ageWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nstringData:\n"
+	for _, name := range []string{"password", "user"} {
+		plaintext, ok := data[name]
+		if !ok {
+			continue
+		}
+		iv := make([]byte, 32)
+		if _, err := rand.Read(iv); err != nil {
+			t.Fatal(err)
+		}
+		sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte("stringData:"+name+":"))
+		ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+		secret += fmt.Sprintf("  %s: ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]\n",
+			name,
+			base64.StdEncoding.EncodeToString(ciphertext),
+			base64.StdEncoding.EncodeToString(iv),
+			base64.StdEncoding.EncodeToString(tag),
+		)
+	}
+	secret += "sops:\n  age:\n  - recipient: " + recipient.String() + "\n    enc: |\n"
+	for _, line := range strings.Split(strings.TrimSpace(encryptedKey.String()), "\n") {
+		secret += "      " + line + "\n"
+	}
+	secret += "  lastmodified: \"2023-06-01T00:00:00Z\"\n  mac: ENC[AES256_GCM,data:bWFj,iv:aXY=,tag:dGFn,type:str]\n  version: 3.7.3\n"
+	return secret
+}
+
+func TestRedactor_Redact(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID := manifest.ID{Kind: "Secret", Name: "db"}
+
+	testCases := []struct {
+		name            string
+		identities      []age.Identity
+		prData          map[string]string
+		targetData      map[string]string
+		expectedChanges []Change
+		expectIdentical bool
+	}{
+		{
+			name:            "Case 1: value changed, with a key",
+			identities:      []age.Identity{identity},
+			prData:          map[string]string{"password": "new", "user": "admin"},
+			targetData:      map[string]string{"password": "old", "user": "admin"},
+			expectedChanges: []Change{{App: "prod/db", ID: secretID, Key: "stringData.password", Change: ChangeChanged}},
+		},
+		{
+			name:            "Case 2: re-encrypted without changes, with a key",
+			identities:      []age.Identity{identity},
+			prData:          map[string]string{"password": "same"},
+			targetData:      map[string]string{"password": "same"},
+			expectedChanges: []Change{},
+			expectIdentical: true,
+		},
+		{
+			name:       "Case 3: keys added and re-encrypted, without a key",
+			prData:     map[string]string{"password": "same", "user": "admin"},
+			targetData: map[string]string{"password": "same"},
+			expectedChanges: []Change{
+				{App: "prod/db", ID: secretID, Key: "stringData.password", Change: ChangeReencrypted},
+				{App: "prod/db", ID: secretID, Key: "stringData.user", Change: ChangeAdded},
+			},
+		},
+		{
+			name:            "Case 4: key removed, with a key",
+			identities:      []age.Identity{identity},
+			prData:          map[string]string{"password": "same"},
+			targetData:      map[string]string{"password": "same", "user": "admin"},
+			expectedChanges: []Change{{App: "prod/db", ID: secretID, Key: "stringData.user", Change: ChangeRemoved}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			builtYaml := yaml.BuiltYaml{
+				AppPath:          "prod/db",
+				YamlPrBranch:     encryptedSecret(t, identity.Recipient(), testCase.prData),
+				YamlTargetBranch: encryptedSecret(t, identity.Recipient(), testCase.targetData),
+			}
+			redactor := NewRedactor(testCase.identities, log.New(io.Discard, "", 0))
+			redactedYaml, changes, err := redactor.Redact(builtYaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(changes, testCase.expectedChanges) {
+				t.Errorf("Expected changes %+v, got %+v", testCase.expectedChanges, changes)
+			}
+			if identical := redactedYaml.YamlPrBranch == redactedYaml.YamlTargetBranch; identical != testCase.expectIdentical {
+				t.Errorf("Expected branches to be identical: %t, got:\n%s\n---\n%s", testCase.expectIdentical, redactedYaml.YamlPrBranch, redactedYaml.YamlTargetBranch)
+			}
+			for _, rendered := range []string{redactedYaml.YamlPrBranch, redactedYaml.YamlTargetBranch} {
+				if strings.Contains(rendered, "ENC[") || strings.Contains(rendered, "AGE ENCRYPTED") {
+					t.Errorf("Expected ciphertext to be redacted, got:\n%s", rendered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package sops
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// metadataKey is the top level key SOPS adds to every document it encrypts
+const metadataKey = "sops"
+
+// IsEncrypted reports whether an object is a document encrypted with SOPS, whose values are
+// ciphertext, or markers once redacted, rather than what is decrypted and applied to a cluster
+func IsEncrypted(object map[string]interface{}) bool {
+	_, ok := object[metadataKey].(map[string]interface{})
+	return ok
+}
+
+// encryptedValue matches a value encrypted by SOPS, e.g. ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([^,]*),iv:([^,]*),tag:([^,]*),type:([^\]]*)\]$`)
+
+// LoadIdentities reads age identities the way SOPS does, from a key file and from
+// a value holding keys itself, either of which may be empty
+func LoadIdentities(keyFile, keys string) ([]age.Identity, error) {
+	identities := []age.Identity{}
+	if keyFile != "" {
+		file, err := os.Open(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		parsed, err := age.ParseIdentities(file)
+		if err != nil {
+			return nil, fmt.Errorf("parsing age key file %s: %w", keyFile, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	if keys != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(keys))
+		if err != nil {
+			return nil, fmt.Errorf("parsing age keys: %w", err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
+
+// metadata is the part of a document's sops block needed to decrypt it
+type metadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+}
+
+// dataKey decrypts the key a document's values are encrypted with, using the first
+// of its age recipients that one of the identities can decrypt
+func dataKey(sopsNode *goyaml.Node, identities []age.Identity) ([]byte, error) {
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no age identities")
+	}
+	meta := metadata{}
+	if err := sopsNode.Decode(&meta); err != nil {
+		return nil, err
+	}
+	if len(meta.Age) == 0 {
+		return nil, fmt.Errorf("not encrypted for any age recipient")
+	}
+	var lastErr error
+	for _, recipient := range meta.Age {
+		reader, err := age.Decrypt(armor.NewReader(strings.NewReader(recipient.Enc)), identities...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return io.ReadAll(reader)
+	}
+	return nil, lastErr
+}
+
+// decrypt decrypts a value with a document's data key. SOPS authenticates each value
+// with its path, the keys above it joined by colons, so it can't be moved elsewhere.
+func decrypt(value string, key []byte, path []string) (string, error) {
+	match := encryptedValue.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("value isn't encrypted by SOPS")
+	}
+	parts := [][]byte{}
+	for _, encoded := range match[1:4] {
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, decoded)
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	additionalData := strings.Join(path, ":") + ":"
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	// the type is part of the value, so that 1 and "1" differ
+	return match[4] + ":" + string(plaintext), nil
+}
@@ -0,0 +1,197 @@
+package tanka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	"github.com/google/go-jsonnet"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+const (
+	mainFile          = "main.jsonnet"
+	specFile          = "spec.json"
+	environmentExtVar = "tanka.dev/environment"
+)
+
+// Builder evaluates Tanka environments in process with go-jsonnet, resolving imports
+// the way Tanka does, and extracts the Kubernetes objects of their output
+type Builder struct {
+	prDir     string // the directory where the PR branch is checked out
+	targetDir string // the directory where the target branch is checked out
+	tankaDir  string // the directory containing the Tanka environments
+	runner    *yaml.Runner
+	logger    *log.Logger
+}
+
+func NewBuilder(prDir, targetDir, tankaDir string, runner *yaml.Runner, logger *log.Logger) *Builder {
+	return &Builder{
+		prDir:     prDir,
+		targetDir: targetDir,
+		tankaDir:  tankaDir,
+		runner:    runner,
+		logger:    logger,
+	}
+}
+
+func (B *Builder) Build(ctx context.Context, appPath string) (yaml.BuiltYaml, error) {
+	prYaml, err := B.render(ctx, B.prDir, appPath)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+	targetYaml, err := B.render(ctx, B.targetDir, appPath)
+	if err != nil {
+		return yaml.BuiltYaml{}, err
+	}
+	return yaml.BuiltYaml{
+		AppPath:          appPath,
+		YamlPrBranch:     prYaml,
+		YamlTargetBranch: targetYaml,
+	}, nil
+}
+
+// spec is the part of an environment's spec.json that changes its render
+type spec struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Namespace string `json:"namespace"`
+	} `json:"spec"`
+}
+
+// render evaluates an environment in a branch
+func (B *Builder) render(ctx context.Context, branchDir, appPath string) (string, error) {
+	envDir := filepath.Join(branchDir, B.tankaDir, appPath)
+	if _, err := os.Stat(filepath.Join(envDir, mainFile)); os.IsNotExist(err) {
+		return "", nil
+	}
+	start := time.Now()
+
+	environment := spec{}
+	content, err := os.ReadFile(filepath.Join(envDir, specFile))
+	if err == nil {
+		if err := json.Unmarshal(content, &environment); err != nil {
+			return "", fmt.Errorf("parsing %s: %w", filepath.Join(envDir, specFile), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{JPaths: jpaths(branchDir, envDir)})
+	// environments read their own spec from the ext var Tanka sets
+	if content != nil {
+		vm.ExtCode(environmentExtVar, string(content))
+	}
+	out, err := B.runner.InProcess(ctx, "evaluating Tanka environment "+envDir, func() (string, error) {
+		out, err := vm.EvaluateFile(filepath.Join(envDir, mainFile))
+		if err != nil {
+			return "", fmt.Errorf("evaluating Tanka environment %s: %w", envDir, err)
+		}
+		return out, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	var evaluated interface{}
+	if err := json.Unmarshal([]byte(out), &evaluated); err != nil {
+		return "", err
+	}
+	// an inline environment holds its spec itself
+	inline := spec{}
+	if err := json.Unmarshal([]byte(out), &inline); err == nil && inline.Kind == "Environment" {
+		environment = inline
+	}
+
+	documents := []string{}
+	for _, object := range extract(evaluated) {
+		metadata, _ := object["metadata"].(map[string]interface{})
+		if metadata != nil && environment.Spec.Namespace != "" && metadata["namespace"] == nil {
+			metadata["namespace"] = environment.Spec.Namespace
+		}
+		document, err := goyaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		documents = append(documents, string(document))
+	}
+	B.logger.Printf("evaluated Tanka environment %s in %s", envDir, time.Since(start))
+	return strings.Join(documents, "---\n"), nil
+}
+
+// jpaths are the import paths of an environment, as Tanka resolves them: the environment
+// itself, then the project's lib, the environment's vendor and the project's vendor.
+// go-jsonnet gives the last path the highest priority.
+func jpaths(branchDir, envDir string) []string {
+	root := projectRoot(branchDir, envDir)
+	return []string{
+		filepath.Join(root, "vendor"),
+		filepath.Join(envDir, "vendor"),
+		filepath.Join(root, "lib"),
+		envDir,
+	}
+}
+
+// projectRoot finds the Tanka project an environment belongs to, the closest directory
+// above it with a jsonnetfile.json or tkrc.yaml, or the root of the branch if there isn't one
+func projectRoot(branchDir, envDir string) string {
+	branchRoot, err := filepath.Abs(branchDir)
+	if err != nil {
+		return branchDir
+	}
+	dir, err := filepath.Abs(envDir)
+	if err != nil {
+		return branchDir
+	}
+	for {
+		for _, marker := range []string{"jsonnetfile.json", "tkrc.yaml"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if dir == branchRoot || dir == filepath.Dir(dir) {
+			return branchRoot
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// extract finds the Kubernetes objects in the output of an environment as Tanka does:
+// any object with an apiVersion and kind, found by walking nested objects and arrays
+// in key order, with Lists expanded and inline environments replaced by their data
+func extract(value interface{}) []map[string]interface{} {
+	objects := []map[string]interface{}{}
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, item := range typed {
+			objects = append(objects, extract(item)...)
+		}
+	case map[string]interface{}:
+		apiVersion, _ := typed["apiVersion"].(string)
+		kind, _ := typed["kind"].(string)
+		switch {
+		case apiVersion == "tanka.dev/v1alpha1" && kind == "Environment":
+			return extract(typed["data"])
+		case apiVersion != "" && kind != "" && strings.HasSuffix(kind, "List") && typed["items"] != nil:
+			return extract(typed["items"])
+		case apiVersion != "" && kind != "":
+			return append(objects, typed)
+		}
+		keys := []string{}
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			objects = append(objects, extract(typed[key])...)
+		}
+	}
+	return objects
+}
@@ -0,0 +1,122 @@
+package tanka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"github.com/cyclingwithelephants/kubediff/internal/yaml"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func newRunner() *yaml.Runner {
+	return yaml.NewRunner(filesys.MakeFsOnDisk(), yaml.KustomizeLibrary, yaml.KustomizeFlags{}, nil, log.New(io.Discard, "", 0))
+}
+
+func TestBuilder_Build(t *testing.T) {
+	project := map[string]string{
+		"jsonnetfile.json":            "{}",
+		"vendor/k.libsonnet":          "{ configMap(name, data):: { apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: name }, data: data } }",
+		"lib/grafana.libsonnet":       "local k = import 'k.libsonnet';\n{ grafana(replicas):: { config: k.configMap('grafana', { replicas: std.toString(replicas) }) } }",
+		"environments/prod/spec.json": `{"apiVersion": "tanka.dev/v1alpha1", "kind": "Environment", "spec": {"namespace": "monitoring"}}`,
+		"environments/dev/spec.json":  `{"apiVersion": "tanka.dev/v1alpha1", "kind": "Environment", "metadata": {"name": "environments/dev"}, "spec": {"namespace": "dev"}}`,
+		"environments/dev/main.jsonnet": `local environment = std.extVar('tanka.dev/environment');
+{ config: { apiVersion: 'v1', kind: 'ConfigMap', metadata: { name: 'env' }, data: { name: environment.metadata.name } } }`,
+		"environments/staging/main.jsonnet": `{
+  apiVersion: 'tanka.dev/v1alpha1',
+  kind: 'Environment',
+  metadata: { name: 'staging' },
+  spec: { namespace: 'staging' },
+  data: { list: { apiVersion: 'v1', kind: 'List', items: [{ apiVersion: 'v1', kind: 'Service', metadata: { name: 'grafana' } }] } },
+}`,
+	}
+	prDir, targetDir := t.TempDir(), t.TempDir()
+	testutil.WriteFiles(t, prDir, project)
+	testutil.WriteFiles(t, targetDir, project)
+	testutil.WriteFiles(t, prDir, map[string]string{
+		"environments/prod/main.jsonnet": "(import 'grafana.libsonnet').grafana(3)",
+	})
+	testutil.WriteFiles(t, targetDir, map[string]string{
+		"environments/prod/main.jsonnet": "(import 'grafana.libsonnet').grafana(2)",
+	})
+
+	logger := log.New(io.Discard, "", 0)
+	apps, err := NewAppFinder(prDir, targetDir, "environments", logger).GetAllAppPaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"dev", "prod", "staging"}; !reflect.DeepEqual(apps.Sorted(func(a, b string) bool { return a < b }), expected) {
+		t.Fatalf("Expected apps %v, got %v", expected, apps)
+	}
+
+	testCases := []struct {
+		name               string
+		appPath            string
+		expectedPrYaml     string
+		expectedTargetYaml string
+	}{
+		{
+			name:               "Case 1: environment with vendored and project libraries",
+			appPath:            "prod",
+			expectedPrYaml:     "apiVersion: v1\ndata:\n    replicas: \"3\"\nkind: ConfigMap\nmetadata:\n    name: grafana\n    namespace: monitoring\n",
+			expectedTargetYaml: "apiVersion: v1\ndata:\n    replicas: \"2\"\nkind: ConfigMap\nmetadata:\n    name: grafana\n    namespace: monitoring\n",
+		},
+		{
+			name:               "Case 2: inline environment with a List",
+			appPath:            "staging",
+			expectedPrYaml:     "apiVersion: v1\nkind: Service\nmetadata:\n    name: grafana\n    namespace: staging\n",
+			expectedTargetYaml: "apiVersion: v1\nkind: Service\nmetadata:\n    name: grafana\n    namespace: staging\n",
+		},
+		{
+			name:               "Case 3: environment reading its spec",
+			appPath:            "dev",
+			expectedPrYaml:     "apiVersion: v1\ndata:\n    name: environments/dev\nkind: ConfigMap\nmetadata:\n    name: env\n    namespace: dev\n",
+			expectedTargetYaml: "apiVersion: v1\ndata:\n    name: environments/dev\nkind: ConfigMap\nmetadata:\n    name: env\n    namespace: dev\n",
+		},
+		{
+			name:    "Case 4: environment missing from both branches",
+			appPath: "qa",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			builtYaml, err := NewBuilder(prDir, targetDir, "environments", newRunner(), logger).Build(context.Background(), testCase.appPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if builtYaml.YamlPrBranch != testCase.expectedPrYaml {
+				t.Errorf("Expected PR yaml:\n%s\ngot:\n%s", testCase.expectedPrYaml, builtYaml.YamlPrBranch)
+			}
+			if builtYaml.YamlTargetBranch != testCase.expectedTargetYaml {
+				t.Errorf("Expected target yaml:\n%s\ngot:\n%s", testCase.expectedTargetYaml, builtYaml.YamlTargetBranch)
+			}
+		})
+	}
+}
+
+func TestBuilder_Build_Timeout(t *testing.T) {
+	prDir, targetDir := t.TempDir(), t.TempDir()
+	testutil.WriteFiles(t, prDir, map[string]string{
+		"environments/prod/main.jsonnet": "{ sum: std.foldl(function(total, i) total + std.foldl(function(a, b) a + b, std.range(1, 100000), 0), std.range(1, 100000), 0) }",
+	})
+	runner := newRunner()
+	ctx, cancel := yaml.BuildContext(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewBuilder(prDir, targetDir, "environments", runner, log.New(io.Discard, "", 0)).Build(ctx, "prod")
+	var timeoutErr *yaml.TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 100*time.Millisecond {
+		t.Fatalf("Expected the evaluation to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the build to stop at its timeout, took %s", elapsed)
+	}
+	if renders := runner.Stats().Renders; renders != 1 {
+		t.Errorf("Expected the evaluation to be counted as a render, got %d", renders)
+	}
+}
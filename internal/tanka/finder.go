@@ -0,0 +1,66 @@
+package tanka
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+)
+
+// AppFinder discovers Tanka environments in both checkouts of the repository.
+// Each environment is a directory containing a main.jsonnet, identified by its
+// path relative to tankaDir, e.g. prod/observability.
+type AppFinder struct {
+	prDir     string // the directory where the PR branch is checked out
+	targetDir string // the directory where the target branch is checked out
+	tankaDir  string // the directory containing the Tanka environments, e.g. environments
+	logger    *log.Logger
+}
+
+func NewAppFinder(prDir, targetDir, tankaDir string, logger *log.Logger) *AppFinder {
+	return &AppFinder{
+		prDir:     prDir,
+		targetDir: targetDir,
+		tankaDir:  tankaDir,
+		logger:    logger,
+	}
+}
+
+func (F *AppFinder) GetAllAppPaths() (utils.Set, error) {
+	paths := utils.NewSet()
+	for _, branchDir := range []string{F.prDir, F.targetDir} {
+		root := filepath.Join(branchDir, F.tankaDir)
+		if _, err := os.Stat(root); os.IsNotExist(err) {
+			F.logger.Println("tanka directory doesn't exist:", root)
+			continue
+		}
+		err := filepath.WalkDir(root, func(dirPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if entry.Name() == "vendor" || entry.Name() == "lib" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(dirPath, mainFile)); err != nil {
+				return nil
+			}
+			relative, err := filepath.Rel(root, dirPath)
+			if err != nil {
+				return err
+			}
+			F.logger.Println("found Tanka environment:", relative)
+			paths.Add(filepath.ToSlash(relative))
+			// environments don't contain other environments
+			return filepath.SkipDir
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
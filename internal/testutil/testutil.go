@@ -0,0 +1,90 @@
+// Package testutil holds the fixtures shared by the tests of other packages
+package testutil
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// WriteFiles writes files, given relative to root, creating their directories, and returns root
+func WriteFiles(t testing.TB, root string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// Deployment describes a Deployment named web, with only the fields a test sets
+type Deployment struct {
+	Namespace   string
+	Replicas    int
+	Annotations map[string]string // of the pod template
+	Containers  []Container
+}
+
+// Container is a container of a Deployment
+type Container struct {
+	Name      string
+	Image     string
+	Requests  map[string]string
+	Limits    map[string]string
+	ConfigMap string // the ConfigMap its environment comes from
+}
+
+// Yaml renders the Deployment as a single document
+func (D Deployment) Yaml() string {
+	metadata := map[string]interface{}{"name": "web"}
+	if D.Namespace != "" {
+		metadata["namespace"] = D.Namespace
+	}
+	containers := []map[string]interface{}{}
+	for _, container := range D.Containers {
+		object := map[string]interface{}{"name": container.Name}
+		if container.Image != "" {
+			object["image"] = container.Image
+		}
+		resources := map[string]interface{}{}
+		if len(container.Requests) > 0 {
+			resources["requests"] = container.Requests
+		}
+		if len(container.Limits) > 0 {
+			resources["limits"] = container.Limits
+		}
+		if len(resources) > 0 {
+			object["resources"] = resources
+		}
+		if container.ConfigMap != "" {
+			object["envFrom"] = []map[string]interface{}{{"configMapRef": map[string]string{"name": container.ConfigMap}}}
+		}
+		containers = append(containers, object)
+	}
+	template := map[string]interface{}{"spec": map[string]interface{}{"containers": containers}}
+	if len(D.Annotations) > 0 {
+		template["metadata"] = map[string]interface{}{"annotations": D.Annotations}
+	}
+	deployment := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   metadata,
+		"spec":       map[string]interface{}{"replicas": D.Replicas, "template": template},
+	}
+
+	var out bytes.Buffer
+	encoder := goyaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(deployment); err != nil {
+		panic(err)
+	}
+	return out.String()
+}
@@ -38,3 +38,24 @@ func (C Chunker) Chunk(
 	// Flush the last chunk
 	return append(chunks, newChunk)
 }
+
+// Pack joins consecutive pieces with a newline for as long as they fit in a chunk,
+// leaving a piece that is too big on its own in a chunk of its own
+func (C Chunker) Pack(pieces []string) []string {
+	var chunks []string
+	packed := ""
+	for _, piece := range pieces {
+		if packed != "" && utf8.RuneCountInString(packed)+1+utf8.RuneCountInString(piece) > C.MaxChunkChars {
+			chunks = append(chunks, packed)
+			packed = ""
+		}
+		if packed != "" {
+			packed += "\n"
+		}
+		packed += piece
+	}
+	if packed != "" {
+		chunks = append(chunks, packed)
+	}
+	return chunks
+}
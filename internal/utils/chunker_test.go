@@ -87,3 +87,53 @@ func TestChunker_Chunk(t *testing.T) {
 		})
 	}
 }
+
+func TestChunker_Pack(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          []string
+		maxChunkSize   int
+		expectedOutput []string
+	}{
+		{
+			name:           "Case 1: Everything fits",
+			input:          []string{"one", "two", "three"},
+			maxChunkSize:   20,
+			expectedOutput: []string{"one\ntwo\nthree"},
+		},
+		{
+			name:           "Case 2: Consecutive pieces that fit",
+			input:          []string{"one", "two", "three"},
+			maxChunkSize:   7,
+			expectedOutput: []string{"one\ntwo", "three"},
+		},
+		{
+			name:           "Case 3: A piece too big on its own",
+			input:          []string{"one", "eleven-long", "two"},
+			maxChunkSize:   5,
+			expectedOutput: []string{"one", "eleven-long", "two"},
+		},
+		{
+			name:           "Case 4: Nothing",
+			input:          []string{},
+			maxChunkSize:   5,
+			expectedOutput: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			chunker := NewChunker(testCase.maxChunkSize)
+			result := chunker.Pack(testCase.input)
+			if len(result) != len(testCase.expectedOutput) {
+				t.Errorf("Expected %v chunks, got %v: %q", len(testCase.expectedOutput), len(result), result)
+				return
+			}
+			for i, chunk := range result {
+				if chunk != testCase.expectedOutput[i] {
+					t.Errorf("Expected chunk %v to be %q, got %q", i, testCase.expectedOutput[i], chunk)
+				}
+			}
+		})
+	}
+}
@@ -3,7 +3,11 @@ package utils
 import (
 	"log"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -45,6 +49,14 @@ func AsInt(val string) int {
 	return result
 }
 
+func AsDuration(val string) time.Duration {
+	result, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("%s must be a duration, e.g. 10m: %s", val, err)
+	}
+	return result
+}
+
 func AsBool(val string) bool {
 	result, err := strconv.ParseBool(val)
 	if err != nil {
@@ -70,3 +82,75 @@ func (s1 Set) Union(s2 Set) Set {
 func (s1 *Set) Add(value string) {
 	(*s1)[value] = struct{}{}
 }
+
+// AsList splits a comma separated value into its trimmed, non-empty items
+func AsList(val string) []string {
+	result := []string{}
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Sorted returns the members of the set ordered by less,
+// since iterating over the set directly gives a different order on every run
+func (s1 Set) Sorted(less func(a, b string) bool) []string {
+	result := make([]string, 0, len(s1))
+	for key := range s1 {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return less(result[i], result[j])
+	})
+	return result
+}
+
+// GlobMatch matches a slash separated path against a glob pattern, where
+// `*` and `?` don't match `/` but `**` matches any number of path elements
+func GlobMatch(pattern, name string) bool {
+	expression := strings.Builder{}
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch char := pattern[i]; char {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expression.WriteString(".*")
+				i++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.MustCompile(expression.String()).MatchString(name)
+}
+
+// GlobMatchAny reports whether name matches any of the patterns
+func GlobMatchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if GlobMatch(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// AsMap parses a comma separated list of key=value pairs
+func AsMap(val string) map[string]string {
+	result := map[string]string{}
+	for _, item := range AsList(val) {
+		key, value, found := strings.Cut(item, "=")
+		if !found {
+			log.Fatalf("%s must be a comma separated list of key=value pairs", val)
+		}
+		result[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return result
+}
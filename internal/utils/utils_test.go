@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		pattern        string
+		input          string
+		expectedOutput bool
+	}{
+		{
+			name:           "Case 1: Single star stays within a path element",
+			pattern:        "prod/*",
+			input:          "prod/apps/web",
+			expectedOutput: false,
+		},
+		{
+			name:           "Case 2: Double star matches any depth",
+			pattern:        "prod/**",
+			input:          "prod/apps/web",
+			expectedOutput: true,
+		},
+		{
+			name:           "Case 3: Question mark matches one character",
+			pattern:        "env-?/apps/*",
+			input:          "env-a/apps/web",
+			expectedOutput: true,
+		},
+		{
+			name:           "Case 4: Regexp characters are literal",
+			pattern:        "prod/app.v1",
+			input:          "prod/appsv1",
+			expectedOutput: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := GlobMatch(testCase.pattern, testCase.input)
+			if result != testCase.expectedOutput {
+				t.Errorf("Expected %v, got %v", testCase.expectedOutput, result)
+			}
+		})
+	}
+}
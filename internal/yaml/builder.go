@@ -1,16 +1,15 @@
 package yaml
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"path"
 	"path/filepath"
 )
 
+// BuiltYaml is an app rendered in both branches. An app missing from a branch renders
+// to nothing there, so that it shows as a full addition or deletion.
 type BuiltYaml struct {
 	AppPath          string
 	YamlPrBranch     string
@@ -22,6 +21,8 @@ type Builder struct {
 	targetDir             string
 	envsDir               string
 	renderedYamlWriteRoot string
+	commands              []Command
+	runner                *Runner
 	logger                *log.Logger
 }
 
@@ -30,6 +31,8 @@ func NewBuilder(
 	targetDir string,
 	envsDir string,
 	renderedYamlWriteRoot string,
+	commands []Command,
+	runner *Runner,
 	logger *log.Logger,
 ) *Builder {
 	return &Builder{
@@ -37,33 +40,14 @@ func NewBuilder(
 		targetDir:             targetDir,
 		envsDir:               envsDir,
 		renderedYamlWriteRoot: renderedYamlWriteRoot,
+		commands:              commands,
+		runner:                runner,
 		logger:                logger,
 	}
 }
 
-func (B *Builder) kustomizeBuild(directory string) (string, error) {
-	if _, err := os.Stat(directory); os.IsNotExist(err) {
-		return "", fmt.Errorf("directory %s does not exist", directory)
-	}
-	if _, err := os.Stat(path.Join(directory, "kustomization.yaml")); errors.Is(err, os.ErrNotExist) {
-		return "", fmt.Errorf("directory %s does not contain kustomization.yaml", directory)
-	}
-	B.logger.Println("running kustomize build on directory:", directory)
-	cmd := exec.Command("kustomize", "build", "--enable-helm", directory)
-	var out bytes.Buffer
-	var outErr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &outErr
-	err := cmd.Run()
-	B.logger.Println(outErr.String())
-	if err != nil {
-		return "", fmt.Errorf("kustomize build failed: %s", outErr.String())
-	}
-	return out.String(), nil
-}
-
-func (B *Builder) Build(appPath string) (BuiltYaml, error) {
-	renderedYamls, err := B.buildForEach(appPath)
+func (B *Builder) Build(ctx context.Context, appPath string) (BuiltYaml, error) {
+	renderedYamls, err := B.buildForEach(ctx, appPath)
 	if err != nil {
 		return BuiltYaml{}, err
 	}
@@ -77,14 +61,14 @@ func (B *Builder) Build(appPath string) (BuiltYaml, error) {
 	}, nil
 }
 
-func (B *Builder) buildForEach(appPath string) ([]string, error) {
+func (B *Builder) buildForEach(ctx context.Context, appPath string) ([]string, error) {
 	branchPaths := []string{
 		B.prDir,
 		B.targetDir,
 	}
 	renderedYamls := []string{}
 	for _, branchPath := range branchPaths {
-		renderedYaml, err := B.build(branchPath, appPath)
+		renderedYaml, err := B.build(ctx, branchPath, appPath)
 		if err != nil {
 			return []string{}, err
 		}
@@ -93,7 +77,7 @@ func (B *Builder) buildForEach(appPath string) ([]string, error) {
 	return renderedYamls, nil
 }
 
-func (B *Builder) build(branchPath, appPath string) (string, error) {
+func (B *Builder) build(ctx context.Context, branchPath, appPath string) (string, error) {
 	fullAppPath := filepath.Join(
 		branchPath,
 		B.envsDir,
@@ -105,7 +89,12 @@ func (B *Builder) build(branchPath, appPath string) (string, error) {
 		return "", nil
 	}
 
-	renderedYaml, err := B.kustomizeBuild(fullAppPath)
+	// apps with a build command of their own aren't built by kustomize
+	if command, ok := MatchCommand(B.commands, appPath); ok {
+		return B.runner.Command(ctx, command, fullAppPath, branchPath, appPath)
+	}
+
+	renderedYaml, err := B.runner.Kustomize(ctx, appPath, fullAppPath)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,333 @@
+package yaml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Cache stores rendered yaml on disk keyed by a hash of everything the render depends on,
+// so that a directory persisted between CI runs serves renders whose inputs haven't changed
+type Cache struct {
+	dir    string
+	logger *log.Logger
+}
+
+func NewCache(dir string, logger *log.Logger) *Cache {
+	return &Cache{
+		dir:    dir,
+		logger: logger,
+	}
+}
+
+// Get returns the render stored under a key, if there is one
+func (C *Cache) Get(key string) (string, bool) {
+	content, err := os.ReadFile(C.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			C.logger.Println("error reading render cache:", err)
+		}
+		return "", false
+	}
+	return string(content), true
+}
+
+// Put stores a render under a key. It is written to a temporary file first so that
+// a run that is interrupted, or another running alongside, never reads half a render
+func (C *Cache) Put(key, rendered string) error {
+	cachePath := C.path(key)
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(cachePath), key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(rendered); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), cachePath)
+}
+
+// path spreads entries over subdirectories named after the first byte of their key
+func (C *Cache) path(key string) string {
+	return filepath.Join(C.dir, key[:2], key+".yaml")
+}
+
+// inputHash hashes the inputs of a render. Paths are hashed relative to the directory
+// that refers to them, so identical trees in both branches, or in different checkouts, share a key
+type inputHash struct {
+	hash.Hash
+	fSys filesys.FileSystem
+}
+
+func newInputHash(fSys filesys.FileSystem) inputHash {
+	return inputHash{sha256.New(), fSys}
+}
+
+func (H inputHash) text(value string) {
+	H.Write([]byte(value))
+	H.Write([]byte{0})
+}
+
+func (H inputHash) key() string {
+	return hex.EncodeToString(H.Sum(nil))
+}
+
+// tree hashes a file, or every file under a directory along with its name
+func (H inputHash) tree(root string) error {
+	return H.fSys.Walk(root, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		relative, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		content, err := H.fSys.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		H.text(relative)
+		H.text(string(content))
+		return nil
+	})
+}
+
+// kustomization hashes a kustomization and every local file it refers to, following
+// other kustomization roots it refers to. Any string of the kustomization naming an
+// existing path is treated as a reference, including the path of a `key=path` generator entry.
+// Charts downloaded from a repository are identified by the name, version and repository
+// already in the kustomization, so only charts without a repository are read from disk.
+// Remote charts and resources that aren't pinned to a version fail with an unpinnedError.
+func (H inputHash) kustomization(directory string, visited map[string]bool) error {
+	confirmedDir, _, err := H.fSys.CleanedAbs(directory)
+	if err != nil {
+		return err
+	}
+	absDir := confirmedDir.String()
+	if visited[absDir] {
+		return nil
+	}
+	visited[absDir] = true
+
+	for _, name := range KustomizationNames {
+		kustomizationPath := filepath.Join(absDir, name)
+		if !H.fSys.Exists(kustomizationPath) {
+			continue
+		}
+		content, err := H.fSys.ReadFile(kustomizationPath)
+		if err != nil {
+			return err
+		}
+		H.text(name)
+		H.text(string(content))
+
+		kustomization := map[string]interface{}{}
+		if err := goyaml.Unmarshal(content, &kustomization); err != nil {
+			return err
+		}
+		chartHome := "charts"
+		if globals, ok := kustomization["helmGlobals"].(map[string]interface{}); ok {
+			if home, ok := globals["chartHome"].(string); ok && home != "" {
+				chartHome = home
+			}
+		}
+		for _, key := range sortedKeys(kustomization) {
+			value := kustomization[key]
+			if key == "resources" || key == "components" || key == "bases" {
+				references, _ := value.([]interface{})
+				for _, reference := range references {
+					reference, _ := reference.(string)
+					if !H.fSys.Exists(filepath.Join(absDir, reference)) && IsRemoteReference(reference) && !pinnedReference(reference) {
+						return &unpinnedError{input: reference}
+					}
+				}
+			}
+			if key != "helmCharts" {
+				if err := H.references(absDir, value, visited); err != nil {
+					return err
+				}
+				continue
+			}
+			charts, _ := value.([]interface{})
+			for _, chart := range charts {
+				chart, _ := chart.(map[string]interface{})
+				if repo, _ := chart["repo"].(string); repo != "" {
+					if version, _ := chart["version"].(string); !pinnedVersion.MatchString(version) {
+						return &unpinnedError{input: fmt.Sprintf("chart %v from %s", chart["name"], repo)}
+					}
+					continue
+				}
+				if name, _ := chart["name"].(string); name != "" {
+					if err := H.reference(absDir, filepath.Join(chartHome, name), visited); err != nil {
+						return err
+					}
+				}
+				if valuesFile, _ := chart["valuesFile"].(string); valuesFile != "" {
+					if err := H.reference(absDir, valuesFile, visited); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// references hashes every path named by a string within a kustomization value
+func (H inputHash) references(directory string, value interface{}, visited map[string]bool) error {
+	switch typed := value.(type) {
+	case string:
+		// inline patches and the like can't be paths
+		if strings.Contains(typed, "\n") {
+			return nil
+		}
+		if err := H.reference(directory, typed, visited); err != nil {
+			return err
+		}
+		if index := strings.LastIndex(typed, "="); index >= 0 {
+			return H.reference(directory, typed[index+1:], visited)
+		}
+	case []interface{}:
+		for _, item := range typed {
+			if err := H.references(directory, item, visited); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(typed) {
+			if err := H.references(directory, typed[key], visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reference hashes the path a kustomization refers to, if it exists
+func (H inputHash) reference(directory, reference string, visited map[string]bool) error {
+	if reference == "" {
+		return nil
+	}
+	referencePath := reference
+	if !filepath.IsAbs(referencePath) {
+		referencePath = filepath.Join(directory, reference)
+	}
+	if !H.fSys.Exists(referencePath) {
+		return nil
+	}
+	H.text(reference)
+	if H.fSys.IsDir(referencePath) && hasKustomization(H.fSys, referencePath) {
+		return H.kustomization(referencePath, visited)
+	}
+	return H.tree(referencePath)
+}
+
+// unpinnedError is returned by the key of a render whose remote inputs can change without anything
+// in the repository changing, such as a chart without a version or a remote base on a branch,
+// so that it isn't served from the cache long after its inputs have changed
+type unpinnedError struct {
+	input string
+}
+
+func (U *unpinnedError) Error() string {
+	return "unpinned remote input " + U.input
+}
+
+// pinnedVersion matches an exact semantic version, as opposed to a range or a branch
+var pinnedVersion = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.+-]+)?$`)
+
+// commitSha matches the full hash of a git commit
+var commitSha = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// pinnedReference reports whether a remote resource is pinned to a commit or a version, either
+// by the ref of a git repository, e.g. github.com/org/repo//deploy?ref=v1.2.0, or in the path
+// of a URL, e.g. https://github.com/org/repo/releases/download/v1.2.0/install.yaml
+func pinnedReference(reference string) bool {
+	pinned := func(value string) bool {
+		return commitSha.MatchString(value) || pinnedVersion.MatchString(value)
+	}
+	location, query, _ := strings.Cut(reference, "?")
+	if values, err := url.ParseQuery(query); err == nil {
+		for _, key := range []string{"ref", "version"} {
+			if value := values.Get(key); value != "" {
+				return pinned(value)
+			}
+		}
+	}
+	for _, segment := range strings.Split(location, "/") {
+		if pinned(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitHosts are the hosts kustomize fetches repositories from without a scheme, e.g. github.com/org/repo//path?ref=v1
+var gitHosts = map[string]bool{
+	"github.com":        true,
+	"gitlab.com":        true,
+	"bitbucket.org":     true,
+	"dev.azure.com":     true,
+	"ssh.dev.azure.com": true,
+}
+
+// IsRemoteReference reports whether kustomize would fetch a reference that isn't a local path
+// from the network: a URL, a git@, git:: or gh: repository, or a repository on a known git host
+// or ending in .git. Anything else is a local path, even when it is missing.
+func IsRemoteReference(reference string) bool {
+	if strings.Contains(reference, "://") {
+		return true
+	}
+	for _, prefix := range []string{"git@", "git::", "gh:"} {
+		if strings.HasPrefix(reference, prefix) {
+			return true
+		}
+	}
+	host, repoPath, found := strings.Cut(reference, "/")
+	if !found {
+		return false
+	}
+	if gitHosts[host] {
+		return true
+	}
+	// other hosts are only told apart from local paths by the .git of the repository
+	repo, _, _ := strings.Cut(repoPath, "//")
+	repo, _, _ = strings.Cut(repo, "?")
+	return strings.Contains(host, ".") && host != "." && host != ".." && strings.HasSuffix(repo, ".git")
+}
+
+// sortedKeys orders the keys of an object, so that it is always hashed in the same order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := []string{}
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
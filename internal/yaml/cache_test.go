@@ -0,0 +1,213 @@
+package yaml
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+)
+
+func kustomizationKey(t *testing.T, directory string) string {
+	hash := newInputHash(filesys.MakeFsOnDisk())
+	if err := hash.kustomization(directory, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	return hash.key()
+}
+
+func TestInputHash_Kustomization(t *testing.T) {
+	base := map[string]string{
+		"base/kustomization.yaml":      "resources: [deployment.yaml]\n",
+		"base/deployment.yaml":         "kind: Deployment\n",
+		"envs/prod/kustomization.yaml": "resources: [../../base]\nconfigMapGenerator:\n- name: config\n  files: [app.conf=config/app.conf]\nhelmCharts:\n- name: redis\n  repo: https://charts.example.com\n  version: 1.0.0\n",
+		"envs/prod/config/app.conf":    "debug = false\n",
+	}
+	with := func(changes map[string]string) map[string]string {
+		files := map[string]string{}
+		for name, content := range base {
+			files[name] = content
+		}
+		for name, content := range changes {
+			files[name] = content
+		}
+		return files
+	}
+
+	testCases := []struct {
+		name      string
+		files     map[string]string
+		sameAsKey bool
+	}{
+		{
+			name:      "Case 1: identical tree in another directory",
+			files:     base,
+			sameAsKey: true,
+		},
+		{
+			name:      "Case 2: downloaded chart is ignored",
+			files:     with(map[string]string{"envs/prod/charts/redis/Chart.yaml": "name: redis\n"}),
+			sameAsKey: true,
+		},
+		{
+			name:      "Case 3: unreferenced file is ignored",
+			files:     with(map[string]string{"envs/prod/README.md": "# prod\n"}),
+			sameAsKey: true,
+		},
+		{
+			name:  "Case 4: changed base resource",
+			files: with(map[string]string{"base/deployment.yaml": "kind: StatefulSet\n"}),
+		},
+		{
+			name:  "Case 5: changed generator file",
+			files: with(map[string]string{"envs/prod/config/app.conf": "debug = true\n"}),
+		},
+		{
+			name:  "Case 6: changed chart version",
+			files: with(map[string]string{"envs/prod/kustomization.yaml": "resources: [../../base]\nconfigMapGenerator:\n- name: config\n  files: [app.conf=config/app.conf]\nhelmCharts:\n- name: redis\n  repo: https://charts.example.com\n  version: 1.0.1\n"}),
+		},
+	}
+	expected := kustomizationKey(t, filepath.Join(testutil.WriteFiles(t, t.TempDir(), base), "envs", "prod"))
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			actual := kustomizationKey(t, filepath.Join(testutil.WriteFiles(t, t.TempDir(), testCase.files), "envs", "prod"))
+			if (actual == expected) != testCase.sameAsKey {
+				t.Errorf("Expected same key to be %v, got keys %s and %s", testCase.sameAsKey, expected, actual)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := NewCache(t.TempDir(), log.New(io.Discard, "", 0))
+	key := kustomizationKey(t, t.TempDir())
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("Expected an empty cache to miss")
+	}
+	if err := cache.Put(key, "kind: Deployment\n"); err != nil {
+		t.Fatal(err)
+	}
+	if rendered, ok := cache.Get(key); !ok || rendered != "kind: Deployment\n" {
+		t.Errorf("Expected the stored render, got %q", rendered)
+	}
+}
+
+func TestRunner_Kustomize_CacheKeyedByHelm(t *testing.T) {
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"app/kustomization.yaml": "resources: [configmap.yaml]\n",
+		"app/configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n",
+	})
+	cache := NewCache(t.TempDir(), log.New(io.Discard, "", 0))
+
+	testCases := []struct {
+		name        string
+		helm        string
+		flags       []string
+		expectedHit bool
+	}{
+		{name: "Case 1: first render", helm: "v3.12.0", flags: DefaultKustomizeFlags},
+		{name: "Case 2: same helm", helm: "v3.12.0", flags: DefaultKustomizeFlags, expectedHit: true},
+		{name: "Case 3: another helm", helm: "v3.13.0", flags: DefaultKustomizeFlags},
+		{name: "Case 4: helm isn't enabled", helm: "v3.14.0", flags: []string{}},
+		{name: "Case 5: helm isn't enabled, with another helm", helm: "v3.15.0", flags: []string{}, expectedHit: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runner := NewRunner(filesys.MakeFsOnDisk(), KustomizeLibrary, KustomizeFlags{Global: testCase.flags}, cache, log.New(io.Discard, "", 0))
+			runner.versions["helm"] = testCase.helm
+			if _, err := runner.Kustomize(context.Background(), "app", filepath.Join(root, "app")); err != nil {
+				t.Fatal(err)
+			}
+			if hit := runner.Stats().CacheHits == 1; hit != testCase.expectedHit {
+				t.Errorf("Expected cache hit to be %v, got stats %+v", testCase.expectedHit, runner.Stats())
+			}
+		})
+	}
+}
+
+func TestInputHash_Kustomization_Unpinned(t *testing.T) {
+	testCases := []struct {
+		name             string
+		kustomization    string
+		expectedUnpinned bool
+	}{
+		{
+			name:          "Case 1: chart with a version",
+			kustomization: "helmCharts:\n- name: redis\n  repo: https://charts.example.com\n  version: 1.0.0\n",
+		},
+		{
+			name:             "Case 2: chart without a version",
+			kustomization:    "helmCharts:\n- name: redis\n  repo: https://charts.example.com\n",
+			expectedUnpinned: true,
+		},
+		{
+			name:             "Case 3: chart with a version range",
+			kustomization:    "helmCharts:\n- name: redis\n  repo: https://charts.example.com\n  version: ^1.0.0\n",
+			expectedUnpinned: true,
+		},
+		{
+			name:          "Case 4: remote base on a tag",
+			kustomization: "resources:\n- github.com/org/repo//deploy?ref=v1.2.0\n",
+		},
+		{
+			name:          "Case 5: remote base on a commit",
+			kustomization: "resources:\n- https://github.com/org/repo//deploy?ref=0123456789abcdef0123456789abcdef01234567\n",
+		},
+		{
+			name:             "Case 6: remote base on a branch",
+			kustomization:    "resources:\n- github.com/org/repo//deploy?ref=main\n",
+			expectedUnpinned: true,
+		},
+		{
+			name:             "Case 7: remote base without a ref",
+			kustomization:    "components:\n- git@github.com:org/repo.git//component\n",
+			expectedUnpinned: true,
+		},
+		{
+			name:          "Case 8: release manifest of a version",
+			kustomization: "resources:\n- https://github.com/org/repo/releases/download/v1.2.0/install.yaml\n",
+		},
+		{
+			name:             "Case 9: latest release manifest",
+			kustomization:    "resources:\n- https://github.com/org/repo/releases/latest/download/install.yaml\n",
+			expectedUnpinned: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			root := testutil.WriteFiles(t, t.TempDir(), map[string]string{"kustomization.yaml": testCase.kustomization})
+			err := newInputHash(filesys.MakeFsOnDisk()).kustomization(root, map[string]bool{})
+			var unpinnedErr *unpinnedError
+			if errors.As(err, &unpinnedErr) != testCase.expectedUnpinned {
+				t.Errorf("Expected unpinned to be %v, got error %v", testCase.expectedUnpinned, err)
+			}
+		})
+	}
+}
+
+func TestPinnedChart(t *testing.T) {
+	testCases := []struct {
+		name             string
+		args             []string
+		expectedUnpinned bool
+	}{
+		{name: "Case 1: local chart", args: []string{"app", "charts/app"}},
+		{name: "Case 2: repository chart with a version", args: []string{"app", "redis", "--repo", "https://charts.example.com", "--version", "1.0.0"}},
+		{name: "Case 3: repository chart without a version", args: []string{"app", "redis", "--repo=https://charts.example.com"}, expectedUnpinned: true},
+		{name: "Case 4: OCI chart with a version", args: []string{"app", "oci://registry.example.com/charts/redis", "--version=1.0.0"}},
+		{name: "Case 5: OCI chart with a version range", args: []string{"app", "oci://registry.example.com/charts/redis", "--version", "1.x"}, expectedUnpinned: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := pinnedChart(testCase.args)
+			if (err != nil) != testCase.expectedUnpinned {
+				t.Errorf("Expected unpinned to be %v, got error %v", testCase.expectedUnpinned, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package yaml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// DefaultCommandTimeout bounds a build command that doesn't set its own timeout
+const DefaultCommandTimeout = 5 * time.Minute
+
+// passedEnvironment are the variables of kubediff's environment every build command is given,
+// so that credentials such as GITHUB_TOKEN are only passed to commands that ask for them
+var passedEnvironment = []string{"PATH", "HOME", "TMPDIR", "LANG", "LC_ALL"}
+
+// maxCommandStderr bounds how much of a failed command's stderr is kept, from the end
+const maxCommandStderr = 4096
+
+// Command renders the apps matching a path pattern with a program other than kustomize,
+// such as jsonnet, cdk8s or a script, whose stdout is the rendered multi-document yaml
+type Command struct {
+	Apps string `yaml:"apps"` // a glob of app paths, e.g. prod/jsonnet/**
+	// run with sh -c in the app directory, after {{.APP_DIR}}, {{.BRANCH_ROOT}} and {{.APP_PATH}}
+	// are replaced, which are also set as environment variables of the same names
+	Command string   `yaml:"command"`
+	Timeout string   `yaml:"timeout"` // e.g. 30s, DefaultCommandTimeout when empty
+	Env     []string `yaml:"env"`     // variables passed from kubediff's environment
+
+	timeout  time.Duration
+	template *template.Template
+}
+
+// LoadCommands reads a list of build commands from a yaml file
+func LoadCommands(path string) ([]Command, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	commands := []Command{}
+	if err := goyaml.Unmarshal(content, &commands); err != nil {
+		return nil, fmt.Errorf("parsing build commands %s: %w", path, err)
+	}
+	for i := range commands {
+		command := &commands[i]
+		if command.Apps == "" || command.Command == "" {
+			return nil, fmt.Errorf("build command %d of %s needs both apps and command", i+1, path)
+		}
+		command.timeout = DefaultCommandTimeout
+		if command.Timeout != "" {
+			command.timeout, err = time.ParseDuration(command.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("build command for %s: invalid timeout: %w", command.Apps, err)
+			}
+		}
+		command.template, err = template.New(command.Apps).Option("missingkey=error").Parse(command.Command)
+		if err != nil {
+			return nil, fmt.Errorf("build command for %s: %w", command.Apps, err)
+		}
+	}
+	return commands, nil
+}
+
+// MatchCommand finds the first command whose pattern matches an app path
+func MatchCommand(commands []Command, appPath string) (Command, bool) {
+	for _, command := range commands {
+		if utils.GlobMatch(command.Apps, appPath) {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// Command renders an app by running a build command in its directory, with a sanitised
+// environment, failing with the end of its stderr if it fails or runs past its timeout
+func (R *Runner) Command(ctx context.Context, command Command, appDir, branchRoot, appPath string) (string, error) {
+	start := time.Now()
+	defer func() {
+		R.stats.Renders++
+		R.stats.Duration += time.Since(start)
+	}()
+
+	absAppDir, err := filepath.Abs(appDir)
+	if err != nil {
+		return "", err
+	}
+	absBranchRoot, err := filepath.Abs(branchRoot)
+	if err != nil {
+		return "", err
+	}
+	variables := map[string]string{
+		"APP_DIR":     absAppDir,
+		"BRANCH_ROOT": absBranchRoot,
+		"APP_PATH":    appPath,
+	}
+	var script bytes.Buffer
+	if err := command.template.Execute(&script, variables); err != nil {
+		return "", fmt.Errorf("build command for %s: %w", appPath, err)
+	}
+
+	environment := []string{}
+	for _, name := range append(append([]string{}, passedEnvironment...), command.Env...) {
+		if value, ok := os.LookupEnv(name); ok {
+			environment = append(environment, name+"="+value)
+		}
+	}
+	for name, value := range variables {
+		environment = append(environment, name+"="+value)
+	}
+
+	// the command's own timeout applies within that of the whole app
+	commandCtx, cancel := context.WithTimeout(ctx, command.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(commandCtx, "sh", "-c", script.String())
+	cmd.Dir = absAppDir
+	cmd.Env = environment
+
+	R.logger.Printf("running build command for %s: %s", appDir, script.String())
+	out, stderr, err := R.execute(commandCtx, cmd)
+	if err != nil {
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			// the command ran past its own timeout, unless the app's ran out first
+			if ctx.Err() == nil {
+				timeoutErr.Timeout = command.timeout
+			}
+			return "", timeoutErr
+		}
+		if errors.Is(err, context.Canceled) {
+			return "", err
+		}
+		if len(stderr) > maxCommandStderr {
+			stderr = "..." + stderr[len(stderr)-maxCommandStderr:]
+		}
+		return "", &CommandError{AppDir: appDir, Err: err, Stderr: strings.TrimSpace(stderr)}
+	}
+	return out, nil
+}
+
+// CommandError is a build command that failed, with the end of what it wrote to stderr
+type CommandError struct {
+	AppDir string
+	Err    error
+	Stderr string
+}
+
+func (C *CommandError) Error() string {
+	return fmt.Sprintf("build command for %s failed: %v\n%s", C.AppDir, C.Err, C.Stderr)
+}
+
+func (C *CommandError) Unwrap() error {
+	return C.Err
+}
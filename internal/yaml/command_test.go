@@ -0,0 +1,80 @@
+package yaml
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestRunner_Command(t *testing.T) {
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"envs/prod/jsonnet/main.yaml": "kind: ConfigMap\n",
+	})
+	t.Setenv("GITHUB_TOKEN", "secret")
+	t.Setenv("REGISTRY", "registry.example.com")
+
+	testCases := []struct {
+		name        string
+		commands    string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "Case 1: stdout is the render",
+			commands: "- apps: prod/**\n  command: cat {{.APP_DIR}}/main.yaml\n",
+			expected: "kind: ConfigMap\n",
+		},
+		{
+			name:     "Case 2: only listed variables are passed",
+			commands: "- apps: prod/*\n  command: echo \"$APP_PATH ${GITHUB_TOKEN:-none} $REGISTRY\"\n  env: [REGISTRY]\n",
+			expected: "prod/jsonnet none registry.example.com\n",
+		},
+		{
+			name:        "Case 3: stderr is reported",
+			commands:    "- apps: prod/*\n  command: echo broken >&2; exit 3\n",
+			expectedErr: "exit status 3\nbroken",
+		},
+		{
+			name:        "Case 4: timeout",
+			commands:    "- apps: prod/*\n  command: sleep 5; echo done\n  timeout: 100ms\n",
+			expectedErr: "timed out after 100ms",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			commandsPath := filepath.Join(t.TempDir(), "commands.yaml")
+			if err := os.WriteFile(commandsPath, []byte(testCase.commands), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			commands, err := LoadCommands(commandsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			command, ok := MatchCommand(commands, "prod/jsonnet")
+			if !ok {
+				t.Fatal("Expected a command to match")
+			}
+			runner := NewRunner(filesys.MakeFsOnDisk(), KustomizeExec, KustomizeFlags{}, nil, log.New(io.Discard, "", 0))
+			actual, err := runner.Command(context.Background(), command, filepath.Join(root, "envs", "prod", "jsonnet"), root, "prod/jsonnet")
+			if testCase.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.expectedErr) {
+					t.Fatalf("Expected error containing %q, got %v", testCase.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("Expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+)
+
+// FailedRender is an app that couldn't be rendered because a build timed out, a build command
+// failed or something it needs isn't available
+type FailedRender struct {
+	AppPath string
+	Err     error
+}
+
+// UnavailableError is returned by a build that needs something that isn't available locally,
+// such as a chart, and so can't render the app at all rather than render it without it
+type UnavailableError struct {
+	Reason string
+}
+
+func (U *UnavailableError) Error() string {
+	return U.Reason
+}
+
+// IsRenderFailure reports whether a build error only fails the render of its own app,
+// which is reported on the PR, rather than the whole run
+func IsRenderFailure(err error) bool {
+	var timeoutErr *TimeoutError
+	var commandErr *CommandError
+	var unavailableErr *UnavailableError
+	return errors.As(err, &timeoutErr) || errors.As(err, &commandErr) || errors.As(err, &unavailableErr)
+}
+
+// FailedRendersSection lists the apps that couldn't be rendered, with the end of what their builds wrote to stderr
+func FailedRendersSection(failed []FailedRender) report.Section {
+	var body strings.Builder
+	for _, render := range failed {
+		var timeoutErr *TimeoutError
+		var commandErr *CommandError
+		var unavailableErr *UnavailableError
+		stderr := ""
+		switch {
+		case errors.As(render.Err, &timeoutErr):
+			body.WriteString(fmt.Sprintf("**%s**: %s timed out after %s\n", render.AppPath, report.Code(timeoutErr.Command), timeoutErr.Timeout))
+			stderr = timeoutErr.Stderr
+		case errors.As(render.Err, &commandErr):
+			body.WriteString(fmt.Sprintf("**%s**: the build command failed with %s\n", render.AppPath, report.Code(commandErr.Err.Error())))
+			stderr = commandErr.Stderr
+		case errors.As(render.Err, &unavailableErr):
+			body.WriteString(fmt.Sprintf("**%s**: %s\n", render.AppPath, unavailableErr.Reason))
+		default:
+			body.WriteString(fmt.Sprintf("**%s**: %s\n", render.AppPath, report.Code(render.Err.Error())))
+		}
+		stderr = strings.TrimSpace(stderr)
+		if len(stderr) > maxCommandStderr {
+			stderr = "..." + stderr[len(stderr)-maxCommandStderr:]
+		}
+		if stderr != "" {
+			body.WriteString("```\n" + strings.ReplaceAll(stderr, "```", "'''") + "\n```\n")
+		}
+		body.WriteString("\n")
+	}
+	return report.Section{
+		Title: fmt.Sprintf("Failed renders: %d apps", len(failed)),
+		Body:  body.String(),
+	}
+}
@@ -0,0 +1,72 @@
+package yaml
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestFailedRendersSection(t *testing.T) {
+	// kustomize on PATH hangs, like a chart pull from an unreachable repository
+	binDir := testutil.WriteFiles(t, t.TempDir(), map[string]string{"kustomize": "#!/bin/sh\necho pulling chart >&2\nexec sleep 30\n"})
+	if err := os.Chmod(filepath.Join(binDir, "kustomize"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"envs/prod/jsonnet/main.jsonnet":    "{\n",
+		"envs/prod/slow/kustomization.yaml": "resources: []\n",
+	})
+	commandsPath := filepath.Join(t.TempDir(), "commands.yaml")
+	if err := os.WriteFile(commandsPath, []byte("- apps: prod/jsonnet\n  command: echo main.jsonnet:2 unexpected end of file >&2; exit 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	commands, err := LoadCommands(commandsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := NewRunner(filesys.MakeFsOnDisk(), KustomizeExec, KustomizeFlags{}, nil, log.New(io.Discard, "", 0))
+
+	_, commandErr := runner.Command(context.Background(), commands[0], filepath.Join(root, "envs", "prod", "jsonnet"), root, "prod/jsonnet")
+	if !IsRenderFailure(commandErr) {
+		t.Fatalf("Expected a failed build command to fail only its render, got %v", commandErr)
+	}
+
+	// the app's timeout, as set for each app by kubediff from BUILD_TIMEOUT
+	ctx, cancel := BuildContext(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, timeoutErr := runner.Kustomize(ctx, "prod/slow", filepath.Join(root, "envs", "prod", "slow"))
+	if !IsRenderFailure(timeoutErr) {
+		t.Fatalf("Expected a build that timed out to fail only its render, got %v", timeoutErr)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the build to be killed at its timeout, took %s", elapsed)
+	}
+
+	section := FailedRendersSection([]FailedRender{
+		{AppPath: "prod/jsonnet", Err: commandErr},
+		{AppPath: "prod/slow", Err: timeoutErr},
+		{AppPath: "prod/chart", Err: &UnavailableError{Reason: "chart redis isn't available locally"}},
+	})
+	for _, expected := range []string{
+		"Failed renders: 3 apps",
+		"**prod/jsonnet**: the build command failed with `exit status 1`",
+		"```\nmain.jsonnet:2 unexpected end of file\n```",
+		"**prod/slow**: `kustomize build " + filepath.Join(root, "envs", "prod", "slow") + "` timed out after 200ms",
+		"```\npulling chart\n```",
+		"**prod/chart**: chart redis isn't available locally\n",
+	} {
+		if !strings.Contains(section.Title+"\n"+section.Body, expected) {
+			t.Errorf("Expected the section to contain %q, got:\n%v\n%v", expected, section.Title, section.Body)
+		}
+	}
+}
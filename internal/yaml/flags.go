@@ -0,0 +1,178 @@
+package yaml
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyclingwithelephants/kubediff/internal/report"
+	"github.com/cyclingwithelephants/kubediff/internal/utils"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// DefaultKustomizeFlags match Argo CD with `kustomize.buildOptions: --enable-helm`,
+// which kustomizations with helmCharts need
+var DefaultKustomizeFlags = []string{"--enable-helm"}
+
+// KustomizeFlags are the `kustomize build` flags each app is built with
+type KustomizeFlags struct {
+	Global []string
+	PerApp []AppFlags // the first whose pattern matches an app replaces Global
+}
+
+// AppFlags are the flags the apps matching a pattern are built with
+type AppFlags struct {
+	Apps  string
+	Flags []string
+}
+
+// ParseAppFlags parses a comma separated list of pattern=flags pairs, e.g.
+// legacy/**=--load-restrictor LoadRestrictionsNone --enable-helm
+func ParseAppFlags(value string) ([]AppFlags, error) {
+	appFlags := []AppFlags{}
+	for _, item := range utils.AsList(value) {
+		apps, flags, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("kustomize flags %q must be given as pattern=flags", item)
+		}
+		appFlags = append(appFlags, AppFlags{Apps: strings.TrimSpace(apps), Flags: strings.Fields(flags)})
+	}
+	return appFlags, nil
+}
+
+// For returns the flags an app is built with
+func (K KustomizeFlags) For(appPath string) []string {
+	for _, appFlags := range K.PerApp {
+		if utils.GlobMatch(appFlags.Apps, appPath) {
+			return appFlags.Flags
+		}
+	}
+	return K.Global
+}
+
+// Section lists the apps built with flags other than the global ones,
+// given the flags each app was built with
+func (K KustomizeFlags) Section(built map[string][]string) (report.Section, bool) {
+	global := strings.Join(K.Global, " ")
+	rows := [][]string{}
+	for _, appPath := range sortedAppPaths(built) {
+		if flags := strings.Join(built[appPath], " "); flags != global {
+			rows = append(rows, []string{report.Code(appPath), report.Code(flags)})
+		}
+	}
+	if len(rows) == 0 && global == strings.Join(DefaultKustomizeFlags, " ") {
+		return report.Section{}, false
+	}
+	body := fmt.Sprintf("Apps are built with `kustomize build %s`.\n", global)
+	if len(rows) > 0 {
+		body += "\n" + report.Table([]string{"App", "Flags"}, rows)
+	}
+	return report.Section{
+		Title: "Kustomize build flags",
+		Body:  body,
+	}, true
+}
+
+func sortedAppPaths(built map[string][]string) []string {
+	appPaths := []string{}
+	for appPath := range built {
+		appPaths = append(appPaths, appPath)
+	}
+	sort.Strings(appPaths)
+	return appPaths
+}
+
+// helmCommand finds the helm command that `kustomize build` inflates charts with given its flags,
+// which is "" when they don't set one, and whether they enable helm at all
+func helmCommand(flags []string) (string, bool) {
+	command, enabled := "", false
+	for i, flag := range flags {
+		name, value, hasValue := strings.Cut(flag, "=")
+		switch {
+		case name == "--enable-helm":
+			enabled = true
+		case name == "--helm-command" && hasValue:
+			command = value
+		case name == "--helm-command" && i+1 < len(flags):
+			command = flags[i+1]
+		}
+	}
+	return command, enabled
+}
+
+// krustyOptions translates `kustomize build` flags into the options of an in process build,
+// as kustomize itself does
+func krustyOptions(flags []string) (*krusty.Options, error) {
+	options := krusty.MakeDefaultOptions()
+	options.Reorder = krusty.ReorderOptionUnspecified
+	enableHelm, enablePlugins := false, false
+	helmCommand := "helm"
+	fnOptions := types.FnPluginLoadingOptions{}
+
+	for i := 0; i < len(flags); i++ {
+		name, value, hasValue := strings.Cut(flags[i], "=")
+		// flags that take a value may have it as the next argument
+		nextValue := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(flags) {
+				return "", fmt.Errorf("kustomize flag %s needs a value", name)
+			}
+			i++
+			return flags[i], nil
+		}
+		var err error
+		switch name {
+		case "--enable-helm":
+			enableHelm = true
+		case "--helm-command":
+			helmCommand, err = nextValue()
+		case "--enable-alpha-plugins":
+			enablePlugins = true
+		case "--enable-exec":
+			fnOptions.EnableExec = true
+		case "--enable-star":
+			fnOptions.EnableStar = true
+		case "--network":
+			fnOptions.Network = true
+		case "--enable-managedby-label":
+			options.AddManagedbyLabel = true
+		case "--load-restrictor":
+			value, err = nextValue()
+			switch value {
+			case types.LoadRestrictionsNone.String():
+				options.LoadRestrictions = types.LoadRestrictionsNone
+			case types.LoadRestrictionsRootOnly.String():
+				options.LoadRestrictions = types.LoadRestrictionsRootOnly
+			default:
+				err = fmt.Errorf("unknown load restrictor %q", value)
+			}
+		case "--reorder":
+			value, err = nextValue()
+			switch value {
+			case "legacy":
+				options.Reorder = krusty.ReorderOptionLegacy
+			case "none":
+				options.Reorder = krusty.ReorderOptionNone
+			default:
+				err = fmt.Errorf("unknown reorder option %q", value)
+			}
+		default:
+			err = fmt.Errorf("kustomize flag %s isn't supported in process, build with the kustomize binary instead", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if enablePlugins {
+		options.PluginConfig = types.EnabledPluginConfig(types.BploUseStaticallyLinked)
+		options.PluginConfig.FnpLoadingOptions = fnOptions
+	}
+	// enabling plugins enables helm too, which kustomize only leaves on with --enable-helm
+	options.PluginConfig.HelmConfig.Enabled = enableHelm
+	options.PluginConfig.HelmConfig.Command = helmCommand
+	return options, nil
+}
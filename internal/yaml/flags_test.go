@@ -0,0 +1,49 @@
+package yaml
+
+import (
+	"testing"
+)
+
+func TestKrustyOptions_Helm(t *testing.T) {
+	testCases := []struct {
+		name            string
+		flags           []string
+		expectedEnabled bool
+		expectedCommand string
+	}{
+		{
+			name:            "Case 1: helm enabled",
+			flags:           []string{"--enable-helm"},
+			expectedEnabled: true,
+			expectedCommand: "helm",
+		},
+		{
+			name:            "Case 2: plugins without helm",
+			flags:           []string{"--enable-alpha-plugins", "--enable-exec"},
+			expectedEnabled: false,
+			expectedCommand: "helm",
+		},
+		{
+			name:            "Case 3: plugins with helm and its command",
+			flags:           []string{"--enable-alpha-plugins", "--enable-helm", "--helm-command=helm3"},
+			expectedEnabled: true,
+			expectedCommand: "helm3",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			options, err := krustyOptions(testCase.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			helmConfig := options.PluginConfig.HelmConfig
+			if helmConfig.Enabled != testCase.expectedEnabled || helmConfig.Command != testCase.expectedCommand {
+				t.Errorf("Expected helm enabled %v with %q, got %v with %q", testCase.expectedEnabled, testCase.expectedCommand, helmConfig.Enabled, helmConfig.Command)
+			}
+			// the runner agrees with kustomize on whether helm is enabled
+			if _, enabled := helmCommand(testCase.flags); enabled != helmConfig.Enabled {
+				t.Errorf("Expected helmCommand to report helm enabled %v, got %v", helmConfig.Enabled, enabled)
+			}
+		})
+	}
+}
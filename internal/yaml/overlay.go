@@ -0,0 +1,120 @@
+package yaml
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	goyaml "gopkg.in/yaml.v3"
+)
+
+// KustomizeOverlay builds a directory wrapped in a temporary overlay, so that
+// GitOps controllers' options (name prefixes, images, patches, ...) can be applied
+// without modifying the checkout. Entries in overlay are written verbatim, except
+// components which are given relative to directory.
+// A directory without a kustomization is treated as a flat list of manifests.
+func (R *Runner) KustomizeOverlay(ctx context.Context, appPath, directory string, overlay map[string]interface{}) (string, error) {
+	overlayDir, err := os.MkdirTemp("", "kubediff-overlay-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(overlayDir)
+
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return "", err
+	}
+
+	resource := ""
+	if HasKustomization(absDir) {
+		// kustomize refuses absolute paths to other kustomization roots
+		resource, err = filepath.Rel(overlayDir, absDir)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		manifests, err := ConcatManifests(absDir, true, nil)
+		if err != nil {
+			return "", err
+		}
+		resource = "manifests.yaml"
+		err = os.WriteFile(filepath.Join(overlayDir, resource), []byte(manifests), 0o644)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	kustomization := map[string]interface{}{}
+	for key, value := range overlay {
+		kustomization[key] = value
+	}
+	kustomization["apiVersion"] = "kustomize.config.k8s.io/v1beta1"
+	kustomization["kind"] = "Kustomization"
+	kustomization["resources"] = []string{resource}
+	if components, ok := overlay["components"].([]string); ok {
+		relative := []string{}
+		for _, component := range components {
+			componentPath, err := filepath.Rel(overlayDir, filepath.Join(absDir, component))
+			if err != nil {
+				return "", err
+			}
+			relative = append(relative, componentPath)
+		}
+		kustomization["components"] = relative
+	}
+
+	content, err := goyaml.Marshal(kustomization)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(overlayDir, "kustomization.yaml"), content, 0o644)
+	if err != nil {
+		return "", err
+	}
+	R.logger.Println("building overlay for directory:", directory)
+	return R.Kustomize(ctx, appPath, overlayDir)
+}
+
+// ConcatManifests joins every yaml and json file in a directory into a single
+// multi-document string. filter, when set, is given each file path relative to
+// directory and decides whether it is included.
+func ConcatManifests(directory string, recurse bool, filter func(relative string) bool) (string, error) {
+	documents := []string{}
+	err := filepath.WalkDir(directory, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != directory && !recurse {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		extension := strings.ToLower(filepath.Ext(filePath))
+		if extension != ".yaml" && extension != ".yml" && extension != ".json" {
+			return nil
+		}
+		relative, err := filepath.Rel(directory, filePath)
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(relative) {
+			return nil
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(content)) == "" {
+			return nil
+		}
+		documents = append(documents, strings.TrimSuffix(string(content), "\n")+"\n")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(documents, "---\n"), nil
+}
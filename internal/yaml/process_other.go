@@ -0,0 +1,21 @@
+//go:build !unix
+
+package yaml
+
+import (
+	"os/exec"
+)
+
+// killProcessGroup leaves the default of killing only the command itself where there are no process groups
+func killProcessGroup(cmd *exec.Cmd) {}
+
+// trackedHelm runs helm as it is where there's no sh to track it with, so an abandoned build's helm runs to completion
+type trackedHelm struct{}
+
+func newTrackedHelm(command string) (*trackedHelm, string, error) {
+	return &trackedHelm{}, command, nil
+}
+
+func (T *trackedHelm) kill() {}
+
+func (T *trackedHelm) remove() {}
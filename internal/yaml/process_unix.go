@@ -0,0 +1,84 @@
+//go:build unix
+
+package yaml
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// killProcessGroup runs a command in a process group of its own, and kills the whole group
+// when the command is cancelled, so that children such as the helm run by kustomize die with it
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// trackedHelm is a script for in process builds to run helm through, as kustomize runs it
+// without a context. Each helm the script starts is recorded by its pid in the script's
+// directory while it runs, so that it can be killed when the build is abandoned.
+type trackedHelm struct {
+	dir string
+}
+
+// trackedHelmScript runs helm in the background so that its pid is known before it does anything,
+// killing it if the pid can't be recorded because the build was abandoned in the meantime
+const trackedHelmScript = `#!/bin/sh
+%s "$@" &
+pid=$!
+echo $pid > %s/$pid || { kill -9 $pid; exit 1; }
+wait $pid
+status=$?
+rm -f %s/$pid
+exit $status
+`
+
+// newTrackedHelm writes a script that runs the helm command, returning it along with the path to run it by
+func newTrackedHelm(command string) (*trackedHelm, string, error) {
+	dir, err := os.MkdirTemp("", "kubediff-helm-")
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating directory for helm: %w", err)
+	}
+	script := filepath.Join(dir, "helm")
+	content := fmt.Sprintf(trackedHelmScript, shellQuote(command), shellQuote(dir), shellQuote(dir))
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("error writing script for helm: %w", err)
+	}
+	return &trackedHelm{dir: dir}, script, nil
+}
+
+// kill kills every helm the script is running and stops it from starting any more
+func (T *trackedHelm) kill() {
+	// once moved, the script can't be run and no more pids can be recorded, so none are missed
+	abandoned := T.dir + "-abandoned"
+	if err := os.Rename(T.dir, abandoned); err != nil {
+		return
+	}
+	T.dir = abandoned
+	entries, err := os.ReadDir(abandoned)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+// remove removes the script once the build is done with it
+func (T *trackedHelm) remove() {
+	_ = os.RemoveAll(T.dir)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
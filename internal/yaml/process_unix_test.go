@@ -0,0 +1,111 @@
+//go:build linux
+
+package yaml
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// alive reports whether a process is running, counting zombies left unreaped as dead
+func alive(pid int) bool {
+	if err := syscall.Kill(pid, 0); err != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// the state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestRunner_Command_KillsProcessGroup(t *testing.T) {
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{"envs/prod/app/main.yaml": "kind: ConfigMap\n"})
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	commandsPath := filepath.Join(t.TempDir(), "commands.yaml")
+	// the command starts a child of its own, then waits on it
+	commands := "- apps: prod/*\n  command: sleep 30 & echo $! > " + pidFile + "; wait\n  timeout: 200ms\n"
+	if err := os.WriteFile(commandsPath, []byte(commands), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCommands(commandsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := NewRunner(filesys.MakeFsOnDisk(), KustomizeExec, KustomizeFlags{}, nil, log.New(io.Discard, "", 0))
+	_, err = runner.Command(context.Background(), loaded[0], filepath.Join(root, "envs", "prod", "app"), root, "prod/app")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("Expected the command to time out, got %v", err)
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the kill is asynchronous, so allow it a moment to land
+	for deadline := time.Now().Add(2 * time.Second); alive(pid) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if alive(pid) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("Expected the command's child %d to be killed with it", pid)
+	}
+}
+
+func TestRunner_Kustomize_KillsAbandonedHelm(t *testing.T) {
+	// helm records its pid, then hangs rendering the chart
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	binDir := t.TempDir()
+	helm := "#!/bin/sh\ncase \"$1\" in\nversion) echo v3.12.0 ;;\ntemplate) echo $$ > " + pidFile + "; exec sleep 30 ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(binDir, "helm"), []byte(helm), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"app/kustomization.yaml":     "helmCharts:\n- name: web\n  releaseName: web\n",
+		"app/charts/web/Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"app/charts/web/values.yaml": "",
+	})
+
+	runner := NewRunner(filesys.MakeFsOnDisk(), KustomizeLibrary, KustomizeFlags{Global: DefaultKustomizeFlags}, nil, log.New(io.Discard, "", 0))
+	ctx, cancel := BuildContext(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := runner.Kustomize(ctx, "app", filepath.Join(root, "app"))
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("Expected the build to time out, got %v", err)
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); alive(pid) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if alive(pid) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("Expected the helm of the abandoned build %d to be killed", pid)
+	}
+}
@@ -0,0 +1,423 @@
+package yaml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// The ways kustomizations can be built
+const (
+	KustomizeLibrary = "library" // in process, with the kustomize API
+	KustomizeExec    = "exec"    // with the kustomize binary on PATH
+)
+
+// KustomizationNames are every file name that kustomize recognises as a kustomization
+var KustomizationNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// Runner executes the external tools used to render manifests.
+// It is shared between all builders so that every render is run the same way.
+type Runner struct {
+	fSys          filesys.FileSystem
+	kustomizeMode string
+	flags         KustomizeFlags
+	appFlags      map[string][]string
+	cache         *Cache
+	binaries      map[string]string
+	versions      map[string]string
+	used          map[string]bool
+	stats         RenderStats
+	logger        *log.Logger
+}
+
+// RenderStats counts the renders of a run and the time spent on them
+type RenderStats struct {
+	Renders     int
+	CacheHits   int
+	CacheMisses int
+	Duration    time.Duration
+}
+
+// NewRunner creates a Runner, serving renders from cache when it is not nil.
+// Kustomizations are read from fSys, which may be in memory when kustomizeMode is
+// KustomizeLibrary; the kustomize and helm binaries only ever read from disk.
+func NewRunner(fSys filesys.FileSystem, kustomizeMode string, flags KustomizeFlags, cache *Cache, logger *log.Logger) *Runner {
+	return &Runner{
+		fSys:          fSys,
+		kustomizeMode: kustomizeMode,
+		flags:         flags,
+		appFlags:      map[string][]string{},
+		cache:         cache,
+		binaries:      map[string]string{},
+		versions:      map[string]string{},
+		used:          map[string]bool{},
+		logger:        logger,
+	}
+}
+
+// Stats returns the renders run so far
+func (R *Runner) Stats() RenderStats {
+	return R.stats
+}
+
+// AppFlags returns the kustomize build flags each app was built with so far
+func (R *Runner) AppFlags() map[string][]string {
+	return R.appFlags
+}
+
+// Kustomize runs `kustomize build` against a directory containing a kustomization.yaml,
+// with the flags configured for the app it belongs to
+func (R *Runner) Kustomize(ctx context.Context, appPath, directory string) (string, error) {
+	if !R.fSys.Exists(directory) {
+		return "", fmt.Errorf("directory %s does not exist", directory)
+	}
+	if !hasKustomization(R.fSys, directory) {
+		return "", fmt.Errorf("directory %s does not contain kustomization.yaml", directory)
+	}
+	flags := R.flags.For(appPath)
+	R.appFlags[appPath] = flags
+	R.logger.Printf("building app %s with kustomize flags: %s", appPath, strings.Join(flags, " "))
+
+	tool, build := "kustomize", R.kustomizeExec
+	if R.kustomizeMode == KustomizeLibrary {
+		tool, build = "krusty", R.kustomizeLibrary
+	}
+	key := func(inputs inputHash) error {
+		// charts are inflated by helm, whose version changes the render as much as kustomize's does
+		if command, enabled := helmCommand(flags); enabled {
+			inputs.text(R.helmIdentity(ctx, command))
+		}
+		return inputs.kustomization(directory, map[string]bool{})
+	}
+	args := append([]string{"build"}, flags...)
+	return R.cached(ctx, directory, tool, args, R.fSys, key, func() (string, error) {
+		return build(ctx, directory, flags)
+	})
+}
+
+func (R *Runner) kustomizeExec(ctx context.Context, directory string, flags []string) (string, error) {
+	R.logger.Println("running kustomize build on directory:", directory)
+	args := append([]string{"build"}, flags...)
+	if helm, ok := R.pinnedHelm(flags); ok {
+		args = append(args, "--helm-command", helm)
+	}
+	args = append(args, directory)
+	out, err := R.run(ctx, "kustomize", args...)
+	if err != nil {
+		return "", fmt.Errorf("kustomize build failed: %w", err)
+	}
+	return out, nil
+}
+
+// kustomizeLibrary builds a kustomization in process, with the options kustomize would use for the flags
+func (R *Runner) kustomizeLibrary(ctx context.Context, directory string, flags []string) (string, error) {
+	R.logger.Println("building kustomization in process on directory:", directory)
+	options, err := krustyOptions(flags)
+	if err != nil {
+		return "", err
+	}
+	if helm, ok := R.pinnedHelm(flags); ok {
+		options.PluginConfig.HelmConfig.Command = helm
+	}
+	// an in process build can't be stopped, so it is abandoned instead when the context is done,
+	// killing any helm it is running
+	if _, enabled := helmCommand(flags); enabled {
+		helm, script, err := newTrackedHelm(options.PluginConfig.HelmConfig.Command)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if ctx.Err() != nil {
+				helm.kill()
+			}
+			helm.remove()
+		}()
+		options.PluginConfig.HelmConfig.Command = script
+	}
+
+	return abandonable(ctx, "kustomize build "+directory, func() (string, error) {
+		resources, err := krusty.MakeKustomizer(options).Run(R.fSys, directory)
+		if err != nil {
+			return "", fmt.Errorf("kustomize build failed: %w", err)
+		}
+		out, err := resources.AsYaml()
+		if err != nil {
+			return "", fmt.Errorf("kustomize build failed: %w", err)
+		}
+		return string(out), nil
+	})
+}
+
+// InProcess runs a render another package does in process, such as evaluating jsonnet, counting
+// it in the renders of the run. It is abandoned like an in process kustomize build when ctx is done.
+func (R *Runner) InProcess(ctx context.Context, command string, render func() (string, error)) (string, error) {
+	start := time.Now()
+	defer func() {
+		R.stats.Renders++
+		R.stats.Duration += time.Since(start)
+	}()
+	return abandonable(ctx, command, render)
+}
+
+// abandonable runs a render that can't be stopped, returning as soon as ctx is done, with a
+// TimeoutError if it timed out, while the render carries on until it finishes in the background
+func abandonable(ctx context.Context, command string, render func() (string, error)) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := render()
+		done <- result{out: out, err: err}
+	}()
+	select {
+	case rendered := <-done:
+		return rendered.out, rendered.err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", &TimeoutError{Command: command, Timeout: buildTimeout(ctx)}
+		}
+		return "", fmt.Errorf("%s: %w", command, ctx.Err())
+	}
+}
+
+// pinnedHelm returns the pinned helm binary for kustomize to inflate charts with, unless
+// the build flags don't enable helm or choose a helm command of their own
+func (R *Runner) pinnedHelm(flags []string) (string, bool) {
+	binary, pinned := R.binaries["helm"]
+	command, enabled := helmCommand(flags)
+	if !pinned || !enabled || command != "" {
+		return "", false
+	}
+	return binary, true
+}
+
+// helmIdentity describes the helm binary a kustomization's charts are inflated with for
+// its render cache key, by path and version, given the command its flags set, if any
+func (R *Runner) helmIdentity(ctx context.Context, command string) string {
+	var version string
+	var err error
+	if command == "" {
+		command = R.binary("helm")
+		version, err = R.version(ctx, "helm")
+	} else {
+		version, err = R.binaryVersion(ctx, "helm", command)
+	}
+	// without helm, kustomizations with charts fail to build and so are never cached
+	if err != nil {
+		version = "unavailable"
+		if command == R.binary("helm") {
+			R.versions["helm"] = version
+		}
+	}
+	return command + " " + version
+}
+
+// Helm runs `helm template` with the given arguments
+func (R *Runner) Helm(ctx context.Context, args ...string) (string, error) {
+	// local paths, such as charts and values files, are keyed by their content as
+	// temporary values files are named differently on every run
+	key := func(inputs inputHash) error {
+		if err := pinnedChart(args); err != nil {
+			return err
+		}
+		for _, arg := range args {
+			if _, err := os.Stat(arg); err != nil {
+				inputs.text(arg)
+				continue
+			}
+			inputs.text("<path>")
+			if err := inputs.tree(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	description := "helm template " + strings.Join(args, " ")
+	return R.cached(ctx, description, "helm", []string{"template"}, filesys.MakeFsOnDisk(), key, func() (string, error) {
+		R.logger.Println("running helm template with args:", strings.Join(args, " "))
+		out, err := R.run(ctx, "helm", append([]string{"template"}, args...)...)
+		if err != nil {
+			return "", fmt.Errorf("helm template failed: %w", err)
+		}
+		return out, nil
+	})
+}
+
+// pinnedChart fails with an unpinnedError when `helm template` arguments pull a chart from a
+// repository, with --repo or an oci:// reference, without an exact --version
+func pinnedChart(args []string) error {
+	var repo, version string
+	for i, arg := range args {
+		value := ""
+		if i+1 < len(args) {
+			value = args[i+1]
+		}
+		switch {
+		case arg == "--repo":
+			repo = value
+		case strings.HasPrefix(arg, "--repo="):
+			repo = strings.TrimPrefix(arg, "--repo=")
+		case arg == "--version":
+			version = value
+		case strings.HasPrefix(arg, "--version="):
+			version = strings.TrimPrefix(arg, "--version=")
+		case strings.HasPrefix(arg, "oci://"):
+			repo = arg
+		}
+	}
+	if repo != "" && !pinnedVersion.MatchString(version) {
+		return &unpinnedError{input: "chart from " + repo}
+	}
+	return nil
+}
+
+// cached serves a render from the cache if its key has been rendered before, and
+// otherwise renders it and stores the result. The key covers the tool, its version and
+// arguments, and whatever inputs hashes, so changing any of them invalidates the entry.
+func (R *Runner) cached(
+	ctx context.Context,
+	description string,
+	tool string,
+	args []string,
+	fSys filesys.FileSystem,
+	inputs func(inputs inputHash) error,
+	render func() (string, error),
+) (string, error) {
+	start := time.Now()
+	R.used[tool] = true
+	defer func() {
+		R.stats.Renders++
+		R.stats.Duration += time.Since(start)
+	}()
+	if R.cache == nil {
+		return render()
+	}
+
+	key, err := R.key(ctx, tool, args, fSys, inputs)
+	if err != nil {
+		R.logger.Println("not caching render of", description+":", err)
+		return render()
+	}
+	if rendered, ok := R.cache.Get(key); ok {
+		R.stats.CacheHits++
+		R.logger.Printf("render cache hit for %s, took %s", description, time.Since(start))
+		return rendered, nil
+	}
+	rendered, err := render()
+	if err != nil {
+		return "", err
+	}
+	R.stats.CacheMisses++
+	R.logger.Printf("render cache miss for %s, took %s", description, time.Since(start))
+	if err := R.cache.Put(key, rendered); err != nil {
+		R.logger.Println("error writing render cache:", err)
+	}
+	return rendered, nil
+}
+
+func (R *Runner) key(ctx context.Context, tool string, args []string, fSys filesys.FileSystem, inputs func(inputs inputHash) error) (string, error) {
+	version, err := R.version(ctx, tool)
+	if err != nil {
+		return "", err
+	}
+	hash := newInputHash(fSys)
+	hash.text(tool)
+	hash.text(version)
+	for _, arg := range args {
+		hash.text(arg)
+	}
+	if err := inputs(hash); err != nil {
+		return "", err
+	}
+	return hash.key(), nil
+}
+
+func (R *Runner) run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, R.binary(name), args...)
+	out, stderr, err := R.execute(ctx, cmd)
+	R.logger.Println(stderr)
+	if err != nil {
+		// timeouts and cancellations are told apart from failures by their type
+		var timeoutErr *TimeoutError
+		if stderr == "" || errors.As(err, &timeoutErr) || errors.Is(err, context.Canceled) {
+			return "", err
+		}
+		return "", errors.New(stderr)
+	}
+	return out, nil
+}
+
+// execute runs a command created with ctx, killing its process group if ctx is done first,
+// in which case the error is a TimeoutError holding whatever it wrote to stderr so far,
+// or the context's error if it was cancelled
+func (R *Runner) execute(ctx context.Context, cmd *exec.Cmd) (string, string, error) {
+	var out bytes.Buffer
+	var outErr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &outErr
+	killProcessGroup(cmd)
+	// don't wait forever on output held open by processes that escaped the group
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return "", outErr.String(), &TimeoutError{Command: strings.Join(cmd.Args, " "), Timeout: buildTimeout(ctx), Stderr: outErr.String()}
+	case context.Canceled:
+		return "", outErr.String(), fmt.Errorf("%s: %w", strings.Join(cmd.Args, " "), context.Canceled)
+	}
+	return out.String(), outErr.String(), err
+}
+
+// TimeoutError is returned by a build that ran past its deadline
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+	Stderr  string // what the build wrote to stderr before it was killed
+}
+
+func (T *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", T.Command, T.Timeout)
+}
+
+// buildTimeoutKey holds the timeout of a context made by BuildContext
+type buildTimeoutKey struct{}
+
+// BuildContext bounds the builds of an app, which fail with a TimeoutError once it times out
+func BuildContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return context.WithValue(ctx, buildTimeoutKey{}, timeout), cancel
+}
+
+// buildTimeout is the timeout a context was given by BuildContext, for reporting builds that
+// run past it, or 0 if it has none
+func buildTimeout(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(buildTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// HasKustomization reports whether a directory is a kustomization root,
+// accepting every file name that kustomize itself recognises.
+func HasKustomization(directory string) bool {
+	return hasKustomization(filesys.MakeFsOnDisk(), directory)
+}
+
+func hasKustomization(fSys filesys.FileSystem, directory string) bool {
+	for _, name := range KustomizationNames {
+		if fSys.Exists(path.Join(directory, name)) {
+			return true
+		}
+	}
+	return false
+}
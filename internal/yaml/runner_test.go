@@ -0,0 +1,206 @@
+package yaml
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cyclingwithelephants/kubediff/internal/testutil"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestRunner_Kustomize(t *testing.T) {
+	fSys := filesys.MakeFsInMemory()
+	files := map[string]string{
+		"/repo/base/kustomization.yaml":   "resources: [configmap.yaml]\n",
+		"/repo/base/configmap.yaml":       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  debug: \"false\"\n",
+		"/repo/prod/kustomization.yaml":   "resources: [../base]\nnamespace: prod\nnamePrefix: prod-\n",
+		"/repo/legacy/kustomization.yaml": "resources: [../base/configmap.yaml]\n",
+	}
+	for name, content := range files {
+		if err := fSys.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flags := KustomizeFlags{
+		Global: DefaultKustomizeFlags,
+		PerApp: []AppFlags{{Apps: "legacy", Flags: []string{"--load-restrictor", "LoadRestrictionsNone"}}},
+	}
+
+	testCases := []struct {
+		name      string
+		appPath   string
+		directory string
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:      "Case 1: overlay of a base",
+			appPath:   "prod",
+			directory: "/repo/prod",
+			expected:  "apiVersion: v1\ndata:\n  debug: \"false\"\nkind: ConfigMap\nmetadata:\n  name: prod-app\n  namespace: prod\n",
+		},
+		{
+			name:      "Case 2: directory without a kustomization",
+			appPath:   "prod",
+			directory: "/repo",
+			expectErr: true,
+		},
+		{
+			name:      "Case 3: file outside the kustomization root, with the app's load restrictor",
+			appPath:   "legacy",
+			directory: "/repo/legacy",
+			expected:  "apiVersion: v1\ndata:\n  debug: \"false\"\nkind: ConfigMap\nmetadata:\n  name: app\n",
+		},
+		{
+			name:      "Case 4: file outside the kustomization root, with the global flags",
+			appPath:   "other",
+			directory: "/repo/legacy",
+			expectErr: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runner := NewRunner(fSys, KustomizeLibrary, flags, nil, log.New(io.Discard, "", 0))
+			actual, err := runner.Kustomize(context.Background(), testCase.appPath, testCase.directory)
+			if (err != nil) != testCase.expectErr {
+				t.Fatalf("Expected error to be %v, got %v", testCase.expectErr, err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunner_Pin(t *testing.T) {
+	// fake binaries print their version like the real ones
+	toolsDir := t.TempDir()
+	binaries := map[string]string{
+		"helm-v3.12.0": "echo v3.12.0+gc9f554d",
+		"kustomize":    "echo '{Version:kustomize/v4.5.7 GitCommit:56d82a8 BuildDate:2022-08-02T16:35:54Z}'",
+	}
+	for name, script := range binaries {
+		if err := os.WriteFile(filepath.Join(toolsDir, name), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", t.TempDir())
+
+	testCases := []struct {
+		name          string
+		kustomizeMode string
+		required      map[string]string
+		expected      []ToolVersion
+		expectErr     bool
+	}{
+		{
+			name:          "Case 1: pinned binaries in the tools directory",
+			kustomizeMode: KustomizeExec,
+			required:      map[string]string{"helm": "3.12.0", "kustomize": "v4.5.7"},
+			expected:      []ToolVersion{{Name: "helm", Version: "v3.12.0"}, {Name: "kustomize", Version: "v4.5.7"}},
+		},
+		{
+			name:          "Case 2: no binary at the required version",
+			kustomizeMode: KustomizeExec,
+			required:      map[string]string{"kustomize": "v5.0.1"},
+			expectErr:     true,
+		},
+		{
+			name:          "Case 3: kustomize can't be pinned when built in process",
+			kustomizeMode: KustomizeLibrary,
+			required:      map[string]string{"kustomize": "v5.0.1"},
+			expectErr:     true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runner := NewRunner(filesys.MakeFsOnDisk(), testCase.kustomizeMode, KustomizeFlags{}, nil, log.New(io.Discard, "", 0))
+			err := runner.Pin(context.Background(), testCase.required, toolsDir)
+			if (err != nil) != testCase.expectErr {
+				t.Fatalf("Expected error to be %v, got %v", testCase.expectErr, err)
+			}
+			if testCase.expectErr {
+				return
+			}
+			for tool := range testCase.required {
+				runner.used[tool] = true
+			}
+			if actual := runner.Versions(context.Background()); !reflect.DeepEqual(actual, testCase.expected) {
+				t.Errorf("Expected versions %+v, got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunner_Kustomize_PinnedHelm(t *testing.T) {
+	// kustomize on PATH prints its arguments, helm on PATH is at the wrong version
+	binDir := t.TempDir()
+	toolsDir := t.TempDir()
+	binaries := map[string]string{
+		filepath.Join(binDir, "kustomize"): `echo "# $*"`,
+		filepath.Join(binDir, "helm"):      "echo v3.11.0",
+		filepath.Join(toolsDir, "helm-v3.12.0"): `case "$1" in
+version) echo v3.12.0+gc9f554d ;;
+template) printf 'apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\n' "$2" ;;
+esac`,
+	}
+	for name, script := range binaries {
+		if err := os.WriteFile(name, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	root := testutil.WriteFiles(t, t.TempDir(), map[string]string{
+		"app/kustomization.yaml":     "helmCharts:\n- name: web\n  releaseName: pinned\n",
+		"app/charts/web/Chart.yaml":  "apiVersion: v2\nname: web\nversion: 0.1.0\n",
+		"app/charts/web/values.yaml": "",
+	})
+	helmBinary := filepath.Join(toolsDir, "helm-v3.12.0")
+
+	testCases := []struct {
+		name          string
+		kustomizeMode string
+		flags         []string
+		expected      string
+	}{
+		{
+			name:          "Case 1: in process",
+			kustomizeMode: KustomizeLibrary,
+			flags:         DefaultKustomizeFlags,
+			expected:      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: pinned\n",
+		},
+		{
+			name:          "Case 2: with the kustomize binary",
+			kustomizeMode: KustomizeExec,
+			flags:         DefaultKustomizeFlags,
+			expected:      "# build --enable-helm --helm-command " + helmBinary + " " + filepath.Join(root, "app") + "\n",
+		},
+		{
+			name:          "Case 3: the app's own helm command",
+			kustomizeMode: KustomizeExec,
+			flags:         []string{"--enable-helm", "--helm-command", "helm3"},
+			expected:      "# build --enable-helm --helm-command helm3 " + filepath.Join(root, "app") + "\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			runner := NewRunner(filesys.MakeFsOnDisk(), testCase.kustomizeMode, KustomizeFlags{Global: testCase.flags}, nil, log.New(io.Discard, "", 0))
+			if err := runner.Pin(context.Background(), map[string]string{"helm": "3.12.0"}, toolsDir); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := runner.Kustomize(context.Background(), "app", filepath.Join(root, "app"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", testCase.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package yaml
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// versionPattern finds a semantic version in the output of `kustomize version` or `helm version`,
+// which differ between releases, e.g. v5.0.1, {Version:kustomize/v4.5.7 ...} or v3.12.0+gc9f554d
+var versionPattern = regexp.MustCompile(`v?(\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?)`)
+
+// ToolVersion is the version of a tool used to render
+type ToolVersion struct {
+	Name    string
+	Version string
+}
+
+// Pin checks that the binaries run to render are at the required version of each tool,
+// falling back to a binary named <tool>-<version>, or <tool>, in toolsDir when the one
+// on PATH isn't. It fails when none is, as renders would differ from everyone else's.
+func (R *Runner) Pin(ctx context.Context, required map[string]string, toolsDir string) error {
+	tools := []string{}
+	for tool := range required {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	for _, tool := range tools {
+		want := normaliseVersion(required[tool])
+		if tool == "kustomize" && R.kustomizeMode == KustomizeLibrary {
+			return fmt.Errorf("kustomize %s is required, but kustomizations are built in process with the kustomize API, which can't be pinned", want)
+		}
+		candidates := []string{tool}
+		if toolsDir != "" {
+			candidates = append(candidates, filepath.Join(toolsDir, tool+"-"+want), filepath.Join(toolsDir, tool))
+		}
+		mismatches := []string{}
+		for _, candidate := range candidates {
+			version, err := R.binaryVersion(ctx, tool, candidate)
+			if err != nil {
+				mismatches = append(mismatches, fmt.Sprintf("%s: %v", candidate, err))
+				continue
+			}
+			if version != want {
+				mismatches = append(mismatches, fmt.Sprintf("%s is %s", candidate, version))
+				continue
+			}
+			R.logger.Printf("using %s %s at %s", tool, version, candidate)
+			R.binaries[tool] = candidate
+			R.versions[tool] = version
+			break
+		}
+		if R.versions[tool] != want {
+			return fmt.Errorf("%s %s is required, but %s", tool, want, strings.Join(mismatches, "; "))
+		}
+	}
+	return nil
+}
+
+// Versions lists the versions of the tools used to render so far
+func (R *Runner) Versions(ctx context.Context) []ToolVersion {
+	tools := []string{}
+	for tool := range R.used {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	versions := []ToolVersion{}
+	for _, tool := range tools {
+		version, err := R.version(ctx, tool)
+		if err != nil {
+			R.logger.Println("error finding tool version:", err)
+			version = "unknown"
+		}
+		name := tool
+		if tool == "krusty" {
+			name = "kustomize API"
+		}
+		versions = append(versions, ToolVersion{Name: name, Version: version})
+	}
+	return versions
+}
+
+// version finds the version of a tool once per run. The version of the kustomize
+// API built into kubediff is read from its build info
+func (R *Runner) version(ctx context.Context, tool string) (string, error) {
+	if version, ok := R.versions[tool]; ok {
+		return version, nil
+	}
+	if tool == "krusty" {
+		R.versions[tool] = "unknown"
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			for _, dependency := range buildInfo.Deps {
+				if dependency.Path == "sigs.k8s.io/kustomize/api" {
+					R.versions[tool] = dependency.Version
+				}
+			}
+		}
+		return R.versions[tool], nil
+	}
+	version, err := R.binaryVersion(ctx, tool, R.binary(tool))
+	if err != nil {
+		return "", err
+	}
+	R.versions[tool] = version
+	return version, nil
+}
+
+// binaryVersion asks a binary of a tool for its version
+func (R *Runner) binaryVersion(ctx context.Context, tool, binary string) (string, error) {
+	args := []string{"version"}
+	if tool == "helm" {
+		args = append(args, "--short")
+	}
+	out, err := R.run(ctx, binary, args...)
+	if err != nil {
+		return "", fmt.Errorf("finding %s version: %w", tool, err)
+	}
+	match := versionPattern.FindStringSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("finding %s version: no version in %q", tool, strings.TrimSpace(out))
+	}
+	return "v" + match[1], nil
+}
+
+// binary is the binary run for a tool, the one on PATH unless another was pinned
+func (R *Runner) binary(tool string) string {
+	if binary, ok := R.binaries[tool]; ok {
+		return binary
+	}
+	return tool
+}
+
+// normaliseVersion prefixes a version with v, as tools report them
+func normaliseVersion(version string) string {
+	return "v" + strings.TrimPrefix(strings.TrimSpace(version), "v")
+}